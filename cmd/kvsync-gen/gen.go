@@ -0,0 +1,997 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const generatedHeader = "// Code generated by kvsync-gen. DO NOT EDIT.\n"
+
+// Builtin types which are encoded without calling encoding.MarshalValue.
+var basicTypes = map[string]string{
+	"string": "string",
+	"bool":   "bool",
+	"int":    "int",
+	"int8":   "int",
+	"int16":  "int",
+	"int32":  "int",
+	"int64":  "int",
+	"uint":   "uint",
+	"uint8":  "uint",
+	"uint16": "uint",
+	"uint32": "uint",
+	"uint64": "uint",
+}
+
+var basicBits = map[string]int{
+	"int":    0,
+	"int8":   8,
+	"int16":  16,
+	"int32":  32,
+	"int64":  64,
+	"uint":   0,
+	"uint8":  8,
+	"uint16": 16,
+	"uint32": 32,
+	"uint64": 64,
+}
+
+// A stored struct field.
+type field struct {
+	name      string
+	static    string   // Static path of the field, or of the map
+	isMap     bool     // The field is a map stored with '{key}'
+	key       ast.Expr // Type of the map key
+	elem      ast.Expr // Type of the field, or of the map element
+	suffix    string   // Static path of the map element, after '{key}'
+	recursive bool     // The field or map element is a struct stored recursively
+	omitEmpty bool
+	keyWidth  int
+}
+
+type generator struct {
+	pkg     string
+	structs map[string]*ast.StructType
+	types   map[string]bool
+	custom  map[string]bool
+	imports map[string]string // Package name to import path
+	used    map[string]bool   // Package names used by the generated code
+	queue   []string
+	queued  map[string]bool
+	buf     bytes.Buffer
+}
+
+// Generate returns the encoding functions of the given types, declared in the package
+// stored in dir. The output file is ignored when parsing the package.
+func Generate(dir string, output string, typeNames []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	filter := func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != output
+	}
+	pkgs, err := parser.ParseDir(fset, dir, filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("Expected one package in '%s', found %d", dir, len(pkgs))
+	}
+
+	g := &generator{
+		structs: make(map[string]*ast.StructType),
+		types:   make(map[string]bool),
+		custom:  make(map[string]bool),
+		imports: make(map[string]string),
+		used:    make(map[string]bool),
+		queued:  make(map[string]bool),
+	}
+	for name, pkg := range pkgs {
+		g.pkg = name
+		g.parse(pkg)
+	}
+
+	for _, name := range typeNames {
+		if _, ok := g.structs[name]; !ok {
+			return nil, fmt.Errorf("Type '%s' is not a struct type declared in package '%s'", name, g.pkg)
+		}
+		g.enqueue(name)
+	}
+
+	for i := 0; i < len(g.queue); i++ {
+		name := g.queue[i]
+		if g.custom[name] {
+			return nil, fmt.Errorf("Type '%s' implements MarshalKV or UnmarshalKV", name)
+		}
+		fields, err := g.fields(name)
+		if err != nil {
+			return nil, err
+		}
+		g.generate(name, fields)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%s\npackage %s\n\n", generatedHeader, g.pkg)
+	out.WriteString("import (\n")
+	body := g.buf.String()
+	for _, p := range []string{"strconv", "strings"} {
+		if strings.Contains(body, p+".") {
+			fmt.Fprintf(&out, "\t%q\n", p)
+		}
+	}
+	var used []string
+	for name := range g.used {
+		used = append(used, name)
+	}
+	sort.Strings(used)
+	for _, name := range used {
+		p := g.imports[name]
+		if path.Base(p) == name {
+			fmt.Fprintf(&out, "\t%q\n", p)
+		} else {
+			fmt.Fprintf(&out, "\t%s %q\n", name, p)
+		}
+	}
+	out.WriteString("\n\t\"github.com/Oryon/kvsync/encoding\"\n)\n")
+	out.WriteString(body)
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid generated code: %v", err)
+	}
+	return src, nil
+}
+
+// Indexes the types, methods and imports of the package.
+func (g *generator) parse(pkg *ast.Package) {
+	for _, file := range pkg.Files {
+		for _, imp := range file.Imports {
+			p, _ := strconv.Unquote(imp.Path.Value)
+			name := path.Base(p)
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			g.imports[name] = p
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					g.types[ts.Name.Name] = true
+					if st, ok := ts.Type.(*ast.StructType); ok {
+						g.structs[ts.Name.Name] = st
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv == nil || len(d.Recv.List) != 1 {
+					continue
+				}
+				if d.Name.Name != "MarshalKV" && d.Name.Name != "UnmarshalKV" {
+					continue
+				}
+				t := d.Recv.List[0].Type
+				if star, ok := t.(*ast.StarExpr); ok {
+					t = star.X
+				}
+				if id, ok := t.(*ast.Ident); ok {
+					g.custom[id.Name] = true
+				}
+			}
+		}
+	}
+}
+
+func (g *generator) enqueue(name string) {
+	if !g.queued[name] {
+		g.queued[name] = true
+		g.queue = append(g.queue, name)
+	}
+}
+
+// Returns the name of the struct type declared in the package, possibly through a pointer.
+func (g *generator) localStruct(t ast.Expr) (string, bool) {
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	id, ok := t.(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+	_, ok = g.structs[id.Name]
+	return id.Name, ok
+}
+
+// Returns the class of a builtin basic type ("string", "bool", "int" or "uint"), if any.
+func (g *generator) basic(t ast.Expr) string {
+	id, ok := t.(*ast.Ident)
+	if !ok || g.types[id.Name] {
+		return ""
+	}
+	return basicTypes[id.Name]
+}
+
+// Returns a type expression, and records the imported packages it uses.
+func (g *generator) typeString(t ast.Expr) string {
+	ast.Inspect(t, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				g.used[id.Name] = true
+			}
+			return false
+		}
+		return true
+	})
+	return types.ExprString(t)
+}
+
+// Returns the error reported for a type which can't be stored by generated code.
+func (g *generator) checkType(t ast.Expr, typeName string, f *ast.Field) error {
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok && g.custom[id.Name] {
+		return fmt.Errorf("%s.%s: type '%s' implements MarshalKV or UnmarshalKV", typeName, fieldName(f), id.Name)
+	}
+	return nil
+}
+
+func fieldName(f *ast.Field) string {
+	if len(f.Names) != 0 {
+		return f.Names[0].Name
+	}
+	t := f.Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch e := t.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	}
+	return ""
+}
+
+// Returns the stored fields of a struct type.
+func (g *generator) fields(typeName string) ([]field, error) {
+	var fields []field
+	for _, f := range g.structs[typeName].Fields.List {
+		var names []string
+		for _, n := range f.Names {
+			names = append(names, n.Name)
+		}
+		if len(names) == 0 {
+			names = []string{fieldName(f)}
+		}
+
+		var tag string
+		if f.Tag != nil {
+			s, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(s).Get("kvs")
+		}
+		if tag == "-" {
+			continue
+		}
+
+		for _, name := range names {
+			if !ast.IsExported(name) {
+				continue
+			}
+			fd, err := g.parseField(typeName, name, tag, f)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, fd)
+		}
+	}
+	return fields, nil
+}
+
+func (g *generator) parseField(typeName string, name string, tag string, f *ast.Field) (field, error) {
+	fd := field{
+		name: name,
+		elem: f.Type,
+	}
+	fail := func(format string, args ...interface{}) (field, error) {
+		return fd, fmt.Errorf("%s.%s: %s", typeName, name, fmt.Sprintf(format, args...))
+	}
+
+	opts := strings.Split(tag, ",")
+	p := opts[0]
+	if p == "" {
+		p = name
+	}
+	for _, opt := range opts[1:] {
+		switch {
+		case opt == "omitempty":
+			fd.omitEmpty = true
+		case strings.HasPrefix(opt, "keywidth="):
+			w, err := strconv.Atoi(opt[len("keywidth="):])
+			if err != nil || w < 0 {
+				return fail("invalid option '%s'", opt)
+			}
+			fd.keyWidth = w
+		case opt == "inline", strings.HasPrefix(opt, "alias="), strings.HasPrefix(opt, "codec="):
+			return fail("option '%s' is not supported by generated code", opt)
+		default:
+			return fail("invalid option '%s'", opt)
+		}
+	}
+
+	if strings.HasPrefix(p, "/") {
+		return fail("tag must not start with '/'")
+	}
+	segs := strings.Split(p, "/")
+	keyIndex := -1
+	for i, s := range segs {
+		if s == "" && i != len(segs)-1 {
+			return fail("empty path element")
+		}
+		if s == "{index}" || (s == "{key}" && keyIndex >= 0) {
+			return fail("nested maps and slices are not supported by generated code")
+		}
+		if s == "{key}" {
+			keyIndex = i
+		} else if strings.Contains(s, "{key}") || strings.Contains(s, "{index}") {
+			return fail("'{key}' must be a whole path element")
+		}
+	}
+
+	if keyIndex < 0 {
+		fd.static = p
+		if segs[len(segs)-1] == "" {
+			fd.static = strings.Join(segs[:len(segs)-1], "/")
+			fd.recursive = true
+		}
+	} else {
+		m, ok := f.Type.(*ast.MapType)
+		if !ok {
+			return fail("'{key}' is only supported for maps")
+		}
+		fd.isMap = true
+		fd.key = m.Key
+		fd.elem = m.Value
+		fd.static = strings.Join(segs[:keyIndex], "/")
+		suffix := segs[keyIndex+1:]
+		if len(suffix) != 0 && suffix[len(suffix)-1] == "" {
+			fd.recursive = true
+			suffix = suffix[:len(suffix)-1]
+		}
+		fd.suffix = strings.Join(suffix, "/")
+	}
+
+	if err := g.checkType(fd.elem, typeName, f); err != nil {
+		return fd, err
+	}
+	if fd.recursive {
+		s, ok := g.localStruct(fd.elem)
+		if !ok {
+			return fail("only struct types declared in package '%s' can be stored recursively", g.pkg)
+		}
+		g.enqueue(s)
+	}
+	return fd, nil
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// Returns the static path followed by '/', or the empty string.
+func dir(static string) string {
+	if static == "" {
+		return ""
+	}
+	return static + "/"
+}
+
+func (g *generator) generate(name string, fields []field) {
+	g.p("")
+	if len(fields) != 0 {
+		g.p("// Names of the stored fields of %s, for SyncEvent navigation.", name)
+		g.p("const (")
+		for _, f := range fields {
+			g.p("%sField%s = %q", name, f.name, f.name)
+		}
+		g.p(")")
+		g.p("")
+	}
+	g.p("var _ encoding.GeneratedObject = (*%s)(nil)", name)
+
+	g.p("")
+	g.p("// EncodeKV implements encoding.GeneratedObject.")
+	g.p("func (o *%s) EncodeKV(prefix string, kvs map[string]string, omitted *[]string) error {", name)
+	for _, f := range fields {
+		g.encodeField(f)
+	}
+	g.p("return nil")
+	g.p("}")
+
+	g.p("")
+	g.p("// UpdateKV implements encoding.GeneratedObject.")
+	g.p("func (o *%s) UpdateKV(key string, value string) ([]interface{}, error) {", name)
+	g.p("if key == \"\" {")
+	g.p("return nil, encoding.ErrFindKeyInvalid")
+	g.p("}")
+	if g.updateFields(fields) {
+		g.p("return nil, encoding.ErrFindPathNotFound")
+	}
+	g.p("}")
+
+	g.p("")
+	g.p("// DeleteKV implements encoding.GeneratedObject.")
+	g.p("func (o *%s) DeleteKV(key string) ([]interface{}, error) {", name)
+	g.p("if key == \"\" {")
+	g.p("*o = %s{}", name)
+	g.p("return nil, nil")
+	g.p("}")
+	if g.deleteFields(fields) {
+		g.p("return nil, encoding.ErrFindPathNotFound")
+	}
+	g.p("}")
+
+	g.p("")
+	g.p("// LookupKV implements encoding.GeneratedObject.")
+	g.p("func (o *%s) LookupKV(key string) (interface{}, []interface{}, error) {", name)
+	g.p("if key == \"\" {")
+	g.p("c := *o")
+	g.p("return &c, nil, nil")
+	g.p("}")
+	if g.lookupFields(fields) {
+		g.p("return nil, nil, encoding.ErrFindPathNotFound")
+	}
+	g.p("}")
+}
+
+// Returns the condition checking whether a value is empty.
+func (g *generator) isEmpty(x string, t ast.Expr) string {
+	switch g.basic(t) {
+	case "string":
+		return x + ` == ""`
+	case "int", "uint":
+		return x + " == 0"
+	case "bool":
+		return "!" + x
+	}
+	if _, ok := t.(*ast.MapType); ok {
+		return "len(" + x + ") == 0"
+	}
+	return "encoding.IsEmptyValue(&" + x + ")"
+}
+
+// Returns the zero value of a type.
+func (g *generator) zero(t ast.Expr) string {
+	switch g.basic(t) {
+	case "string":
+		return `""`
+	case "int", "uint":
+		return "0"
+	case "bool":
+		return "false"
+	}
+	switch e := t.(type) {
+	case *ast.StarExpr, *ast.MapType, *ast.InterfaceType, *ast.FuncType, *ast.ChanType:
+		return "nil"
+	case *ast.ArrayType:
+		if e.Len == nil {
+			return "nil"
+		}
+	}
+	if s, ok := g.localStruct(t); ok {
+		return s + "{}"
+	}
+	return "*new(" + g.typeString(t) + ")"
+}
+
+// Stores a value at a given key expression.
+func (g *generator) encodeValue(x string, t ast.Expr, key string) {
+	switch g.basic(t) {
+	case "string":
+		g.p("kvs[%s] = %s", key, x)
+		return
+	case "int":
+		g.p("kvs[%s] = strconv.FormatInt(int64(%s), 10)", key, x)
+		return
+	case "uint":
+		g.p("kvs[%s] = strconv.FormatUint(uint64(%s), 10)", key, x)
+		return
+	case "bool":
+		g.p("kvs[%s] = strconv.FormatBool(%s)", key, x)
+		return
+	}
+	if _, ok := t.(*ast.StarExpr); ok {
+		g.p("if %s != nil {", x)
+		g.p("s, err := encoding.MarshalValue(%s)", x)
+		g.p("if err != nil {")
+		g.p("return err")
+		g.p("}")
+		g.p("kvs[%s] = s", key)
+		g.p("}")
+		return
+	}
+	g.p("if s, err := encoding.MarshalValue(&%s); err != nil {", x)
+	g.p("return err")
+	g.p("} else {")
+	g.p("kvs[%s] = s", key)
+	g.p("}")
+}
+
+// Parses the value into a variable. Invalid values are replaced with the zero value.
+func (g *generator) decodeValue(x string, t ast.Expr) {
+	switch g.basic(t) {
+	case "string":
+		g.p("%s = value", x)
+		return
+	case "int":
+		name := t.(*ast.Ident).Name
+		g.p("%s = %s(encoding.ParseInt(value, %d))", x, name, basicBits[name])
+		return
+	case "uint":
+		name := t.(*ast.Ident).Name
+		g.p("%s = %s(encoding.ParseUint(value, %d))", x, name, basicBits[name])
+		return
+	case "bool":
+		g.p("%s = encoding.ParseBool(value)", x)
+		return
+	}
+	g.p("_ = encoding.UnmarshalValue(value, &%s)", x)
+}
+
+// Declares ks, the key path element of map key k.
+func (g *generator) encodeMapKey(f field) {
+	switch g.basic(f.key) {
+	case "string":
+		g.p("ks := encoding.EscapeMapKey(k)")
+		return
+	case "int", "uint":
+		format := "strconv.FormatInt(int64(k), 10)"
+		if g.basic(f.key) == "uint" {
+			format = "strconv.FormatUint(uint64(k), 10)"
+		}
+		if f.keyWidth != 0 {
+			g.p("ks := encoding.PadMapKey(%s, %d)", format, f.keyWidth)
+		} else {
+			g.p("ks := %s", format)
+		}
+		return
+	}
+	g.p("ks, err := encoding.FormatMapKey(&k, %d)", f.keyWidth)
+	g.p("if err != nil {")
+	g.p("return err")
+	g.p("}")
+}
+
+// Declares k, the map key parsed from the key path element seg.
+// nils are the values returned along with the parsing error.
+func (g *generator) decodeMapKey(f field, nils string) {
+	switch g.basic(f.key) {
+	case "string":
+		g.p("k := encoding.UnescapeMapKey(seg)")
+		return
+	case "int", "uint":
+		name := f.key.(*ast.Ident).Name
+		if g.basic(f.key) == "int" {
+			g.p("i, err := strconv.ParseInt(seg, 10, %d)", basicBits[name])
+		} else {
+			g.p("i, err := strconv.ParseUint(seg, 10, %d)", basicBits[name])
+		}
+		g.p("if err != nil {")
+		g.p("return %s, err", nils)
+		g.p("}")
+		g.p("k := %s(i)", name)
+		return
+	}
+	g.p("var k %s", g.typeString(f.key))
+	g.p("if err := encoding.ParseMapKey(seg, &k); err != nil {")
+	g.p("return %s, err", nils)
+	g.p("}")
+}
+
+func (g *generator) encodeField(f field) {
+	x := "o." + f.name
+	if f.omitEmpty {
+		omitted := f.static
+		if f.isMap || f.recursive {
+			omitted = dir(f.static)
+		}
+		g.p("if %s {", g.isEmpty(x, f.elem))
+		g.p("*omitted = append(*omitted, prefix+%q)", omitted)
+		g.p("} else {")
+		defer g.p("}")
+	}
+
+	if !f.isMap {
+		if !f.recursive {
+			g.encodeValue(x, f.elem, fmt.Sprintf("prefix+%q", f.static))
+			return
+		}
+		if _, ok := f.elem.(*ast.StarExpr); ok {
+			g.p("if %s != nil {", x)
+			defer g.p("}")
+		}
+		g.p("if err := %s.EncodeKV(prefix+%q, kvs, omitted); err != nil {", x, dir(f.static))
+		g.p("return err")
+		g.p("}")
+		return
+	}
+
+	g.p("for k, v := range %s {", x)
+	g.encodeMapKey(f)
+	if !f.recursive {
+		key := fmt.Sprintf("prefix+%q+ks", dir(f.static))
+		if f.suffix != "" {
+			key += fmt.Sprintf("+%q", "/"+f.suffix)
+		}
+		g.encodeValue("v", f.elem, key)
+	} else {
+		if _, ok := f.elem.(*ast.StarExpr); ok {
+			g.p("if v == nil {")
+			g.p("continue")
+			g.p("}")
+		}
+		g.p("if err := v.EncodeKV(prefix+%q+ks+%q, kvs, omitted); err != nil {", dir(f.static), "/"+dir(f.suffix))
+		g.p("return err")
+		g.p("}")
+	}
+	g.p("}")
+}
+
+// Splits the key below a map path into the map key element seg, and the element key sub.
+// sub is only declared when the map elements are stored below the key element.
+func (g *generator) splitMapKey(f field) {
+	g.p("rest := key[%d:]", len(dir(f.static)))
+	if !f.recursive && f.suffix == "" {
+		g.p("seg, hasSub := rest, false")
+		g.p("if i := strings.IndexByte(rest, '/'); i >= 0 {")
+		g.p("seg, hasSub = rest[:i], true")
+		g.p("}")
+		return
+	}
+	g.p("seg, sub, hasSub := rest, \"\", false")
+	g.p("if i := strings.IndexByte(rest, '/'); i >= 0 {")
+	g.p("seg, sub, hasSub = rest[:i], rest[i+1:], true")
+	g.p("}")
+}
+
+// Generates the lookup of the field set by a key.
+// Returns whether a key may not match any field.
+func (g *generator) updateFields(fields []field) bool {
+	for _, f := range fields {
+		x := "o." + f.name
+		switch {
+		case !f.isMap && !f.recursive:
+			g.p("if key == %q {", f.static)
+			g.decodeValue(x, f.elem)
+			g.p("return []interface{}{%q}, nil", f.name)
+			g.p("}")
+			g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			g.p("return nil, encoding.ErrFindPathPastObject")
+			g.p("}")
+			if strings.Contains(f.static, "/") {
+				g.p("if strings.HasPrefix(%q, key+\"/\") {", dir(f.static))
+				g.p("return nil, encoding.ErrFindKeyInvalid")
+				g.p("}")
+			}
+
+		case !f.isMap:
+			g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			if _, ok := f.elem.(*ast.StarExpr); ok {
+				s, _ := g.localStruct(f.elem)
+				g.p("if %s == nil {", x)
+				g.p("%s = new(%s)", x, s)
+				g.p("}")
+			}
+			g.p("fields, err := %s.UpdateKV(key[%d:], value)", x, len(dir(f.static)))
+			g.p("if err != nil {")
+			g.p("return nil, err")
+			g.p("}")
+			g.p("return append([]interface{}{%q}, fields...), nil", f.name)
+			g.p("}")
+			g.p("if strings.HasPrefix(%q, key+\"/\") {", dir(f.static))
+			g.p("return nil, encoding.ErrFindKeyInvalid")
+			g.p("}")
+
+		default:
+			if f.static != "" {
+				g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			}
+			g.splitMapKey(f)
+			g.decodeMapKey(f, "nil")
+			if !f.recursive {
+				if f.suffix == "" {
+					g.p("if hasSub {")
+					g.p("return nil, encoding.ErrFindPathPastObject")
+					g.p("}")
+				} else {
+					g.p("if sub != %q {", f.suffix)
+					g.p("if !hasSub || strings.HasPrefix(%q, sub+\"/\") {", f.suffix+"/")
+					g.p("return nil, encoding.ErrFindKeyInvalid")
+					g.p("}")
+					g.p("if strings.HasPrefix(sub, %q) {", f.suffix+"/")
+					g.p("return nil, encoding.ErrFindPathPastObject")
+					g.p("}")
+					g.p("return nil, encoding.ErrFindPathNotFound")
+					g.p("}")
+				}
+				g.p("if %s == nil {", x)
+				g.p("%s = make(%s)", x, g.mapType(f))
+				g.p("}")
+				g.p("var e %s", g.typeString(f.elem))
+				g.decodeValue("e", f.elem)
+				g.p("%s[k] = e", x)
+				g.p("return []interface{}{%q, k}, nil", f.name)
+			} else {
+				g.elementKey(f, "return nil, encoding.ErrFindKeyInvalid", "nil")
+				g.p("if %s == nil {", x)
+				g.p("%s = make(%s)", x, g.mapType(f))
+				g.p("}")
+				g.p("e := %s[k]", x)
+				if _, ok := f.elem.(*ast.StarExpr); ok {
+					s, _ := g.localStruct(f.elem)
+					g.p("if e == nil {")
+					g.p("e = new(%s)", s)
+					g.p("}")
+				}
+				g.p("fields, err := e.UpdateKV(elemKey, value)")
+				g.p("if err != nil {")
+				g.p("return nil, err")
+				g.p("}")
+				g.p("%s[k] = e", x)
+				g.p("return append([]interface{}{%q, k}, fields...), nil", f.name)
+			}
+			if f.static == "" {
+				// Every key belongs to the map
+				return false
+			}
+			g.p("}")
+			g.p("if strings.HasPrefix(%q, key+\"/\") {", dir(f.static))
+			g.p("return nil, encoding.ErrFindKeyInvalid")
+			g.p("}")
+		}
+	}
+	return true
+}
+
+// Declares elemKey, the key relative to a recursively stored map element.
+// The given statement is executed when the key designates the element itself.
+// nils are the values returned along with the error when the key is not found.
+func (g *generator) elementKey(f field, element string, nils string) {
+	if f.suffix == "" {
+		g.p("if !hasSub {")
+		g.p("%s", element)
+		g.p("}")
+		g.p("elemKey := sub")
+		return
+	}
+	g.p("if !hasSub || strings.HasPrefix(%q, sub+\"/\") {", f.suffix+"/")
+	g.p("%s", element)
+	g.p("}")
+	g.p("if !strings.HasPrefix(sub, %q) {", f.suffix+"/")
+	g.p("return %s, encoding.ErrFindPathNotFound", nils)
+	g.p("}")
+	g.p("elemKey := sub[%d:]", len(f.suffix)+1)
+}
+
+func (g *generator) mapType(f field) string {
+	return "map[" + g.typeString(f.key) + "]" + g.typeString(f.elem)
+}
+
+// Generates the lookup of the field reset by a key deletion.
+// Returns whether a key may not match any field.
+func (g *generator) deleteFields(fields []field) bool {
+	for _, f := range fields {
+		x := "o." + f.name
+		switch {
+		case !f.isMap && !f.recursive:
+			g.p("if key == %q || strings.HasPrefix(%q, key+\"/\") {", f.static, dir(f.static))
+			g.p("%s = %s", x, g.zero(f.elem))
+			g.p("return []interface{}{%q}, nil", f.name)
+			g.p("}")
+			g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			g.p("return nil, encoding.ErrFindPathPastObject")
+			g.p("}")
+
+		case !f.isMap:
+			g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			if _, ok := f.elem.(*ast.StarExpr); ok {
+				g.p("if %s == nil {", x)
+				g.p("return nil, encoding.ErrFindObjectNotFound")
+				g.p("}")
+			}
+			g.p("fields, err := %s.DeleteKV(key[%d:])", x, len(dir(f.static)))
+			g.p("if err != nil {")
+			g.p("return nil, err")
+			g.p("}")
+			g.p("return append([]interface{}{%q}, fields...), nil", f.name)
+			g.p("}")
+			g.p("if strings.HasPrefix(%q, key+\"/\") {", dir(f.static))
+			g.p("%s = %s", x, g.zero(f.elem))
+			g.p("return []interface{}{%q}, nil", f.name)
+			g.p("}")
+
+		default:
+			if f.static != "" {
+				g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			}
+			g.splitMapKey(f)
+			g.decodeMapKey(f, "nil")
+			// Deleting a missing element fails, as with reflection
+			deleteElement := fmt.Sprintf("if _, ok := %s[k]; !ok {\nreturn nil, encoding.ErrFindObjectNotFound\n}\n"+
+				"delete(%s, k)\nreturn []interface{}{%q, k}, nil", x, x, f.name)
+			if !f.recursive {
+				if f.suffix == "" {
+					g.p("if hasSub {")
+					g.p("return nil, encoding.ErrFindPathPastObject")
+					g.p("}")
+				} else {
+					g.p("if hasSub && !strings.HasPrefix(%q, sub+\"/\") {", f.suffix+"/")
+					g.p("if strings.HasPrefix(sub, %q) {", f.suffix+"/")
+					g.p("return nil, encoding.ErrFindPathPastObject")
+					g.p("}")
+					g.p("return nil, encoding.ErrFindPathNotFound")
+					g.p("}")
+				}
+				g.p("%s", deleteElement)
+			} else {
+				g.elementKey(f, deleteElement, "nil")
+				g.p("e, ok := %s[k]", x)
+				g.p("if !ok {")
+				g.p("return nil, encoding.ErrFindObjectNotFound")
+				g.p("}")
+				g.p("fields, err := e.DeleteKV(elemKey)")
+				g.p("if err != nil {")
+				g.p("return nil, err")
+				g.p("}")
+				if _, ok := f.elem.(*ast.StarExpr); !ok {
+					g.p("%s[k] = e", x)
+				}
+				g.p("return append([]interface{}{%q, k}, fields...), nil", f.name)
+			}
+			if f.static == "" {
+				// Every key belongs to the map
+				return false
+			}
+			g.p("}")
+			g.p("if strings.HasPrefix(%q, key+\"/\") {", dir(f.static))
+			g.p("%s = nil", x)
+			g.p("return []interface{}{%q}, nil", f.name)
+			g.p("}")
+		}
+	}
+	return true
+}
+
+// Generates the lookup of the sub-object designated by a key.
+// Returns whether a key may not match any field.
+func (g *generator) lookupFields(fields []field) bool {
+	for _, f := range fields {
+		x := "o." + f.name
+		path := fmt.Sprintf("[]interface{}{%q}", f.name)
+		switch {
+		case !f.isMap && !f.recursive:
+			g.p("if key == %q || strings.HasPrefix(%q, key+\"/\") {", f.static, dir(f.static))
+			g.p("%s", lookupValue(x, f.elem, path))
+			g.p("}")
+			g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			g.p("return nil, nil, encoding.ErrFindPathPastObject")
+			g.p("}")
+
+		case !f.isMap:
+			g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			missing := ""
+			if isPointer(f.elem) {
+				missing = x + " == nil"
+			}
+			g.lookupElement(x, f.elem, fmt.Sprintf("key[%d:]", len(dir(f.static))), path, missing)
+			g.p("}")
+			g.p("if strings.HasPrefix(%q, key+\"/\") {", dir(f.static))
+			g.p("%s", lookupValue(x, f.elem, path))
+			g.p("}")
+
+		default:
+			if f.static != "" {
+				g.p("if strings.HasPrefix(key, %q) {", dir(f.static))
+			}
+			g.splitMapKey(f)
+			g.decodeMapKey(f, "nil, nil")
+			elemPath := fmt.Sprintf("[]interface{}{%q, k}", f.name)
+			element := fmt.Sprintf("e, ok := %s[k]\nif !ok {\nreturn nil, %s, nil\n}\n%s", x, elemPath, lookupValue("e", f.elem, elemPath))
+			if !f.recursive {
+				if f.suffix == "" {
+					g.p("if hasSub {")
+					g.p("return nil, nil, encoding.ErrFindPathPastObject")
+					g.p("}")
+				} else {
+					g.p("if hasSub && !strings.HasPrefix(%q, sub+\"/\") {", f.suffix+"/")
+					g.p("if strings.HasPrefix(sub, %q) {", f.suffix+"/")
+					g.p("return nil, nil, encoding.ErrFindPathPastObject")
+					g.p("}")
+					g.p("return nil, nil, encoding.ErrFindPathNotFound")
+					g.p("}")
+				}
+				g.p("%s", element)
+			} else {
+				g.elementKey(f, element, "nil, nil")
+				missing := "e == nil"
+				if isPointer(f.elem) {
+					g.p("e := %s[k]", x)
+				} else {
+					g.p("e, ok := %s[k]", x)
+					missing = "!ok"
+				}
+				g.lookupElement("e", f.elem, "elemKey", elemPath, missing)
+			}
+			if f.static == "" {
+				// Every key belongs to the map
+				return false
+			}
+			g.p("}")
+			g.p("if strings.HasPrefix(%q, key+\"/\") {", dir(f.static))
+			g.p("return %s, %s, nil", x, path)
+			g.p("}")
+		}
+	}
+	return true
+}
+
+// Returns the statements returning a copy of a value, and the path to the value.
+// Nil pointers are returned as nil.
+func lookupValue(x string, t ast.Expr, path string) string {
+	if !isPointer(t) {
+		return fmt.Sprintf("return %s, %s, nil", x, path)
+	}
+	return fmt.Sprintf("if %s == nil {\nreturn nil, %s, nil\n}\nc := *%s\nreturn &c, %s, nil", x, path, x, path)
+}
+
+// Generates the lookup of a key below a recursively stored struct x.
+// When the missing condition is met, the path is returned without value.
+func (g *generator) lookupElement(x string, t ast.Expr, key string, path string, missing string) {
+	s, _ := g.localStruct(t)
+	if missing != "" {
+		g.p("if %s {", missing)
+		g.p("_, fields, err := new(%s).LookupKV(%s)", s, key)
+		g.p("if err != nil {")
+		g.p("return nil, nil, err")
+		g.p("}")
+		g.p("return nil, append(%s, fields...), nil", path)
+		g.p("}")
+	}
+	g.p("v, fields, err := %s.LookupKV(%s)", x, key)
+	g.p("if err != nil {")
+	g.p("return nil, nil, err")
+	g.p("}")
+	g.p("return v, append(%s, fields...), nil", path)
+}
+
+func isPointer(t ast.Expr) bool {
+	_, ok := t.(*ast.StarExpr)
+	return ok
+}
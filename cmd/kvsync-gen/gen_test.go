@@ -0,0 +1,71 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateExample(t *testing.T) {
+	dir := filepath.Join("..", "..", "examples", "codegen")
+	src, err := Generate(dir, "directory_kvs.go", []string{"Directory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := ioutil.ReadFile(filepath.Join(dir, "directory_kvs.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, committed) {
+		t.Errorf("examples/codegen/directory_kvs.go is not up to date, run 'go generate'")
+	}
+}
+
+func testGenerateError(t *testing.T, src string, expected string) {
+	dir, err := ioutil.TempDir("", "kvsync-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "t.go"), []byte("package t\n\n"+src), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Generate(dir, "t_kvs.go", []string{"T"})
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Generating %s returned error %v, expected '%s'", src, err, expected)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	testGenerateError(t, "type T int", "not a struct type")
+	testGenerateError(t, "type T struct { A int `kvs:\"a,codec=text\"` }", "T.A: option 'codec=text' is not supported")
+	testGenerateError(t, "type T struct { A U `kvs:\",inline\"` }\ntype U struct{}", "T.A: option 'inline' is not supported")
+	testGenerateError(t, "type T struct { A int `kvs:\"a,alias=b\"` }", "T.A: option 'alias=b' is not supported")
+	testGenerateError(t, "type T struct { A int `kvs:\"a,foo\"` }", "T.A: invalid option 'foo'")
+	testGenerateError(t, "type T struct { A int `kvs:\"/a\"` }", "T.A: tag must not start with '/'")
+	testGenerateError(t, "type T struct { A int `kvs:\"a//b\"` }", "T.A: empty path element")
+	testGenerateError(t, "type T struct { A map[string]map[string]int `kvs:\"a/{key}/{key}\"` }", "T.A: nested maps")
+	testGenerateError(t, "type T struct { A int `kvs:\"a/{key}\"` }", "T.A: '{key}' is only supported for maps")
+	testGenerateError(t, "type T struct { A map[string]int `kvs:\"a/{key}/\"` }", "T.A: only struct types")
+	testGenerateError(t, "type T struct { A U }\ntype U struct{}\nfunc (U) MarshalKV() {}", "T.A: type 'U' implements MarshalKV")
+	testGenerateError(t, "type T struct { A U `kvs:\"a/\"` }\ntype U struct{ B V `kvs:\"b/\"` }\ntype V struct{ C int `kvs:\"c,keywidth=x\"` }", "V.C: invalid option")
+}
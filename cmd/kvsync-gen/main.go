@@ -0,0 +1,72 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Kvsync-gen generates reflection-free encoding functions for struct types
+// stored with kvsync.
+//
+// Usage:
+//
+//	//go:generate go run github.com/Oryon/kvsync/cmd/kvsync-gen -type Directory,Student
+//
+// For each given type, as well as the struct types of the same package which
+// are stored recursively below it, kvsync-gen implements encoding.GeneratedObject
+// and declares the names of the stored fields as <Type>Field<Name> constants.
+// The code is written to <type>_kvs.go, in the package directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma-separated list of type names")
+	output := flag.String("output", "", "output file name (default <type>_kvs.go)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: kvsync-gen -type T[,T...] [-output file] [directory]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *typeNames == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+
+	if *output == "" {
+		*output = strings.ToLower(types[0]) + "_kvs.go"
+	}
+	out := filepath.Join(dir, filepath.Base(*output))
+
+	src, err := Generate(dir, filepath.Base(out), types)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvsync-gen: %v\n", err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(out, src, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvsync-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
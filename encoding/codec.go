@@ -0,0 +1,208 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+var ErrUnknownCodec = errors.New("Unknown codec")
+var ErrCodecUnsupportedType = errors.New("Type not supported by codec")
+
+// Codec serializes values stored as a single key.
+//
+// Codecs are selected with the 'codec' format option, e.g. `kvs:"config,codec=yaml"`.
+// The option applies to the value stored at the given format,
+// as well as to all the values stored below it when the format is recursive.
+// A default codec can therefore be given for a whole object by adding the option
+// to the format provided to Encode, FindByKey, UpdateKeyObject, store or sync functions
+// (e.g. "/db/,codec=text").
+//
+// The "json" codec is used by default. The "text" codec is also provided.
+// Other codecs (e.g. YAML, protobuf or msgpack) can be added with RegisterCodec.
+type Codec interface {
+	// Marshal returns the string representation of v.
+	Marshal(v interface{}) (string, error)
+
+	// Unmarshal parses data and stores the result in the value pointed to by v.
+	Unmarshal(data string, v interface{}) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: map[string]Codec{
+		"json": jsonCodec{},
+		"text": textCodec{},
+	},
+}
+
+// RegisterCodec makes a codec available under the given name.
+// Registering a codec with an existing name replaces it.
+func RegisterCodec(name string, codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[name] = codec
+}
+
+func getCodec(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[name]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return c, nil
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Returns whether values of the given type can be stored in text form,
+// which requires both encoding.TextMarshaler and encoding.TextUnmarshaler.
+func isTextType(t reflect.Type) bool {
+	return getTypeInfo(t).text
+}
+
+// The default codec stores strings as is, types implementing encoding.TextMarshaler
+// and encoding.TextUnmarshaler in text form, and other values as JSON.
+//
+// Values of text types written by earlier versions are JSON strings (e.g. "\"10.0.0.1\"").
+// Such quoted values are still decoded.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) (string, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+
+	if rv.IsValid() && isTextType(rv.Type()) {
+		if !rv.Type().Implements(textMarshalerType) {
+			p := reflect.New(rv.Type())
+			p.Elem().Set(rv)
+			rv = p
+		}
+		text, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	arr, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(arr), nil
+}
+
+func (jsonCodec) Unmarshal(data string, v interface{}) error {
+	rv := reflect.ValueOf(v).Elem()
+	if rv.Kind() == reflect.String {
+		rv.SetString(data)
+		return nil
+	}
+
+	if isTextType(rv.Type()) {
+		if len(data) >= 2 && data[0] == '"' {
+			// Quoted JSON string, as written by earlier versions
+			var text string
+			if json.Unmarshal([]byte(data), &text) == nil {
+				data = text
+			}
+		}
+		return v.(encoding.TextUnmarshaler).UnmarshalText([]byte(data))
+	}
+
+	return json.Unmarshal([]byte(data), v)
+}
+
+// The text codec stores scalar values in their plain text representation.
+// It supports strings, byte slices, booleans, numbers and types implementing
+// encoding.TextMarshaler and encoding.TextUnmarshaler.
+type textCodec struct{}
+
+func (textCodec) Marshal(v interface{}) (string, error) {
+	if m, ok := v.(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits()), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes()), nil
+		}
+	}
+	return "", ErrCodecUnsupportedType
+}
+
+func (textCodec) Unmarshal(data string, v interface{}) error {
+	if u, ok := v.(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(data))
+	}
+
+	rv := reflect.ValueOf(v).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(data)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(data)
+		if err == nil {
+			rv.SetBool(b)
+		}
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(data, 10, rv.Type().Bits())
+		if err == nil {
+			rv.SetInt(i)
+		}
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, err := strconv.ParseUint(data, 10, rv.Type().Bits())
+		if err == nil {
+			rv.SetUint(i)
+		}
+		return err
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(data, rv.Type().Bits())
+		if err == nil {
+			rv.SetFloat(f)
+		}
+		return err
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes([]byte(data))
+			return nil
+		}
+	}
+	return ErrCodecUnsupportedType
+}
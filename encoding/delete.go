@@ -0,0 +1,319 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Changes lists the changes to apply to the key-value store after modifying an object locally:
+// keys to delete first, followed by key-value pairs to store.
+type Changes struct {
+	// Keys to delete. Keys finishing with '/' designate all the keys below them.
+	Keys []string
+
+	// Key-value pairs to store (e.g. when a deleted sub-object was stored within a single value,
+	// such as a slice element, or an attribute of a struct stored as JSON).
+	Values map[string]string
+}
+
+// DeleteFields deletes the sub-object designated by a field path, and returns the changes
+// to apply to the key-value store.
+//
+// Map elements are removed from their map, slice elements are removed from their slice,
+// and other sub-objects (e.g. struct attributes and pointers) are reset to their zero value.
+// An empty field path resets the whole object.
+//
+// Sub-objects stored as multiple keys are deleted by deleting their directory (e.g. "/db/Edges/10/"),
+// unless other attributes may store keys within that directory (e.g. because of inlined attributes),
+// in which case the keys currently stored by the sub-object are deleted one by one.
+// Sub-objects stored within a single value cause that value to be stored again.
+func DeleteFields(object interface{}, format interface{}, fields ...interface{}) (*Changes, error) {
+	root, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := findByFields(root, fields, findOptions{})
+	if errors.Is(err, ErrFindPathPastObject) {
+		// The sub-object is stored within the value of o
+		return deleteInValue(object, format, o, fields[len(o.fields):])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := storedKeys(object, format, root, o, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resetByFields(object, format, fields)
+	if err != nil {
+		return nil, err
+	}
+	return &Changes{Keys: keys}, nil
+}
+
+// Returns the keys or directories storing the sub-object found at o, designated by fields.
+func storedKeys(object interface{}, format interface{}, root objectPath, o objectPath, fields []interface{}) ([]string, error) {
+	key := strings.Join(o.keypath, "/")
+	if len(o.format) == 0 {
+		return []string{key}, nil
+	}
+	if isExclusiveDirectory(root.vtype, fields) {
+		return []string{key + "/"}, nil
+	}
+	if !o.value.IsValid() {
+		return nil, nil
+	}
+
+	kvs, omitted, err := EncodeWithDeletions(format, object, fields...)
+	if err != nil {
+		return nil, err
+	}
+	keys := omitted
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// MoveFields moves the sub-object designated by the field path 'from' to the field path 'to'
+// (e.g. to rename a map element), and returns the changes to apply to the key-value store,
+// in order: the changes storing the sub-object at its new location, replacing any sub-object
+// stored there, followed by the changes deleting the sub-object from its previous location
+// (see DeleteFields).
+//
+// Both sub-objects must be stored at their own keys (e.g. map elements), and none may contain the other.
+func MoveFields(object interface{}, format interface{}, from []interface{}, to []interface{}) (*Changes, *Changes, error) {
+	if isFieldPathPrefix(from, to) || isFieldPathPrefix(to, from) {
+		return nil, nil, ErrMoveOverlap
+	}
+
+	root, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := findByFields(root, from, findOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !src.value.IsValid() {
+		return nil, nil, src.wrap(ErrFindObjectNotFound)
+	}
+	dst, err := findByFields(root, to, findOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if dst.vtype != src.vtype && (dst.vtype.Kind() != reflect.Interface || !src.vtype.AssignableTo(dst.vtype)) {
+		return nil, nil, dst.wrap(ErrFindSetWrongType)
+	}
+
+	// Keys currently stored at the destination are replaced
+	replaced, err := storedKeys(object, format, root, dst, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value := reflect.New(src.vtype).Elem()
+	value.Set(src.value)
+	deleted, err := DeleteFields(object, format, from...)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = SetByFields(object, format, value.Interface(), to...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kvs, omitted, err := EncodeWithDeletions(format, object, to...)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := &Changes{
+		Keys:   append(replaced, omitted...),
+		Values: kvs,
+	}
+
+	// Values stored at the destination already contain the deletion (e.g. when both are stored in the same value)
+	for k := range deleted.Values {
+		if _, ok := kvs[k]; ok {
+			delete(deleted.Values, k)
+		}
+	}
+	keys := deleted.Keys[:0]
+	for _, k := range deleted.Keys {
+		if _, ok := kvs[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	deleted.Keys = keys
+	return stored, deleted, nil
+}
+
+// Returns whether the field path p is a prefix of the field path fields.
+func isFieldPathPrefix(p []interface{}, fields []interface{}) bool {
+	if len(p) > len(fields) {
+		return false
+	}
+	for i := range p {
+		if p[i] != fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Deletes a sub-object stored within the value of the object at o, and stores that value again.
+func deleteInValue(object interface{}, format interface{}, o objectPath, fields []interface{}) (*Changes, error) {
+	if !o.value.IsValid() {
+		return nil, o.wrap(ErrFindObjectNotFound)
+	}
+
+	// Modify a copy, which replaces the value once modified
+	c := reflect.New(o.vtype).Elem()
+	c.Set(o.value)
+	err := deleteValueByFields(c, fields)
+	if err != nil {
+		return nil, o.wrap(err)
+	}
+	err = SetByFields(object, format, c.Interface(), o.fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	kvs, omitted, err := EncodeWithDeletions(format, object, o.fields...)
+	if err != nil {
+		return nil, err
+	}
+	return &Changes{
+		Keys:   omitted,
+		Values: kvs,
+	}, nil
+}
+
+// Removes the element designated by fields from v, which must be settable.
+// The pointed values, maps and slices containing the element are copied, such that
+// values shared with other objects are not modified.
+func deleteValueByFields(v reflect.Value, fields []interface{}) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ErrFindObjectNotFound
+		}
+		// Copy the pointed value, as it may be shared
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(v.Elem())
+		v.Set(p)
+		v = p.Elem()
+	}
+
+	last := len(fields) == 1
+	switch v.Kind() {
+	case reflect.Struct:
+		name, ok := fields[0].(string)
+		if !ok {
+			return ErrWrongFieldType
+		}
+		f := v.FieldByName(name)
+		if !f.IsValid() || !f.CanSet() {
+			return ErrWrongFieldName
+		}
+		if last {
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+		return deleteValueByFields(f, fields[1:])
+	case reflect.Map:
+		k := reflect.ValueOf(fields[0])
+		if !k.IsValid() || k.Type() != v.Type().Key() {
+			return ErrFindKeyWrongType
+		}
+		e := v.MapIndex(k)
+		if !e.IsValid() {
+			return ErrFindObjectNotFound
+		}
+		// Copy the map, as it may be shared
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), iter.Value())
+		}
+		v.Set(m)
+		if last {
+			v.SetMapIndex(k, reflect.Value{})
+			return nil
+		}
+		// Map elements are not addressable
+		c := reflect.New(e.Type()).Elem()
+		c.Set(e)
+		err := deleteValueByFields(c, fields[1:])
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(k, c)
+		return nil
+	case reflect.Slice, reflect.Array:
+		i, ok := fields[0].(int)
+		if !ok {
+			return ErrWrongFieldType
+		}
+		if i < 0 || i >= v.Len() {
+			return ErrFindObjectNotFound
+		}
+		if v.Kind() == reflect.Slice {
+			// Copy the slice, as the backing array may be shared
+			s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			reflect.Copy(s, v)
+			v.Set(s)
+		}
+		if !last {
+			return deleteValueByFields(v.Index(i), fields[1:])
+		}
+		if v.Kind() == reflect.Array {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+			return nil
+		}
+		v.Set(reflect.AppendSlice(v.Slice(0, i), v.Slice(i+1, v.Len())))
+		return nil
+	default:
+		return ErrFindPathPastObject
+	}
+}
+
+// Returns whether the directory storing the sub-object designated by fields only contains
+// keys of that sub-object, i.e. none of the structs containing it has inlined attributes
+// or attributes which keys overlap.
+func isExclusiveDirectory(t reflect.Type, fields []interface{}) bool {
+	for _, f := range fields {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			t = t.Elem()
+			continue
+		}
+		plan := getStructPlan(t)
+		if plan.ordered == nil {
+			return false
+		}
+		t = plan.fields[plan.byName[f.(string)]].ftype
+	}
+	return true
+}
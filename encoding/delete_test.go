@@ -0,0 +1,204 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"reflect"
+	"testing"
+)
+
+type D1 struct {
+	A int
+	L []int
+}
+
+type D2 struct {
+	Sub   D1            `kvs:"sub/"`
+	Ptr   *D1           `kvs:"ptr/"`
+	Blob  D1            `kvs:"blob"`
+	Blobs map[string]D1 `kvs:"blobs/{key}"`
+	Elems map[int]*D1   `kvs:"elems/{key}/"`
+}
+
+type D3 struct {
+	D1 `kvs:",inline"`
+	B  int
+}
+
+type D4 struct {
+	In D3 `kvs:"in/"`
+}
+
+func testDeleteFields(t *testing.T, object interface{}, format string, expected Changes, fields ...interface{}) {
+	d, err := DeleteFields(object, format, fields...)
+	if err != nil {
+		t.Errorf("DeleteFields%v returned %v", fields, err)
+		return
+	}
+	if len(d.Values) == 0 {
+		d.Values = nil
+	}
+	if !reflect.DeepEqual(*d, expected) {
+		t.Errorf("DeleteFields%v returned %v instead of %v", fields, *d, expected)
+	}
+}
+
+func testD2() D2 {
+	return D2{
+		Sub:   D1{A: 1, L: []int{1, 2}},
+		Ptr:   &D1{A: 2},
+		Blob:  D1{A: 3, L: []int{1, 2, 3}},
+		Blobs: map[string]D1{"a": {A: 4, L: []int{4}}, "b": {A: 5}},
+		Elems: map[int]*D1{1: {A: 6, L: []int{6, 7}}},
+	}
+}
+
+func TestDeleteFields(t *testing.T) {
+	o := testD2()
+
+	// Sub-objects stored recursively are deleted by directory
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/sub/"}}, "Sub")
+	if !reflect.DeepEqual(o.Sub, D1{}) {
+		t.Errorf("Sub was not reset: %v", o.Sub)
+	}
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/ptr/"}}, "Ptr")
+	if o.Ptr != nil {
+		t.Errorf("Ptr was not reset: %v", o.Ptr)
+	}
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/elems/1/"}}, "Elems", 1)
+	if len(o.Elems) != 0 {
+		t.Errorf("Element was not removed: %v", o.Elems)
+	}
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/elems/"}}, "Elems")
+	if o.Elems != nil {
+		t.Errorf("Elems was not reset: %v", o.Elems)
+	}
+
+	// Attributes stored as a single key
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/sub/A"}}, "Sub", "A")
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/blob"}}, "Blob")
+
+	// Sub-objects stored within a value
+	o = testD2()
+	testDeleteFields(t, &o, "/o/", Changes{Values: map[string]string{"/o/sub/L": "[2]"}}, "Sub", "L", 0)
+	testDeleteFields(t, &o, "/o/", Changes{Values: map[string]string{"/o/blob": `{"A":3,"L":[1,3]}`}}, "Blob", "L", 1)
+	testDeleteFields(t, &o, "/o/", Changes{Values: map[string]string{"/o/blob": `{"A":0,"L":[1,3]}`}}, "Blob", "A")
+	testDeleteFields(t, &o, "/o/", Changes{Values: map[string]string{"/o/blobs/a": `{"A":4,"L":[]}`}}, "Blobs", "a", "L", 0)
+	testDeleteFields(t, &o, "/o/", Changes{Values: map[string]string{"/o/elems/1/L": "[6]"}}, "Elems", 1, "L", 1)
+	if !reflect.DeepEqual(o.Sub.L, []int{2}) || !reflect.DeepEqual(o.Blob, D1{L: []int{1, 3}}) ||
+		len(o.Blobs["a"].L) != 0 || !reflect.DeepEqual(o.Elems[1].L, []int{6}) {
+		t.Errorf("Wrong object %v", o)
+	}
+
+	// Whole object
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/"}})
+	if !reflect.DeepEqual(o, D2{}) {
+		t.Errorf("Object was not reset: %v", o)
+	}
+
+	// Errors
+	o = testD2()
+	for _, c := range []struct {
+		fields []interface{}
+		err    error
+	}{
+		{[]interface{}{"Blobs", "c"}, ErrFindObjectNotFound},
+		{[]interface{}{"Blob", "L", 3}, ErrFindObjectNotFound},
+		{[]interface{}{"Blob", "L", "a"}, ErrWrongFieldType},
+		{[]interface{}{"Blob", "X"}, ErrWrongFieldName},
+		{[]interface{}{"Blobs", 1}, ErrFindKeyWrongType},
+		{[]interface{}{"Blob", "A", 1}, ErrFindPathPastObject},
+		{[]interface{}{"Unknown"}, ErrWrongFieldName},
+	} {
+		_, err := DeleteFields(&o, "/o/", c.fields...)
+		failIfErrorDifferent(t, err, c.err)
+	}
+	if !reflect.DeepEqual(o, testD2()) {
+		t.Errorf("Object was modified: %v", o)
+	}
+}
+
+func TestDeleteFieldsInline(t *testing.T) {
+	// Keys of inlined attributes share the struct directory
+	o := D4{In: D3{D1: D1{A: 1, L: []int{1}}, B: 2}}
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/in/A", "/o/in/L"}}, "In", "D1")
+	if !reflect.DeepEqual(o, D4{In: D3{B: 2}}) {
+		t.Errorf("Wrong object %v", o)
+	}
+	testDeleteFields(t, &o, "/o/", Changes{Keys: []string{"/o/in/"}}, "In")
+}
+
+func testMoveFields(t *testing.T, object interface{}, format string, dst Changes, src Changes, from []interface{}, to []interface{}) {
+	d, s, err := MoveFields(object, format, from, to)
+	if err != nil {
+		t.Errorf("MoveFields(%v, %v) returned %v", from, to, err)
+		return
+	}
+	if len(s.Values) == 0 {
+		s.Values = nil
+	}
+	if !reflect.DeepEqual(*d, dst) || !reflect.DeepEqual(*s, src) {
+		t.Errorf("MoveFields(%v, %v) returned %v %v instead of %v %v", from, to, *d, *s, dst, src)
+	}
+}
+
+func TestMoveFields(t *testing.T) {
+	o := testD2()
+
+	// Renaming a map element
+	testMoveFields(t, &o, "/o/",
+		Changes{
+			Keys:   []string{"/o/elems/2/"},
+			Values: map[string]string{"/o/elems/2/A": "6", "/o/elems/2/L": "[6,7]"},
+		},
+		Changes{Keys: []string{"/o/elems/1/"}},
+		[]interface{}{"Elems", 1}, []interface{}{"Elems", 2})
+	if _, ok := o.Elems[1]; ok || !reflect.DeepEqual(o.Elems[2], &D1{A: 6, L: []int{6, 7}}) {
+		t.Errorf("Wrong object %v", o.Elems)
+	}
+
+	// Replacing an existing map element
+	testMoveFields(t, &o, "/o/",
+		Changes{
+			Keys:   []string{"/o/blobs/b"},
+			Values: map[string]string{"/o/blobs/b": `{"A":4,"L":[4]}`},
+		},
+		Changes{Keys: []string{"/o/blobs/a"}},
+		[]interface{}{"Blobs", "a"}, []interface{}{"Blobs", "b"})
+	if !reflect.DeepEqual(o.Blobs, map[string]D1{"b": {A: 4, L: []int{4}}}) {
+		t.Errorf("Wrong object %v", o.Blobs)
+	}
+
+	// Errors
+	o = testD2()
+	for _, c := range []struct {
+		from []interface{}
+		to   []interface{}
+		err  error
+	}{
+		{[]interface{}{"Elems", 1}, []interface{}{"Elems", 1, "A"}, ErrMoveOverlap},
+		{[]interface{}{"Elems"}, []interface{}{"Elems"}, ErrMoveOverlap},
+		{[]interface{}{"Blobs", "c"}, []interface{}{"Blobs", "d"}, ErrFindObjectNotFound},
+		{[]interface{}{"Blobs", "a"}, []interface{}{"Sub", "A"}, ErrFindSetWrongType},
+		{[]interface{}{"Blobs", "a"}, []interface{}{"Blobs", 2}, ErrFindKeyWrongType},
+		{[]interface{}{"Blobs", "a"}, []interface{}{"Blob", "L", 0}, ErrFindPathPastObject},
+	} {
+		_, _, err := MoveFields(&o, "/o/", c.from, c.to)
+		failIfErrorDifferent(t, err, c.err)
+	}
+	if !reflect.DeepEqual(o, testD2()) {
+		t.Errorf("Object was modified: %v", o)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -39,10 +41,30 @@ var ErrScalarType = errors.New("Cannot recursively store scalar type")
 var ErrTagFirstSlash = errors.New("Structure field tag cannot start with /")
 var ErrFindKeyWrongType = errors.New("Provided map key field is of wrong type")
 var ErrNotMapIndex = errors.New("Specified object is not a map index")
+var ErrFieldSkipped = errors.New("Provided field is not stored")
+var ErrInlineType = errors.New("Only struct fields can be inlined")
+var ErrFieldOption = errors.New("Option can only be used in struct field tags")
+var ErrFormatEmptySegment = errors.New("Format can only contain an empty element in first or last position")
+var ErrFormatKeySegment = errors.New("Format '{key}' and '{index}' must be whole path elements")
+var ErrMapFormat = errors.New("Map format must contain a '{key}' element")
+var ErrStructFormat = errors.New("Struct format must finish with '/'")
+var ErrNotAddressable = errors.New("Object is not addressable")
+var ErrFormatOption = errors.New("Invalid format option")
+var ErrKeyConflict = errors.New("Key is already used by another value")
+var ErrKeyOrder = errors.New("Key is not stored in order")
+var ErrMoveOverlap = errors.New("Cannot move an object within itself")
 
 // State storing keys and values before they get stored for one or multiple objects
 type encodeState struct {
 	kvs map[string]string
+
+	// Keys of the empty fields which were omitted.
+	omitted []string
+
+	// When set, keys are provided to the sink in increasing order, instead of being stored in kvs and omitted.
+	sink    KVSink
+	started bool
+	lastKey string
 }
 
 // State representing an object as well as its path in some parent opbjects.
@@ -78,6 +100,142 @@ type objectPath struct {
 	// When setting a value, traversing a map will make a value non-addressible.
 	// We have to remember which is the last crossed map, such as to make the traversal addressable if necessary.
 	lastMapIndirection *objectPath
+
+	// The codec used to serialize values, or nil for the default codec.
+	codec Codec
+
+	// When non-zero, integer map keys are zero-padded to this width.
+	keyWidth int
+
+	// Whether the object is a struct field which is not stored when empty.
+	omitEmpty bool
+
+	// Whether the object keys are decoded by a KVUnmarshaler.
+	custom bool
+}
+
+// Options given after the path of a format, separated by commas (e.g. "path/{key}/,codec=text").
+type formatOptions struct {
+	// Name of the codec used for values stored at and below this format.
+	codec string
+
+	// Width of integer map keys stored at and below this format.
+	keyWidth int
+
+	// The following options are only valid in struct field tags.
+
+	// Do not store the field when it is empty.
+	omitEmpty bool
+
+	// Store the fields of the struct field as if they were fields of the parent struct.
+	inline bool
+
+	// Alternative paths accepted when decoding the field.
+	aliases []string
+}
+
+// Splits a format into its path and its options.
+func parseFormat(format string) ([]string, formatOptions, error) {
+	var opts formatOptions
+	parts := strings.Split(format, ",")
+	for _, opt := range parts[1:] {
+		switch {
+		case strings.HasPrefix(opt, "codec="):
+			opts.codec = opt[len("codec="):]
+		case strings.HasPrefix(opt, "keywidth="):
+			w, err := strconv.Atoi(opt[len("keywidth="):])
+			if err != nil || w <= 0 {
+				return nil, opts, fmt.Errorf("%w: invalid key width '%s'", ErrFormatOption, opt)
+			}
+			opts.keyWidth = w
+		case opt == "omitempty":
+			opts.omitEmpty = true
+		case opt == "inline":
+			opts.inline = true
+		case strings.HasPrefix(opt, "alias="):
+			alias := opt[len("alias="):]
+			for _, e := range strings.Split(alias, "/") {
+				if e == "" || strings.Contains(e, "{") {
+					return nil, opts, fmt.Errorf("%w: invalid alias '%s'", ErrFormatOption, opt)
+				}
+			}
+			opts.aliases = append(opts.aliases, alias)
+		default:
+			return nil, opts, fmt.Errorf("%w: unknown option '%s'", ErrFormatOption, opt)
+		}
+	}
+	path := strings.Split(parts[0], "/")
+	return path, opts, validateFormat(path)
+}
+
+// Checks the format grammar, independently of the stored object type.
+//
+// A format is a list of elements separated by '/'. Each element is either:
+// - A static key path element, stored as is.
+// - "{key}", replaced with a map key. The rest of the format applies to the map values,
+// such that nested maps are stored with one "{key}" per level (e.g. "tenants/{key}/services/{key}/").
+// Static elements may follow "{key}" (e.g. "{key}/meta/").
+// - "{index}", replaced with an array or slice index.
+// - "" in last position (i.e. the format finishes with '/'), meaning the object is stored recursively.
+// - "" in first position, when the format starts with '/'.
+func validateFormat(format []string) error {
+	for i, e := range format {
+		if e == "" && i != 0 && i != len(format)-1 {
+			return ErrFormatEmptySegment
+		}
+		if e != "{key}" && e != "{index}" && (strings.Contains(e, "{key}") || strings.Contains(e, "{index}")) {
+			return ErrFormatKeySegment
+		}
+	}
+	return nil
+}
+
+// Applies the format options to the path.
+func (o *objectPath) setOptions(opts formatOptions) error {
+	if opts.codec != "" {
+		c, err := getCodec(opts.codec)
+		if err != nil {
+			return err
+		}
+		o.codec = c
+	}
+	if opts.keyWidth != 0 {
+		o.keyWidth = opts.keyWidth
+	}
+	return nil
+}
+
+// Returns the path to the root of an object stored with the given format string or Layout.
+func rootObjectPath(object interface{}, format interface{}) (objectPath, error) {
+	o := objectPath{
+		value:   reflect.ValueOf(object),
+		vtype:   reflect.TypeOf(object),
+		keypath: []string{},
+	}
+
+	var path []string
+	var opts formatOptions
+	switch f := format.(type) {
+	case *Layout:
+		err := f.Validate(object)
+		if err != nil {
+			return o, err
+		}
+		path, opts = f.path, f.opts
+	case string:
+		var err error
+		path, opts, err = parseFormat(f)
+		if err != nil {
+			return o, err
+		}
+		if opts.omitEmpty || opts.inline || len(opts.aliases) != 0 {
+			return o, ErrFieldOption
+		}
+	default:
+		return o, ErrFormatType
+	}
+	o.format = path
+	return o, o.setOptions(opts)
 }
 
 type findOptions struct {
@@ -96,66 +254,191 @@ type findOptions struct {
 
 	// Next time a map entry is crossed, it will be made addressable for the rest of the way
 	MakeMapAddressable bool
+
+	// When set, the searched key is being deleted, which is notified to KVUnmarshaler objects.
+	DeleteValue bool
 }
 
-// Returns the format
-func getStructFieldFormat(f reflect.StructField) ([]string, error) {
+// Returns whether a struct field is stored.
+// Unexported fields and fields tagged with `kvs:"-"` are not stored.
+func isStoredField(f reflect.StructField) bool {
+	return f.PkgPath == "" && f.Tag.Get("kvs") != "-"
+}
+
+// Returns the format and options of a struct field
+func getStructFieldFormat(f reflect.StructField) ([]string, formatOptions, error) {
 	tag := f.Tag.Get("kvs")
 	if tag == "" {
-		return []string{f.Name}, nil
+		return []string{f.Name}, formatOptions{}, nil
 	} else if tag[:1] == "/" {
-		return nil, ErrTagFirstSlash
-	} else {
-		return strings.Split(tag, "/"), nil
+		return nil, formatOptions{}, ErrTagFirstSlash
 	}
-}
 
-func serializeValue(v reflect.Value) (string, error) {
-	if v.Type().Kind() == reflect.String {
-		return v.Interface().(string), nil
+	format, opts, err := parseFormat(tag)
+	if err != nil {
+		return nil, opts, err
 	}
+	if opts.inline {
+		if f.Type.Kind() != reflect.Struct || len(format) != 1 || format[0] != "" {
+			return nil, opts, ErrInlineType
+		}
+		// The struct attributes are stored directly following the parent path
+		return format, opts, nil
+	}
+	if len(format) == 1 && format[0] == "" {
+		// Only options are provided
+		format[0] = f.Name
+	}
+	return format, opts, nil
+}
 
-	arr, err := json.Marshal(v.Interface())
-	if err != nil {
-		return "", err
+// Returns the format of a struct field when decoded from one of its aliases.
+// The alias replaces the static prefix of the field format (i.e. before any "", "{key}" or "{index}").
+func aliasFormat(format []string, alias string) []string {
+	i := 0
+	for i < len(format) && format[i] != "" && format[i] != "{key}" && format[i] != "{index}" {
+		i++
 	}
-	return string(arr), nil
+	return append(strings.Split(alias, "/"), format[i:]...)
 }
 
-func unserializeValue(val string, t reflect.Type) (reflect.Value, error) {
-	v := reflect.New(t).Elem()
-	if t.Kind() == reflect.String {
-		v.Set(reflect.ValueOf(val))
-		return v, nil
+func serializeValue(v reflect.Value, codec Codec) (string, error) {
+	if v.Kind() == reflect.Interface {
+		return serializeInterface(v)
+	}
+	if codec == nil {
+		codec = jsonCodec{}
 	}
+	return codec.Marshal(v.Interface())
+}
 
-	err := json.Unmarshal([]byte(val), v.Addr().Interface())
+func unserializeValue(val string, t reflect.Type, codec Codec) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface {
+		return unserializeInterface(val, t)
+	}
+	if codec == nil {
+		codec = jsonCodec{}
+	}
+	v := reflect.New(t)
+	err := codec.Unmarshal(val, v.Interface())
 	if err != nil {
 		return reflect.Zero(t), err
 	}
-	return v, nil
+	return v.Elem(), nil
 }
 
-func serializeMapKey(v reflect.Value) (string, error) {
-	if v.Type().Kind() == reflect.String {
-		return v.Interface().(string), nil
+// Escapes the characters which can't be used within a key path element:
+// '/' is stored as "%2F", and '%' as "%25" when followed by two hexadecimal digits.
+// Other '%' characters are stored as is, such that keys stored before escaping was
+// introduced (e.g. "50%") are still read.
+func escapeMapKey(s string) string {
+	if strings.IndexByte(s, '/') < 0 && strings.IndexByte(s, '%') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '/':
+			b.WriteString("%2F")
+		case s[i] == '%' && i+2 < len(s) && isHexDigit(s[i+1]) && isHexDigit(s[i+2]):
+			b.WriteString("%25")
+		default:
+			b.WriteByte(s[i])
+		}
 	}
+	return b.String()
+}
 
-	arr, err := json.Marshal(v.Interface())
-	if err != nil {
-		return "<ERROR>", err
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Returns the key path element storing a map key.
+//
+// Keys implementing encoding.TextMarshaler and encoding.TextUnmarshaler are stored in text form,
+// strings are stored as is, integers are stored in decimal form (zero-padded to keyWidth when non-zero),
+// and other keys are stored as JSON.
+// '/' is percent-encoded such that the key always is a single key path element (see escapeMapKey).
+func serializeMapKey(v reflect.Value, keyWidth int) (string, error) {
+	var s string
+	switch {
+	case isTextType(v.Type()):
+		text, err := jsonCodec{}.Marshal(v.Interface())
+		if err != nil {
+			return "<ERROR>", err
+		}
+		s = text
+	case v.Kind() == reflect.String:
+		s = v.String()
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		s = padMapKey(strconv.FormatInt(v.Int(), 10), keyWidth)
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uintptr:
+		s = padMapKey(strconv.FormatUint(v.Uint(), 10), keyWidth)
+	default:
+		arr, err := json.Marshal(v.Interface())
+		if err != nil {
+			return "<ERROR>", err
+		}
+		s = string(arr)
+	}
+	return escapeMapKey(s), nil
+}
+
+// Pads an integer with leading zeros, after its sign if negative.
+func padMapKey(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	zeros := strings.Repeat("0", width-len(s))
+	if s[0] == '-' {
+		return "-" + zeros + s[1:]
 	}
-	return string(arr), nil
+	return zeros + s
+}
+
+// Reverts the escaping done by serializeMapKey.
+// Only "%25" and "%2F" are escape sequences, other '%' characters are kept as is.
+func unescapeMapKey(s string) string {
+	if strings.IndexByte(s, '%') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "%25"):
+			b.WriteByte('%')
+			i += 2
+		case strings.HasPrefix(s[i:], "%2F"):
+			b.WriteByte('/')
+			i += 2
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
 }
 
 func unserializeMapKey(s string, t reflect.Type) (reflect.Value, error) {
+	s = unescapeMapKey(s)
+
+	var err error
 	v := reflect.New(t).Elem()
-	if t.Kind() == reflect.String {
-		v.Set(reflect.ValueOf(s))
-		return v, nil
+	switch {
+	case isTextType(t):
+		err = jsonCodec{}.Unmarshal(s, v.Addr().Interface())
+	case t.Kind() == reflect.String:
+		v.SetString(s)
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, t.Bits())
+		v.SetInt(i)
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr:
+		var i uint64
+		i, err = strconv.ParseUint(s, 10, t.Bits())
+		v.SetUint(i)
+	default:
+		err = json.Unmarshal([]byte(s), v.Addr().Interface())
 	}
-
-	err := json.Unmarshal([]byte(s), v.Addr().Interface())
 	if err != nil {
 		return reflect.Zero(t), err
 	}
@@ -163,44 +446,101 @@ func unserializeMapKey(s string, t reflect.Type) (reflect.Value, error) {
 }
 
 func (state *encodeState) encodeStruct(o objectPath) error {
+	if len(o.format) != 1 || o.format[0] != "" {
+		return o.wrap(ErrStructFormat)
+	}
+
 	v := o.value
-	for i := 0; i < v.NumField(); i++ {
-		f := v.Type().Field(i)
-		if f.PkgPath != "" {
-			// Attribute is not exported
+	plan := getStructPlan(v.Type())
+	if state.sink != nil {
+		if plan.ordered == nil {
+			// Keys of different fields may be interleaved
+			return state.buffer(func(inner *encodeState) error {
+				return inner.encodeStruct(o)
+			})
+		}
+		return state.encodeStructOrdered(o, plan)
+	}
+
+	codec, keyWidth, fields := o.codec, o.keyWidth, o.fields
+	var inlined []*fieldPlan
+	for i := range plan.fields {
+		fp := &plan.fields[i]
+		if fp.opts.inline {
+			// Inlined fields are stored after the struct own fields
+			inlined = append(inlined, fp)
 			continue
 		}
 
-		format, err := getStructFieldFormat(f)
+		o.value, o.vtype, o.fields = v.Field(fp.index), fp.ftype, append(fields, fp.name)
+		o.codec, o.keyWidth = codec, keyWidth
+		err := o.setFieldPlan(fp)
+		if err != nil {
+			return o.wrap(err)
+		}
+
+		err = state.encode(o)
 		if err != nil {
 			return err
 		}
+	}
+	if len(inlined) == 0 {
+		return nil
+	}
 
-		o.value = v.Field(i)
-		o.format = format
+	// Keys used by the struct own fields shadow the keys of inlined fields
+	shadowed := make(map[string]bool)
+	for _, k := range state.omitted {
+		shadowed[k] = true
+	}
+	for _, fp := range inlined {
+		o.value, o.vtype, o.fields = v.Field(fp.index), fp.ftype, append(fields, fp.name)
+		o.codec, o.keyWidth = codec, keyWidth
+		err := o.setFieldPlan(fp)
+		if err != nil {
+			return o.wrap(err)
+		}
 
-		err = state.encode(o)
+		inner := &encodeState{
+			kvs: make(map[string]string),
+		}
+		err = inner.encode(o)
 		if err != nil {
 			return err
 		}
+		for k, val := range inner.kvs {
+			if _, ok := state.kvs[k]; !ok && !shadowed[k] {
+				state.kvs[k] = val
+			}
+		}
+		for _, k := range inner.omitted {
+			if _, ok := state.kvs[k]; !ok && !shadowed[k] {
+				state.omitted = append(state.omitted, k)
+			}
+		}
 	}
 	return nil
 }
 
 func (state *encodeState) encodeMap(o objectPath) error {
 	if len(o.format) == 0 || o.format[0] != "{key}" {
-		return fmt.Errorf("Map format must contain a '{key}' element")
+		return o.wrap(ErrMapFormat)
 	}
 	o.format = o.format[1:] //Remove "{key}" from format
+	o.omitEmpty = false
 
 	v := o.value
+	if state.sink != nil {
+		return state.encodeMapOrdered(o)
+	}
+	fields := o.fields
 	for _, k := range v.MapKeys() {
-		key_string, err := serializeMapKey(k)
+		key_string, err := serializeMapKey(k, o.keyWidth)
 		if err != nil {
-			return err
+			return o.wrap(err)
 		}
 
-		o.value = reflect.Indirect(v.MapIndex(k))
+		o.value, o.vtype, o.fields = reflect.Indirect(v.MapIndex(k)), mapElemType(v.Type()), append(fields, k.Interface())
 		o.keypath = append(o.keypath, key_string)
 		err = state.encode(o)
 		if err != nil {
@@ -211,27 +551,59 @@ func (state *encodeState) encodeMap(o objectPath) error {
 	return nil
 }
 
-func (state *encodeState) encodeJson(o objectPath) error {
-	key := strings.Join(o.keypath, "/")
-	if v, ok := state.kvs[key]; ok {
-		return fmt.Errorf("Key '%s' is already used by value '%s'", key, v)
+// Returns the type of the elements of a map, which are dereferenced when they are pointers.
+func mapElemType(t reflect.Type) reflect.Type {
+	t = t.Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	return t
+}
 
-	val, err := serializeValue(o.value)
+func (state *encodeState) encodeJson(o objectPath) error {
+	val, err := serializeValue(o.value, o.codec)
 	if err != nil {
-		return err
+		return o.wrap(err)
 	}
+	return state.set(strings.Join(o.keypath, "/"), val)
+}
 
-	state.kvs[key] = val
-	return nil
+// Records the key of an empty object which is not stored.
+func (state *encodeState) omit(o objectPath) error {
+	for len(o.format) != 0 && o.format[0] != "" && o.format[0] != "{key}" && o.format[0] != "{index}" {
+		o.keypath = append(o.keypath, o.format[0])
+		o.format = o.format[1:]
+	}
+	key := strings.Join(o.keypath, "/")
+	if len(o.format) != 0 {
+		// The object is stored as multiple keys
+		key += "/"
+	}
+	return state.omitKey(key)
 }
 
 func (state *encodeState) encode(o objectPath) error {
+	if o.omitEmpty {
+		if isEmptyValue(o.value) {
+			return state.omit(o)
+		}
+		o.omitEmpty = false
+	}
+
 	if o.value.Type().Kind() == reflect.Ptr {
-		o.value = o.value.Elem()
+		if o.value.IsNil() {
+			// Nothing to store
+			return nil
+		}
+		o.value, o.vtype = o.value.Elem(), o.vtype.Elem()
 		return state.encode(o)
 	}
 
+	if o.value.Type().Kind() == reflect.Interface && o.value.IsNil() {
+		// Nothing to store
+		return nil
+	}
+
 	for len(o.format) != 0 {
 		if o.format[0] == "" || o.format[0] == "{key}" || o.format[0] == "{index}" {
 			break
@@ -240,6 +612,10 @@ func (state *encodeState) encode(o objectPath) error {
 		o.format = o.format[1:]
 	}
 
+	if m, ok := getKVMarshaler(o.value); ok {
+		return state.encodeMarshaler(o, m)
+	}
+
 	if len(o.format) == 0 {
 		// This element is stored as blob
 		return state.encodeJson(o)
@@ -251,13 +627,13 @@ func (state *encodeState) encode(o objectPath) error {
 	case reflect.Map:
 		return state.encodeMap(o)
 	case reflect.Slice:
-		return ErrNotImplemented
+		return o.wrap(ErrNotImplemented)
 	case reflect.Array:
-		return ErrNotImplemented
+		return o.wrap(ErrNotImplemented)
 	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Invalid, reflect.UnsafePointer:
-		return ErrUnsupportedType
+		return o.wrap(ErrUnsupportedType)
 	default:
-		return ErrScalarType
+		return o.wrap(ErrScalarType)
 	}
 }
 
@@ -266,21 +642,22 @@ func findByFieldsMap(o objectPath, fields []interface{}, opt findOptions) (objec
 	o.lastMapIndirection = &o2
 
 	if len(o.format) == 0 || o.format[0] != "{key}" {
-		return o, fmt.Errorf("Map format must contain a '{key}' element")
+		return o, o.wrap(ErrMapFormat)
 	}
-	o.format = o.format[1:] //Remove "{key}" from format
-
 	key_type := o.vtype.Key()
 	key := reflect.ValueOf(fields[0])
 	if key.Type() != key_type {
-		return o, ErrFindKeyWrongType
+		return o, o.wrap(ErrFindKeyWrongType)
 	}
 
-	keystr, err := serializeMapKey(key)
+	keystr, err := serializeMapKey(key, o.keyWidth)
 	if err != nil {
-		return o, err
+		return o, o.wrap(err)
 	}
 
+	o.format = o.format[1:] //Remove "{key}" from format
+	o.omitEmpty = false
+
 	m := o.value
 	if o.value.IsValid() {
 
@@ -335,28 +712,35 @@ func findByFieldsMap(o objectPath, fields []interface{}, opt findOptions) (objec
 }
 
 func findByFieldsStruct(o objectPath, fields []interface{}, opt findOptions) (objectPath, error) {
+	if len(o.format) != 1 || o.format[0] != "" {
+		return o, o.wrap(ErrStructFormat)
+	}
 
 	name, ok := fields[0].(string)
 	if !ok {
-		return o, ErrWrongFieldType
+		return o, o.wrap(ErrWrongFieldType)
 	}
 	fields = fields[1:]
 
-	f, ok := o.vtype.FieldByName(name)
+	plan := getStructPlan(o.vtype)
+	i, ok := plan.byName[name]
 	if !ok {
-		return o, ErrWrongFieldName
+		if f, ok := o.vtype.FieldByName(name); ok && len(f.Index) == 1 {
+			return o, o.wrap(ErrFieldSkipped)
+		}
+		return o, o.wrap(ErrWrongFieldName)
 	}
+	fp := &plan.fields[i]
 
-	format, err := getStructFieldFormat(f)
+	err := o.setFieldPlan(fp)
 	if err != nil {
-		return o, err
+		return o, o.wrap(err)
 	}
 
 	if o.value.IsValid() {
-		o.value = o.value.FieldByIndex(f.Index)
+		o.value = o.value.Field(fp.index)
 	}
-	o.vtype = o.vtype.FieldByIndex(f.Index).Type
-	o.format = format
+	o.vtype = fp.ftype
 	o.fields = append(o.fields, name)
 
 	return findByFields(o, fields, opt)
@@ -404,7 +788,7 @@ func findByFieldsFormat(o objectPath, fields []interface{}) (objectPath, []inter
 
 func findByFieldsRevertAddressable(o objectPath, fields []interface{}, opt findOptions) (objectPath, error) {
 	if o.lastMapIndirection == nil {
-		return o, fmt.Errorf("Object is not addressable")
+		return o, o.wrap(ErrNotAddressable)
 	}
 
 	fields = append(o.fields[len(o.lastMapIndirection.fields):], fields...) // Reconstruct the fields before they were consumed
@@ -421,7 +805,7 @@ func findByFieldsSetMaybe(o objectPath, fields []interface{}, opt findOptions) (
 
 	// Can only set if the value exists (opt.Create should be set if intent is to create too)
 	if !o.value.IsValid() {
-		return o, ErrFindSetNoExists
+		return o, o.wrap(ErrFindSetNoExists)
 	}
 
 	// If object cannot be set, try to rollback
@@ -433,12 +817,12 @@ func findByFieldsSetMaybe(o objectPath, fields []interface{}, opt findOptions) (
 	var err error
 	// If set by string, parse the string
 	if opt.SetObject == nil {
-		value, err = unserializeValue(*opt.SetValue, o.vtype)
+		value, err = unserializeValue(*opt.SetValue, o.vtype, o.codec)
 		if err != nil {
 			if opt.IgnoreUnmarshalFailure {
 				value = reflect.New(o.vtype)
 			} else {
-				return o, err
+				return o, o.wrap(err)
 			}
 		}
 	} else {
@@ -446,8 +830,8 @@ func findByFieldsSetMaybe(o objectPath, fields []interface{}, opt findOptions) (
 	}
 
 	// Check the type
-	if value.Type() != o.vtype {
-		return o, ErrFindSetWrongType
+	if value.Type() != o.vtype && (o.vtype.Kind() != reflect.Interface || !value.Type().AssignableTo(o.vtype)) {
+		return o, o.wrap(ErrFindSetWrongType)
 	}
 
 	// Set the value
@@ -480,7 +864,7 @@ func findByFields(o objectPath, fields []interface{}, opt findOptions) (objectPa
 		// return it with the reduced key.
 		// For now let's just return an error.
 		if len(fields) != 0 {
-			return o, ErrFindPathPastObject
+			return o, o.wrap(ErrFindPathPastObject)
 		}
 	}
 
@@ -490,33 +874,32 @@ func findByFields(o objectPath, fields []interface{}, opt findOptions) (objectPa
 	case reflect.Map:
 		return findByFieldsMap(o, fields, opt)
 	case reflect.Slice:
-		return o, ErrNotImplemented
+		return o, o.wrap(ErrNotImplemented)
 	case reflect.Array:
-		return o, ErrNotImplemented
+		return o, o.wrap(ErrNotImplemented)
 	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Invalid, reflect.UnsafePointer:
-		return o, ErrUnsupportedType
+		return o, o.wrap(ErrUnsupportedType)
 	default:
-		return o, ErrScalarType
+		return o, o.wrap(ErrScalarType)
 	}
 }
 
 // Finds a sub-object based on the path of successive fields.
 //
 // Returns the found object, its path, and possibly an error.
-func FindByFields(object interface{}, format string, fields []interface{}) (interface{}, string, error) {
-	o := objectPath{
-		value:  reflect.ValueOf(object),
-		vtype:  reflect.TypeOf(object),
-		format: strings.Split(format, "/"),
+func FindByFields(object interface{}, format interface{}, fields []interface{}) (interface{}, string, error) {
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, "", err
 	}
 
-	o, err := findByFields(o, fields, findOptions{})
+	o, err = findByFields(o, fields, findOptions{})
 	if err != nil {
 		return nil, "", err
 	}
 
 	if !o.value.IsValid() {
-		return nil, "", ErrFindKeyNotFound
+		return nil, "", o.wrap(ErrFindKeyNotFound)
 	}
 
 	if !o.value.CanAddr() {
@@ -533,23 +916,37 @@ func FindByFields(object interface{}, format string, fields []interface{}) (inte
 // Structure attributes are identified by name (as a string).
 // Slice indexes are identified with integers.
 // Map keys are identified by given an object of the same type than the map key.
-func Encode(format string, object interface{}, fields ...interface{}) (map[string]string, error) {
+func Encode(format interface{}, object interface{}, fields ...interface{}) (map[string]string, error) {
+	kvs, _, err := EncodeWithDeletions(format, object, fields...)
+	return kvs, err
+}
 
-	formatpath := strings.Split(format, "/")
+// EncodeWithDeletions works like Encode, but also returns the keys of the empty fields
+// which were omitted because of the 'omitempty' tag option, and should therefore be deleted.
+// Keys of fields stored as multiple keys finish with '/'.
+func EncodeWithDeletions(format interface{}, object interface{}, fields ...interface{}) (map[string]string, []string, error) {
+	if g, prefix, ok := generatedObject(object, format); ok && len(fields) == 0 {
+		kvs := make(map[string]string)
+		var omitted []string
+		err := g.EncodeKV(prefix, kvs, &omitted)
+		if err != nil {
+			return nil, nil, keyError(prefix, nil, err)
+		}
+		sort.Strings(omitted)
+		return kvs, omitted, nil
+	}
 
-	o := objectPath{
-		value:   reflect.ValueOf(object),
-		vtype:   reflect.TypeOf(object),
-		format:  formatpath,
-		keypath: []string{},
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	o, err := findByFields(o, fields, findOptions{})
+	o, err = findByFields(o, fields, findOptions{})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if !o.value.IsValid() {
-		return nil, ErrFindObjectNotFound
+		return nil, nil, o.wrap(ErrFindObjectNotFound)
 	}
 
 	state := &encodeState{
@@ -557,49 +954,64 @@ func Encode(format string, object interface{}, fields ...interface{}) (map[strin
 	}
 	err = state.encode(o)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return state.kvs, nil
+	sort.Strings(state.omitted)
+	return state.kvs, state.omitted, nil
 }
 
 // Find sub-object from struct per its key
 // Returns the found object, the consumed key path
 func findByKeyOneStruct(o objectPath, path []string, opt findOptions) (objectPath, error) {
-	if len(o.format) != 1 && o.format[0] != "" {
-		return o, fmt.Errorf("Struct object expect [\"\"] format")
+	if len(o.format) != 1 || o.format[0] != "" {
+		return o, o.wrap(ErrStructFormat)
 	}
 
+	parent := o
 	v := o.value
 	t := o.vtype
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.PkgPath != "" {
-			// Attribute is not exported
-			continue
-		}
-
-		format, err := getStructFieldFormat(f)
+	plan := getStructPlan(t)
+	codec, keyWidth := o.codec, o.keyWidth
+	for _, i := range plan.lookup {
+		fp := &plan.fields[i]
+		o.codec, o.keyWidth = codec, keyWidth
+		err := o.setFieldPlan(fp)
 		if err != nil {
-			return o, err
+			return o, o.wrap(err)
 		}
 
 		if v.IsValid() {
-			o.value = v.Field(i) // Get field if value exists
+			o.value = v.Field(fp.index) // Get field if value exists
+		}
+		o.vtype = fp.ftype // Get attribute type
+
+		if fp.opts.inline {
+			// Inlined fields are looked up after the struct own fields
+			o2 := o
+			o2.fields = append(o.fields[:len(o.fields):len(o.fields)], fp.name)
+			o2, err = findByKey(o2, path, opt)
+			if !errors.Is(err, ErrFindPathNotFound) {
+				return o2, err
+			}
+			continue
 		}
-		o.vtype = f.Type // Get attribute type
-		o.format = format
 
-		// First see if the format corresponds
-		o2, path2, err := findByKeyFormat(o, path)
-		if err == nil {
-			// We can fully look in there
-			o2.fields = append(o2.fields, f.Name)
-			return findByKey(o2, path2, opt)
+		// First see if the format, or one of its aliases, corresponds
+		for j := -1; j < len(fp.aliases); j++ {
+			if j >= 0 {
+				o.format = fp.aliases[j]
+			}
+			o2, path2, err := findByKeyFormat(o, path)
+			if err == nil {
+				// We can fully look in there
+				o2.fields = append(o2.fields, fp.name)
+				return findByKey(o2, path2, opt)
+			}
 		}
 		// Let's continue searching
 	}
-	return o, ErrFindPathNotFound
+	return o, parent.wrap(ErrFindPathNotFound)
 }
 
 // Finds a sub-object inside a map with the provided object format (e.g. {key}, {key}/, {key}/name).
@@ -614,15 +1026,14 @@ func findByKeyOneMap(o objectPath, path []string, opt findOptions) (objectPath,
 	o.lastMapIndirection = &o2
 
 	if len(o.format) == 0 || o.format[0] != "{key}" {
-		return o, fmt.Errorf("Map format must contain a '{key}' element")
+		return o, o.wrap(ErrMapFormat)
 	}
-	o.format = o.format[1:] // Consume {key} format
-
 	// Consume key
 	keyvalue, err := unserializeMapKey(path[0], o.vtype.Key())
 	if err != nil {
-		return o, err
+		return o, o.wrap(err)
 	}
+	o.format = o.format[1:] // Consume {key} format
 
 	m := o.value
 	if o.value.IsValid() {
@@ -722,7 +1133,7 @@ func findByKeyFormat(o objectPath, path []string) (objectPath, []string, error)
 // and restart while asking for the rest of the process to be addressable.
 func findByKeyRevertAddressable(o objectPath, path []string, opt findOptions) (objectPath, error) {
 	if o.lastMapIndirection == nil {
-		return o, fmt.Errorf("Object is not addressable")
+		return o, o.wrap(ErrNotAddressable)
 	}
 
 	path = append(o.keypath[len(o.lastMapIndirection.keypath):], path...) // Reconstruct the keypath before it was consumed
@@ -739,7 +1150,7 @@ func findByKeySetMaybe(o objectPath, path []string, opt findOptions) (objectPath
 
 	// Can only set if the value exists (opt.Create should be set if intent is to create too)
 	if !o.value.IsValid() {
-		return o, ErrFindSetNoExists
+		return o, o.wrap(ErrFindSetNoExists)
 	}
 
 	// If object cannot be set, try to rollback
@@ -751,12 +1162,12 @@ func findByKeySetMaybe(o objectPath, path []string, opt findOptions) (objectPath
 	var err error
 	// If set by string, parse the string
 	if opt.SetObject == nil {
-		value, err = unserializeValue(*opt.SetValue, o.vtype)
+		value, err = unserializeValue(*opt.SetValue, o.vtype, o.codec)
 		if err != nil {
 			if opt.IgnoreUnmarshalFailure {
 				value = reflect.New(o.vtype).Elem()
 			} else {
-				return o, err
+				return o, o.wrap(err)
 			}
 		}
 	} else {
@@ -765,7 +1176,7 @@ func findByKeySetMaybe(o objectPath, path []string, opt findOptions) (objectPath
 
 	// Check the type
 	if value.Type() != o.vtype {
-		return o, ErrFindSetWrongType
+		return o, o.wrap(ErrFindSetWrongType)
 	}
 
 	// Set the value
@@ -782,21 +1193,25 @@ func findByKey(o objectPath, path []string, opt findOptions) (objectPath, error)
 	// Go through format prefixing element (before "", "{key}" or "{index}")
 	o, path, err := findByKeyFormat(o, path)
 	if err != nil {
-		return o, err
+		return o, o.wrap(err)
+	}
+
+	if isKVUnmarshaler(o.vtype) {
+		return findByKeyUnmarshaler(o, path, opt)
 	}
 
 	if len(o.format) == 0 {
 		// The object is supposed to be encoded as a blob
 		if len(path) != 0 {
 			// Path is too specific and therefore does not correspond to an encoded object.
-			return o, ErrFindPathPastObject
+			return o, o.wrap(ErrFindPathPastObject)
 		}
 		return findByKeySetMaybe(o, path, opt)
 	}
 
 	if len(path) == 0 || (path[0] == "" && len(path) != 1) {
 		// We reached the end of the requested path but the object expects more.
-		return o, ErrFindKeyInvalid
+		return o, o.wrap(ErrFindKeyInvalid)
 	}
 
 	if path[0] == "" {
@@ -809,38 +1224,37 @@ func findByKey(o objectPath, path []string, opt findOptions) (objectPath, error)
 	case reflect.Map:
 		return findByKeyOneMap(o, path, opt)
 	case reflect.Slice:
-		return o, ErrNotImplemented
+		return o, o.wrap(ErrNotImplemented)
 	case reflect.Array:
-		return o, ErrNotImplemented
+		return o, o.wrap(ErrNotImplemented)
 	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Invalid, reflect.UnsafePointer:
-		return o, ErrUnsupportedType
+		return o, o.wrap(ErrUnsupportedType)
 	default:
-		return o, ErrScalarType
+		return o, o.wrap(ErrScalarType)
 	}
 }
 
 // FindByKey returns a sub-object by following the provided path.
 //
-// 'format' is the provided object key formatting string,
+// 'format' is the provided object key formatting string (or its compiled Layout),
 // equivalent to the attribute 'kvs' tags from struct fields.
 // For most types, providing a format is optional.
 //
 // Note that the provided path should include the format, or specific values
 // used by the format. For instance, if the format is "here/{key}/there/", then
 // the path should start with "here/<some-key-value>/there/".
-func FindByKey(o interface{}, format string, path string) (interface{}, []interface{}, error) {
-	op := objectPath{
-		value:  reflect.ValueOf(o),
-		vtype:  reflect.TypeOf(o),
-		format: strings.Split(format, "/"),
+func FindByKey(o interface{}, format interface{}, path string) (interface{}, []interface{}, error) {
+	op, err := rootObjectPath(o, format)
+	if err != nil {
+		return nil, nil, err
 	}
-	op, err := findByKey(op, strings.Split(path, "/"), findOptions{})
+	op, err = findByKey(op, strings.Split(path, "/"), findOptions{})
 	if err != nil {
 		return nil, nil, err
 	}
 
 	if !op.value.IsValid() {
-		return nil, nil, ErrFindKeyNotFound
+		return nil, nil, op.wrap(ErrFindKeyNotFound)
 	}
 
 	if !op.value.CanAddr() {
@@ -855,18 +1269,45 @@ func FindByKey(o interface{}, format string, path string) (interface{}, []interf
 //
 // Given an object and its format, as well as a (key, value) pair (where key is relative to the object),
 // Update modifies the object, returns the field path to the modified sub-object.
-func UpdateKeyObject(object interface{}, format string, keypath string, value string) ([]interface{}, error) {
-	o := objectPath{
-		value:  reflect.ValueOf(object),
-		vtype:  reflect.TypeOf(object),
-		format: strings.Split(format, "/"),
+//
+// By default, values which can't be decoded set the sub-object to its zero value.
+// With the StrictDecoding option, a *ValueError is returned instead and the object is not modified.
+func UpdateKeyObject(object interface{}, format interface{}, keypath string, value string, opts ...KeyOption) ([]interface{}, error) {
+	var kopt keyOptions
+	for _, opt := range opts {
+		opt(&kopt)
+	}
+
+	if t := reflect.TypeOf(object); kopt.strict && t != nil && t.Kind() == reflect.Ptr {
+		// Decode the value into a new object first, such that the object is left unchanged upon failure
+		fields, err := CheckKey(format, t.Elem(), keypath, value)
+		var verr *ValueError
+		if errors.As(err, &verr) {
+			return fields, err
+		}
+	}
+
+	if g, prefix, ok := generatedObject(object, format); ok {
+		if !strings.HasPrefix(keypath, prefix) {
+			if keypath+"/" == prefix {
+				return nil, keyError(keypath, nil, ErrFindKeyInvalid)
+			}
+			return nil, keyError(keypath, nil, ErrFindPathNotFound)
+		}
+		fields, err := g.UpdateKV(keypath[len(prefix):], value)
+		return fields, keyError(keypath, fields, err)
+	}
+
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, err
 	}
 	opt := findOptions{
 		Create:                 true,
 		SetValue:               &value,
 		IgnoreUnmarshalFailure: true,
 	}
-	o, err := findByKey(o, strings.Split(keypath, "/"), opt)
+	o, err = findByKey(o, strings.Split(keypath, "/"), opt)
 	if err != nil {
 		return nil, err
 	}
@@ -874,38 +1315,308 @@ func UpdateKeyObject(object interface{}, format string, keypath string, value st
 	return o.fields, nil
 }
 
-func DeleteKeyObject(object interface{}, format string, keypath string) ([]interface{}, error) {
-	o := objectPath{
-		value:  reflect.ValueOf(object),
-		vtype:  reflect.TypeOf(object),
-		format: strings.Split(format, "/"),
+// LookupKey returns a copy of the sub-object associated with a key, as well as its field path.
+//
+// In contrast with FindByKey, the key may designate a sub-object stored
+// as multiple keys (e.g. "map/{key}" for a map element stored with format "map/{key}/"),
+// and the sub-object does not need to exist, in which case nil is returned with the
+// field path the object would have.
+// When the field path designates a pointer, a new pointer to a copy of the pointed value is returned.
+func LookupKey(object interface{}, format interface{}, keypath string) (interface{}, []interface{}, error) {
+	if g, prefix, ok := generatedObject(object, format); ok {
+		var v interface{}
+		var fields []interface{}
+		var err error
+		if keypath+"/" == prefix {
+			v, fields, err = g.LookupKV("")
+		} else if !strings.HasPrefix(keypath, prefix) {
+			err = ErrFindPathNotFound
+		} else {
+			v, fields, err = g.LookupKV(keypath[len(prefix):])
+		}
+		return v, fields, keyError(keypath, fields, err)
 	}
 
-	opt := findOptions{}
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o, err = findByKey(o, strings.Split(keypath, "/"), findOptions{})
+	if err != nil && !errors.Is(err, ErrFindKeyInvalid) {
+		return nil, nil, err
+	}
+
+	if !o.value.IsValid() {
+		return nil, o.fields, nil
+	}
+	return pointerTo(o.value, TypeByFields(reflect.TypeOf(object), o.fields)).Interface(), o.fields, nil
+}
+
+// TypeByFields returns the type of the sub-object designated by a field path in objects of type t.
+// Pointers are dereferenced to reach the sub-object, but the returned type may be a pointer type.
+// The field path must be valid for type t (e.g. returned by LookupKey or UpdateKeyObject).
+func TypeByFields(t reflect.Type, fields []interface{}) reflect.Type {
+	for _, f := range fields {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			sf, _ := t.FieldByName(f.(string))
+			t = sf.Type
+		} else {
+			t = t.Elem()
+		}
+	}
+	return t
+}
+
+// Returns v as a value of type t, when t is a pointer type (possibly to pointers) to the type of v.
+// The pointers are new, and point to a copy of v.
+func pointerTo(v reflect.Value, t reflect.Type) reflect.Value {
+	var ptrs []reflect.Type
+	for ; t.Kind() == reflect.Ptr && t != v.Type(); t = t.Elem() {
+		ptrs = append(ptrs, t)
+	}
+	if t != v.Type() {
+		return v
+	}
+	for i := len(ptrs) - 1; i >= 0; i-- {
+		p := reflect.New(ptrs[i].Elem())
+		p.Elem().Set(v)
+		v = p
+	}
+	return v
+}
+
+// Options used when modifying an object from a key.
+type keyOptions struct {
+	// Remove map elements left empty after a deletion.
+	pruneEmpty bool
+
+	// Fail updates which value can't be decoded.
+	strict bool
+}
+
+// KeyOption alters how an object is modified from a key.
+type KeyOption func(*keyOptions)
+
+// PruneEmpty makes DeleteKeyObject remove the map elements which are left
+// empty (i.e. all their attributes have zero values, and their maps are empty)
+// after a deletion, recursively up to the object root.
+func PruneEmpty() KeyOption {
+	return func(opt *keyOptions) {
+		opt.pruneEmpty = true
+	}
+}
+
+// StrictDecoding makes UpdateKeyObject return a *ValueError, and leave the object unchanged,
+// when the value can't be decoded.
+func StrictDecoding() KeyOption {
+	return func(opt *keyOptions) {
+		opt.strict = true
+	}
+}
+
+// DeleteKeyObject transforms a key deletion into an actually modified object.
+//
+// When the key corresponds to a map element, the element is removed from the map.
+// Otherwise, the sub-object is reset to its zero value, such that pointers are set to nil.
+// The key may designate a sub-object stored as multiple keys (e.g. "map/{key}"
+// for a map element stored with format "map/{key}/"),
+// Returns the field path to the deleted sub-object.
+func DeleteKeyObject(object interface{}, format interface{}, keypath string, opts ...KeyOption) ([]interface{}, error) {
+	var kopt keyOptions
+	for _, opt := range opts {
+		opt(&kopt)
+	}
+
+	if g, prefix, ok := generatedObject(object, format); ok && !kopt.pruneEmpty {
+		var fields []interface{}
+		var err error
+		if keypath+"/" == prefix {
+			fields, err = g.DeleteKV("")
+		} else if !strings.HasPrefix(keypath, prefix) {
+			err = ErrFindPathNotFound
+		} else {
+			fields, err = g.DeleteKV(keypath[len(prefix):])
+		}
+		return fields, keyError(keypath, fields, err)
+	}
+
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := findOptions{
+		DeleteValue: true,
+	}
 	path := strings.Split(keypath, "/")
 
-	o, err := findByKey(o, path, opt)
-	if err != nil && err != ErrFindKeyInvalid {
+	o, err = findByKey(o, path, opt)
+	if err != nil && !errors.Is(err, ErrFindKeyInvalid) {
 		// Getting ErrFindKeyInvalid means the key does not represent an encoded value, which is ok in this case
 		return nil, err
 	}
+	if err == nil && o.custom {
+		// The deletion was handled by a KVUnmarshaler
+		return o.fields, nil
+	}
+
+	fields := o.fields
+	err = resetByFields(object, format, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	for n := len(fields) - 1; kopt.pruneEmpty && n > 0; n-- {
+		// Remove the parents of the deleted object which are empty map elements.
+		// Struct parents are not removed, but their own parents may be.
+		pruned, err := pruneByFields(object, format, fields[:n])
+		if err != nil {
+			return nil, err
+		}
+		if pruned {
+			fields = fields[:n]
+		}
+	}
 
-	err, _ = DeleteByFields(object, format, o.fields...)
-	return o.fields, err
+	return fields, nil
 }
 
-func SetByFields(object interface{}, format string, value interface{}, fields ...interface{}) error {
-	o := objectPath{
-		value:  reflect.ValueOf(object),
-		vtype:  reflect.TypeOf(object),
-		format: strings.Split(format, "/"),
+// Removes the designated object if it is a map element, or resets it to its zero value otherwise.
+func resetByFields(object interface{}, format interface{}, fields []interface{}) error {
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return err
+	}
+
+	if len(fields) == 0 {
+		// Resetting the whole object
+		o, err := findByFields(o, fields, findOptions{})
+		if err != nil {
+			return err
+		}
+		if !o.value.CanSet() {
+			return o.wrap(ErrNotAddressable)
+		}
+		o.value.Set(reflect.Zero(o.vtype))
+		return nil
+	}
+
+	parent, err := findByFields(o, fields[:len(fields)-1], findOptions{})
+	if err != nil {
+		return err
+	}
+	if !parent.value.IsValid() {
+		return parent.wrap(ErrFindObjectNotFound)
+	}
+
+	switch parent.vtype.Kind() {
+	case reflect.Map:
+		err, _ := DeleteByFields(object, format, fields...)
+		return err
+	case reflect.Struct:
+		name, ok := fields[len(fields)-1].(string)
+		if !ok {
+			return parent.wrap(ErrWrongFieldType)
+		}
+		f, ok := parent.vtype.FieldByName(name)
+		if !ok {
+			return parent.wrap(ErrWrongFieldName)
+		}
+		if parent.value.CanSet() {
+			parent.value.FieldByIndex(f.Index).Set(reflect.Zero(f.Type))
+			return nil
+		}
+
+		// The structure is not addressable (e.g. stored in a map), so it is replaced by a modified copy
+		c := reflect.New(parent.vtype).Elem()
+		c.Set(parent.value)
+		c.FieldByIndex(f.Index).Set(reflect.Zero(f.Type))
+		return SetByFields(object, format, c.Interface(), fields[:len(fields)-1]...)
+	default:
+		return parent.wrap(ErrFindPathPastObject)
+	}
+}
+
+// Removes the designated object if it is an empty map element.
+// Returns whether the object was removed.
+func pruneByFields(object interface{}, format interface{}, fields []interface{}) (bool, error) {
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return false, err
+	}
+
+	parent, err := findByFields(o, fields[:len(fields)-1], findOptions{})
+	if err != nil {
+		return false, err
+	}
+	if parent.vtype.Kind() != reflect.Map {
+		return false, nil
+	}
+
+	elem, err := findByFields(parent, fields[len(fields)-1:], findOptions{})
+	if err != nil {
+		return false, err
+	}
+	if !elem.value.IsValid() || !isEmptyValue(elem.value) {
+		return false, nil
+	}
+
+	err, _ = DeleteByFields(object, format, fields...)
+	return err == nil, err
+}
+
+// Returns whether a value only contains zero values and empty maps or slices.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil() || isEmptyValue(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isEmptyValue(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isEmptyValue(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() == 0
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+func SetByFields(object interface{}, format interface{}, value interface{}, fields ...interface{}) error {
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return err
 	}
 
 	opt := findOptions{
 		Create:    true,
 		SetObject: value,
 	}
-	_, err := findByFields(o, fields, opt)
+	_, err = findByFields(o, fields, opt)
 	if err != nil {
 		return err
 	}
@@ -915,26 +1626,26 @@ func SetByFields(object interface{}, format string, value interface{}, fields ..
 
 // Deletes an element from a map, which means the last element from the fields
 // list must be a key, and the previous fields must reference a map object.
-// Returns an error, or nil and the format string of the removed object
-func DeleteByFields(object interface{}, format string, fields ...interface{}) (error, string) {
-	if len(fields) < 1 {
-		return ErrNotMapIndex, ""
+// Returns an error, or nil and the format string of the removed object.
+// See DeleteFields for deleting other sub-objects.
+func DeleteByFields(object interface{}, format interface{}, fields ...interface{}) (error, string) {
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return err, ""
 	}
 
-	o := objectPath{
-		value:  reflect.ValueOf(object),
-		vtype:  reflect.TypeOf(object),
-		format: strings.Split(format, "/"),
+	if len(fields) < 1 {
+		return o.wrap(ErrNotMapIndex), ""
 	}
 
 	opt := findOptions{}
-	o, err := findByFields(o, fields[0:len(fields)-1], opt)
+	o, err = findByFields(o, fields[0:len(fields)-1], opt)
 	if err != nil {
 		return err, ""
 	}
 
 	if o.vtype.Kind() != reflect.Map {
-		return ErrNotMapIndex, ""
+		return o.wrap(ErrNotMapIndex), ""
 	}
 
 	o2, err := findByFields(o, fields[len(fields)-1:], opt)
@@ -943,7 +1654,7 @@ func DeleteByFields(object interface{}, format string, fields ...interface{}) (e
 	}
 
 	if !o2.value.IsValid() {
-		return ErrFindObjectNotFound, ""
+		return o2.wrap(ErrFindObjectNotFound), ""
 	}
 
 	key := reflect.ValueOf(fields[len(fields)-1])
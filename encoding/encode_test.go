@@ -15,9 +15,11 @@
 package encoding
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"reflect"
-	//"strings"
+	"strings"
 	"testing"
 )
 
@@ -35,7 +37,7 @@ func failIfNotError(t *testing.T, err error) {
 }
 
 func failIfErrorDifferent(t *testing.T, err error, expected error) {
-	if err != expected {
+	if !errors.Is(err, expected) {
 		fmt.Printf("FAIL::::: Error '%v' differs from expected '%v'\n", err, expected)
 		t.Errorf("Error '%v' differs from expected '%v'", err, expected)
 	}
@@ -52,7 +54,7 @@ type S2 struct {
 	B S1 `kvs:"sub/"`
 }
 
-func testEncode(t *testing.T, key string, obj interface{}, truth map[string]string, fields ...interface{}) {
+func testEncode(t *testing.T, key interface{}, obj interface{}, truth map[string]string, fields ...interface{}) {
 	m, e := Encode(key, obj, fields...)
 	if e != nil {
 		fmt.Printf("FAIL::::: Encode returned %v\n", e)
@@ -338,7 +340,7 @@ type S7 struct {
 	StringMap   map[string]string `kvs:"smap/{key}"`
 }
 
-func testUpdateKeyObject(t *testing.T, object interface{}, format string, keypath string, value string, path []interface{}) {
+func testUpdateKeyObject(t *testing.T, object interface{}, format interface{}, keypath string, value string, path []interface{}) {
 	rpath, err := UpdateKeyObject(object, format, keypath, value)
 	if err != nil {
 		t.Errorf("findByKey returned %v", err)
@@ -411,6 +413,52 @@ func TestUpdateKeyObject(t *testing.T) {
 	}
 }
 
+func TestUpdateKeyObjectStrict(t *testing.T) {
+	s := S7{I: 1}
+
+	// Lenient by default
+	testUpdateKeyObject(t, &s, "", "I", "abc", []interface{}{"I"})
+	if s.I != 0 {
+		t.Errorf("I is %d", s.I)
+	}
+
+	s.I = 1
+	fields, err := UpdateKeyObject(&s, "", "I", "abc", StrictDecoding())
+	verr, ok := err.(*ValueError)
+	if !ok {
+		t.Fatalf("UpdateKeyObject returned %v", err)
+	}
+	if verr.Key != "I" || verr.Value != "abc" || verr.Err == nil || !reflect.DeepEqual(verr.Fields, []interface{}{"I"}) {
+		t.Errorf("Unexpected error %#v", verr)
+	}
+	if !reflect.DeepEqual(fields, []interface{}{"I"}) {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if s.I != 1 {
+		t.Errorf("I was modified: %d", s.I)
+	}
+
+	// Failed updates do not create map elements
+	_, err = UpdateKeyObject(&s, "", "s6_ptr_map/a/sub/IntMap/b", "abc", StrictDecoding())
+	if _, ok := err.(*ValueError); !ok {
+		t.Errorf("UpdateKeyObject returned %v", err)
+	}
+	if len(s.S6PtrMap) != 0 {
+		t.Errorf("Map element was created: %v", s.S6PtrMap)
+	}
+
+	_, err = UpdateKeyObject(&s, "", "s6_ptr_map/a/sub/IntMap/b", "2", StrictDecoding())
+	if err != nil || s.S6PtrMap["a"].IntMap["b"] != 2 {
+		t.Errorf("Map element was not set: %v", s.S6PtrMap)
+	}
+
+	// Other errors are returned as is
+	_, err = UpdateKeyObject(&s, "", "unknown", "1", StrictDecoding())
+	if !errors.Is(err, ErrFindPathNotFound) {
+		t.Errorf("UpdateKeyObject returned %v", err)
+	}
+}
+
 type S8 struct {
 	A int `kvs:"A"`
 	B string
@@ -426,7 +474,7 @@ type S9 struct {
 
 func testFindByField(t *testing.T, o interface{}, format string, fields []interface{}, ret_format string, expected error) interface{} {
 	o, f, err := FindByFields(o, format, fields)
-	if err != expected {
+	if !errors.Is(err, expected) {
 		fmt.Printf("FAIL::::: FindByFields error '%v' instead of '%v'\n", err, expected)
 		t.Errorf("FindByFields error '%v' instead of '%v'", err, expected)
 		return nil
@@ -530,12 +578,12 @@ func TestSetByFields(t *testing.T) {
 	}
 
 	err, k := DeleteByFields(&s, "/la/", "M")
-	if err != ErrNotMapIndex {
+	if !errors.Is(err, ErrNotMapIndex) {
 		t.Errorf("Cannot delete Map object")
 	}
 
 	err, k = DeleteByFields(&s, "/la/", "M", 10)
-	if err != ErrFindKeyWrongType {
+	if !errors.Is(err, ErrFindKeyWrongType) {
 		t.Errorf("Cannot delete Map object")
 	}
 
@@ -551,3 +599,622 @@ func TestSetByFields(t *testing.T) {
 		t.Errorf("Invalid delete key %v", k)
 	}
 }
+
+func TestLookupKey(t *testing.T) {
+	s := S11{
+		M: map[string]S10{"a": {A: 1}},
+	}
+
+	o, fields, err := LookupKey(&s, "/la/", "/la/M/a")
+	failIfError(t, err)
+	testFindByKeyResult(t, o, fields, S10{A: 1}, []interface{}{"M", "a"})
+
+	o, fields, err = LookupKey(&s, "/la/", "/la/M/a/A")
+	failIfError(t, err)
+	testFindByKeyResult(t, o, fields, 1, []interface{}{"M", "a", "A"})
+
+	o, fields, err = LookupKey(&s, "/la/", "/la/M/b/A")
+	failIfError(t, err)
+	testFindByKeyResult(t, o, fields, nil, []interface{}{"M", "b", "A"})
+
+	_, _, err = LookupKey(&s, "/la/", "/la/N/b/A")
+	failIfErrorDifferent(t, err, ErrFindPathNotFound)
+}
+
+type S12 struct {
+	A int
+	P *S10                      `kvs:"p"`
+	M map[string]S10            `kvs:"M/{key}/"`
+	N map[string]map[string]int `kvs:"N/{key}/{key}"`
+}
+
+func TestTypeByFields(t *testing.T) {
+	tests := []struct {
+		fields []interface{}
+		t      reflect.Type
+	}{
+		{[]interface{}{}, reflect.TypeOf(&S12{})},
+		{[]interface{}{"A"}, reflect.TypeOf(0)},
+		{[]interface{}{"P"}, reflect.TypeOf(&S10{})},
+		{[]interface{}{"P", "A"}, reflect.TypeOf(0)},
+		{[]interface{}{"M", "a"}, reflect.TypeOf(S10{})},
+		{[]interface{}{"N", "a"}, reflect.TypeOf(map[string]int{})},
+		{[]interface{}{"N", "a", "b"}, reflect.TypeOf(0)},
+	}
+	for _, test := range tests {
+		if typ := TypeByFields(reflect.TypeOf(&S12{}), test.fields); typ != test.t {
+			t.Errorf("TypeByFields%v returned %v (should be %v)", test.fields, typ, test.t)
+		}
+	}
+}
+
+type S23 struct {
+	Sub map[string]int `kvs:"sub/{key}"`
+}
+
+func TestDeleteKeyObject(t *testing.T) {
+	s := S12{
+		A: 1,
+		P: &S10{A: 2},
+		M: map[string]S10{"a": {A: 3}, "b": {A: 4}},
+		N: map[string]map[string]int{"x": {"y": 5}},
+	}
+
+	fields, err := DeleteKeyObject(&s, "/o/", "/o/A")
+	failIfError(t, err)
+	if s.A != 0 || !reflect.DeepEqual(fields, []interface{}{"A"}) {
+		t.Errorf("Struct field was not reset")
+	}
+
+	fields, err = DeleteKeyObject(&s, "/o/", "/o/p")
+	failIfError(t, err)
+	if s.P != nil || !reflect.DeepEqual(fields, []interface{}{"P"}) {
+		t.Errorf("Pointer was not set to nil")
+	}
+
+	fields, err = DeleteKeyObject(&s, "/o/", "/o/M/a/A")
+	failIfError(t, err)
+	if v, ok := s.M["a"]; !ok || v.A != 0 || !reflect.DeepEqual(fields, []interface{}{"M", "a", "A"}) {
+		t.Errorf("Map element field was not reset")
+	}
+
+	fields, err = DeleteKeyObject(&s, "/o/", "/o/M/b/A", PruneEmpty())
+	failIfError(t, err)
+	if _, ok := s.M["b"]; ok || !reflect.DeepEqual(fields, []interface{}{"M", "b"}) {
+		t.Errorf("Empty map element was not pruned")
+	}
+
+	fields, err = DeleteKeyObject(&s, "/o/", "/o/N/x/y", PruneEmpty())
+	failIfError(t, err)
+	if len(s.N) != 0 || !reflect.DeepEqual(fields, []interface{}{"N", "x"}) {
+		t.Errorf("Empty map element was not pruned %v", fields)
+	}
+
+	// Empty map elements are pruned through intermediate structs
+	n := map[string]S23{"a": {Sub: map[string]int{"k": 1}}, "b": {Sub: map[string]int{"k": 2, "l": 3}}}
+	fields, err = DeleteKeyObject(&n, "/n/{key}/", "/n/a/sub/k", PruneEmpty())
+	failIfError(t, err)
+	if _, ok := n["a"]; ok || !reflect.DeepEqual(fields, []interface{}{"a"}) {
+		t.Errorf("Empty map element was not pruned %v %v", n, fields)
+	}
+	fields, err = DeleteKeyObject(&n, "/n/{key}/", "/n/b/sub/k", PruneEmpty())
+	failIfError(t, err)
+	if len(n["b"].Sub) != 1 || !reflect.DeepEqual(fields, []interface{}{"b", "Sub", "k"}) {
+		t.Errorf("Non-empty map element was pruned %v %v", n, fields)
+	}
+
+	_, err = DeleteKeyObject(&s, "/o/", "/o/M/c")
+	failIfErrorDifferent(t, err, ErrFindObjectNotFound)
+
+	_, err = DeleteKeyObject(&s, "/o/", "/o/unknown")
+	failIfErrorDifferent(t, err, ErrFindPathNotFound)
+
+	fields, err = DeleteKeyObject(&s, "/o/", "/o")
+	failIfError(t, err)
+	if !reflect.DeepEqual(s, S12{}) || len(fields) != 0 {
+		t.Errorf("Object was not reset")
+	}
+}
+
+// Codec used for testing, storing values as JSON prefixed with the codec name.
+type prefixCodec struct{}
+
+func (prefixCodec) Marshal(v interface{}) (string, error) {
+	s, err := jsonCodec{}.Marshal(v)
+	return "prefix:" + s, err
+}
+
+func (prefixCodec) Unmarshal(data string, v interface{}) error {
+	if !strings.HasPrefix(data, "prefix:") {
+		return fmt.Errorf("Missing prefix")
+	}
+	return jsonCodec{}.Unmarshal(data[len("prefix:"):], v)
+}
+
+type S13 struct {
+	A int
+	B S10          `kvs:"b,codec=prefix"`
+	C S10          `kvs:"c/,codec=prefix"`
+	D int          `kvs:",codec=text"`
+	E map[int]bool `kvs:"e/{key},codec=text"`
+}
+
+func TestCodec(t *testing.T) {
+	RegisterCodec("prefix", prefixCodec{})
+
+	s := S13{
+		A: 1,
+		B: S10{A: 2},
+		C: S10{A: 3},
+		D: 4,
+		E: map[int]bool{5: true},
+	}
+
+	c := map[string]string{
+		"/o/A":   "1",
+		"/o/b":   "prefix:{\"A\":2}",
+		"/o/c/A": "prefix:3",
+		"/o/D":   "4",
+		"/o/e/5": "true",
+	}
+	testEncode(t, "/o/", &s, c)
+
+	// Default codec for the whole object
+	c["/o/A"] = "prefix:1"
+	testEncode(t, "/o/,codec=prefix", &s, c)
+
+	_, err := Encode("/o/,codec=unknown", &s)
+	failIfErrorDifferent(t, err, ErrUnknownCodec)
+
+	s2 := S13{}
+	testUpdateKeyObject(t, &s2, "/o/", "/o/b", "prefix:{\"A\":6}", []interface{}{"B"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/c/A", "prefix:7", []interface{}{"C", "A"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/e/8", "true", []interface{}{"E", 8})
+	testUpdateKeyObject(t, &s2, "/o/,codec=prefix", "/o/A", "prefix:9", []interface{}{"A"})
+	if s2.B.A != 6 || s2.C.A != 7 || !s2.E[8] || s2.A != 9 {
+		t.Errorf("Wrong decoded values %v", s2)
+	}
+}
+
+type Level int
+
+func (l Level) MarshalText() ([]byte, error) {
+	switch l {
+	case 0:
+		return []byte("low"), nil
+	case 1:
+		return []byte("high"), nil
+	}
+	return nil, fmt.Errorf("Invalid level %d", l)
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 0
+	case "high":
+		*l = 1
+	default:
+		return fmt.Errorf("Invalid level '%s'", text)
+	}
+	return nil
+}
+
+// Temperature stored as a single key with a unit suffix.
+type Temperature struct {
+	Celsius int
+}
+
+func (t Temperature) MarshalKV(w KVWriter) error {
+	return w.Set("", fmt.Sprintf("%dC", t.Celsius))
+}
+
+func (t *Temperature) UnmarshalKV(key string, value *string) error {
+	if value == nil {
+		t.Celsius = 0
+		return nil
+	}
+	_, err := fmt.Sscanf(*value, "%dC", &t.Celsius)
+	return err
+}
+
+// Labels stored as one key per label, where label names may contain '/'.
+type Labels struct {
+	m map[string]string
+}
+
+func (l Labels) MarshalKV(w KVWriter) error {
+	for k, v := range l.m {
+		if err := w.Set(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Labels) UnmarshalKV(key string, value *string) error {
+	if value == nil {
+		delete(l.m, key)
+		return nil
+	}
+	if l.m == nil {
+		l.m = make(map[string]string)
+	}
+	l.m[key] = *value
+	return nil
+}
+
+type S14 struct {
+	IP     net.IP                 `kvs:"ip"`
+	Level  Level                  `kvs:"level"`
+	Temp   Temperature            `kvs:"temp"`
+	Labels Labels                 `kvs:"labels/"`
+	Temps  map[string]Temperature `kvs:"temps/{key}"`
+}
+
+func TestMarshaler(t *testing.T) {
+	s := S14{
+		IP:     net.ParseIP("10.0.0.1"),
+		Level:  1,
+		Temp:   Temperature{21},
+		Labels: Labels{map[string]string{"a": "1", "b/c": "2"}},
+		Temps:  map[string]Temperature{"x": {-3}},
+	}
+
+	c := map[string]string{
+		"/o/ip":         "10.0.0.1",
+		"/o/level":      "high",
+		"/o/temp":       "21C",
+		"/o/labels/a":   "1",
+		"/o/labels/b/c": "2",
+		"/o/temps/x":    "-3C",
+	}
+	testEncode(t, "/o/", &s, c)
+	testEncode(t, "/o/", s, c)
+
+	s2 := S14{}
+	testUpdateKeyObject(t, &s2, "/o/", "/o/ip", "10.0.0.2", []interface{}{"IP"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/level", "high", []interface{}{"Level"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/temp", "22C", []interface{}{"Temp"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/labels/d/e", "3", []interface{}{"Labels"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/temps/y", "4C", []interface{}{"Temps", "y"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/temps/y", "5C", []interface{}{"Temps", "y"})
+	if !s2.IP.Equal(net.ParseIP("10.0.0.2")) || s2.Level != 1 || s2.Temp.Celsius != 22 ||
+		s2.Labels.m["d/e"] != "3" || s2.Temps["y"].Celsius != 5 {
+		t.Errorf("Wrong decoded values %v", s2)
+	}
+
+	// Values written as JSON strings by earlier versions are still decoded
+	testUpdateKeyObject(t, &s2, "/o/", "/o/ip", "\"10.0.0.3\"", []interface{}{"IP"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/level", "\"low\"", []interface{}{"Level"})
+	if !s2.IP.Equal(net.ParseIP("10.0.0.3")) || s2.Level != 0 {
+		t.Errorf("Wrong decoded values %v", s2)
+	}
+	testUpdateKeyObject(t, &s2, "/o/", "/o/level", "high", []interface{}{"Level"})
+
+	// Unmarshaling failures fall back to the default value
+	testUpdateKeyObject(t, &s2, "/o/", "/o/level", "medium", []interface{}{"Level"})
+	if s2.Level != 0 {
+		t.Errorf("Invalid level should reset the value")
+	}
+
+	_, err := UpdateKeyObject(&s2, "/o/", "/o/temp/x", "1C")
+	failIfErrorDifferent(t, err, ErrFindPathPastObject)
+
+	fields, err := DeleteKeyObject(&s2, "/o/", "/o/labels/d/e")
+	failIfError(t, err)
+	if !reflect.DeepEqual(fields, []interface{}{"Labels"}) || len(s2.Labels.m) != 0 {
+		t.Errorf("Wrong deletion %v %v", fields, s2.Labels)
+	}
+
+	fields, err = DeleteKeyObject(&s2, "/o/", "/o/temp")
+	failIfError(t, err)
+	if !reflect.DeepEqual(fields, []interface{}{"Temp"}) || s2.Temp.Celsius != 0 {
+		t.Errorf("Wrong deletion %v %v", fields, s2.Temp)
+	}
+}
+
+type Name string
+
+type S15 struct {
+	Names  map[Name]int       `kvs:"names/{key}"`
+	Levels map[Level]int      `kvs:"levels/{key}"`
+	Paths  map[string]int     `kvs:"paths/{key}"`
+	Ids    map[uint16]int     `kvs:"ids/{key},keywidth=5"`
+	Nested map[int]S10        `kvs:"nested/{key}/,keywidth=3"`
+	Points map[[2]int]float64 `kvs:"points/{key}"`
+}
+
+func TestMapKeys(t *testing.T) {
+	s := S15{
+		Names:  map[Name]int{"alice": 1},
+		Levels: map[Level]int{1: 2},
+		Paths:  map[string]int{"a/b": 3, "50%": 4, "%2F": 5},
+		Ids:    map[uint16]int{42: 5},
+		Nested: map[int]S10{7: {A: 6}},
+		Points: map[[2]int]float64{{1, 2}: 7},
+	}
+
+	c := map[string]string{
+		"/o/names/alice":  "1",
+		"/o/levels/high":  "2",
+		"/o/paths/a%2Fb":  "3",
+		"/o/paths/50%":    "4",
+		"/o/paths/%252F":  "5",
+		"/o/ids/00042":    "5",
+		"/o/nested/007/A": "6",
+		"/o/points/[1,2]": "7",
+	}
+	testEncode(t, "/o/", &s, c)
+
+	s2 := S15{}
+	testUpdateKeyObject(t, &s2, "/o/", "/o/names/bob", "1", []interface{}{"Names", Name("bob")})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/levels/low", "2", []interface{}{"Levels", Level(0)})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/paths/a%2Fb", "3", []interface{}{"Paths", "a/b"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/paths/50%", "4", []interface{}{"Paths", "50%"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/paths/%252F", "5", []interface{}{"Paths", "%2F"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/paths/%zz%", "5", []interface{}{"Paths", "%zz%"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/ids/00042", "5", []interface{}{"Ids", uint16(42)})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/nested/007/A", "6", []interface{}{"Nested", 7, "A"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/points/[1,2]", "7", []interface{}{"Points", [2]int{1, 2}})
+
+	_, err := UpdateKeyObject(&s2, "/o/", "/o/levels/medium", "8")
+	if err == nil {
+		t.Errorf("Invalid text key should fail")
+	}
+	_, err = Encode("/o/,keywidth=0", &s)
+	if err == nil {
+		t.Errorf("Invalid key width should fail")
+	}
+
+	_, key, err := FindByFields(&s2, "/o/", []interface{}{"Paths", "a/b"})
+	failIfError(t, err)
+	if key != "/o/paths/a%2Fb" {
+		t.Errorf("Wrong key %s", key)
+	}
+}
+
+type Base struct {
+	ID   string `kvs:"id"`
+	Name string `kvs:"name"`
+}
+
+type S16 struct {
+	Base  `kvs:",inline"`
+	Name  string            `kvs:"name"`
+	Local int               `kvs:"-"`
+	Count int               `kvs:"count,omitempty"`
+	Tags  map[string]string `kvs:"tags/{key},omitempty"`
+	Addr  S10               `kvs:"address/,alias=addr,alias=old/address"`
+}
+
+type S17 struct {
+	A int `kvs:",inline"`
+}
+
+func TestTagOptions(t *testing.T) {
+	s := S16{
+		Base:  Base{ID: "x", Name: "inner"},
+		Name:  "outer",
+		Local: 1,
+		Addr:  S10{A: 2},
+	}
+
+	c := map[string]string{
+		"/o/id":        "x",
+		"/o/name":      "outer",
+		"/o/address/A": "2",
+	}
+	testEncode(t, "/o/", &s, c)
+
+	kvs, deleted, err := EncodeWithDeletions("/o/", &s)
+	failIfError(t, err)
+	if !reflect.DeepEqual(kvs, c) || !reflect.DeepEqual(deleted, []string{"/o/count", "/o/tags/"}) {
+		t.Errorf("Wrong encoding %v %v", kvs, deleted)
+	}
+
+	s.Count = 3
+	s.Tags = map[string]string{"k": "v"}
+	c["/o/count"] = "3"
+	c["/o/tags/k"] = "v"
+	testEncode(t, "/o/", &s, c)
+
+	s2 := S16{}
+	testUpdateKeyObject(t, &s2, "/o/", "/o/id", "y", []interface{}{"Base", "ID"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/name", "n", []interface{}{"Name"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/addr/A", "4", []interface{}{"Addr", "A"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/old/address/A", "5", []interface{}{"Addr", "A"})
+	if s2.Base.ID != "y" || s2.Name != "n" || s2.Base.Name != "" || s2.Addr.A != 5 {
+		t.Errorf("Wrong decoded values %v", s2)
+	}
+
+	_, err = UpdateKeyObject(&s2, "/o/", "/o/Local", "1")
+	failIfErrorDifferent(t, err, ErrFindPathNotFound)
+
+	_, _, err = FindByFields(&s2, "/o/", []interface{}{"Local"})
+	failIfErrorDifferent(t, err, ErrFieldSkipped)
+
+	err = SetByFields(&s2, "/o/", 1, "Local")
+	failIfErrorDifferent(t, err, ErrFieldSkipped)
+
+	_, err = Encode("/o/", &S17{})
+	failIfErrorDifferent(t, err, ErrInlineType)
+
+	_, err = Encode("/o/,omitempty", &s)
+	failIfErrorDifferent(t, err, ErrFieldOption)
+}
+
+type Check interface {
+	Kind() string
+}
+
+type HTTPCheck struct {
+	URL string
+}
+
+func (HTTPCheck) Kind() string { return "http" }
+
+type TCPCheck struct {
+	Port int
+}
+
+func (*TCPCheck) Kind() string { return "tcp" }
+
+type S18 struct {
+	Check  Check            `kvs:"check"`
+	Checks map[string]Check `kvs:"checks/{key}"`
+	Other  Check            `kvs:"other"`
+	Extra  interface{}      `kvs:"extra"`
+}
+
+func TestInterface(t *testing.T) {
+	RegisterType("http", HTTPCheck{})
+	RegisterType("tcp", &TCPCheck{})
+
+	s := S18{
+		Check: HTTPCheck{URL: "http://a"},
+		Checks: map[string]Check{
+			"x": &TCPCheck{Port: 80},
+		},
+	}
+
+	c := map[string]string{
+		"/o/check":    "{\"type\":\"http\",\"value\":{\"URL\":\"http://a\"}}",
+		"/o/checks/x": "{\"type\":\"tcp\",\"value\":{\"Port\":80}}",
+	}
+	testEncode(t, "/o/", &s, c)
+
+	s2 := S18{}
+	testUpdateKeyObject(t, &s2, "/o/", "/o/check", c["/o/check"], []interface{}{"Check"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/checks/x", c["/o/checks/x"], []interface{}{"Checks", "x"})
+	if !reflect.DeepEqual(s, s2) {
+		t.Errorf("Wrong decoded values %v", s2)
+	}
+
+	o, _, err := FindByKey(&s2, "/o/", "/o/checks/x")
+	failIfError(t, err)
+	if tcp, ok := o.(*TCPCheck); !ok || tcp.Port != 80 {
+		t.Errorf("Wrong concrete type %v", o)
+	}
+
+	err = SetByFields(&s2, "/o/", HTTPCheck{URL: "http://b"}, "Other")
+	failIfError(t, err)
+	if s2.Other != (HTTPCheck{URL: "http://b"}) {
+		t.Errorf("Wrong value %v", s2.Other)
+	}
+	err = SetByFields(&s2, "/o/", 1, "Other")
+	failIfErrorDifferent(t, err, ErrFindSetWrongType)
+
+	_, err = unserializeValue("{\"type\":\"unknown\",\"value\":{}}", reflect.TypeOf((*Check)(nil)).Elem(), nil)
+	failIfErrorDifferent(t, err, ErrUnknownTypeName)
+
+	_, err = Encode("/o/", &S18{Check: &HTTPCheck{}})
+	failIfErrorDifferent(t, err, ErrUnregisteredType)
+
+	RegisterType("int", 1)
+	_, err = unserializeValue("{\"type\":\"int\",\"value\":1}", reflect.TypeOf((*Check)(nil)).Elem(), nil)
+	failIfErrorDifferent(t, err, ErrTypeNotAssignable)
+
+	// Unregistered values of empty interfaces are stored as plain JSON
+	testEncode(t, "/o/", &S18{Extra: map[string]int{"a": 1}}, map[string]string{"/o/extra": "{\"a\":1}"})
+	testEncode(t, "/o/", &S18{Extra: HTTPCheck{URL: "http://c"}}, map[string]string{
+		"/o/extra": "{\"type\":\"http\",\"value\":{\"URL\":\"http://c\"}}",
+	})
+	s3 := S18{}
+	testUpdateKeyObject(t, &s3, "/o/", "/o/extra", "{\"a\":1}", []interface{}{"Extra"})
+	if !reflect.DeepEqual(s3.Extra, map[string]interface{}{"a": 1.0}) {
+		t.Errorf("Wrong plain JSON value %v", s3.Extra)
+	}
+	testUpdateKeyObject(t, &s3, "/o/", "/o/extra", "[1,\"b\"]", []interface{}{"Extra"})
+	if !reflect.DeepEqual(s3.Extra, []interface{}{1.0, "b"}) {
+		t.Errorf("Wrong plain JSON value %v", s3.Extra)
+	}
+	testUpdateKeyObject(t, &s3, "/o/", "/o/extra", c["/o/check"], []interface{}{"Extra"})
+	if s3.Extra != (HTTPCheck{URL: "http://a"}) {
+		t.Errorf("Wrong registered value %v", s3.Extra)
+	}
+}
+
+type S19 struct {
+	Tenants map[string]map[string]S10 `kvs:"tenants/{key}/services/{key}/"`
+	Items   map[int]S10               `kvs:"items/{key}/meta/"`
+	Pairs   map[string]map[int]int    `kvs:"pairs/{key}/{key}"`
+}
+
+func TestNestedMaps(t *testing.T) {
+	s := S19{
+		Tenants: map[string]map[string]S10{"t1": {"s1": {A: 1}}},
+		Items:   map[int]S10{2: {A: 2}},
+		Pairs:   map[string]map[int]int{"p": {3: 3}},
+	}
+
+	c := map[string]string{
+		"/o/tenants/t1/services/s1/A": "1",
+		"/o/items/2/meta/A":           "2",
+		"/o/pairs/p/3":                "3",
+	}
+	testEncode(t, "/o/", &s, c)
+
+	s2 := S19{}
+	testUpdateKeyObject(t, &s2, "/o/", "/o/tenants/t1/services/s1/A", "1", []interface{}{"Tenants", "t1", "s1", "A"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/items/2/meta/A", "2", []interface{}{"Items", 2, "A"})
+	testUpdateKeyObject(t, &s2, "/o/", "/o/pairs/p/3", "3", []interface{}{"Pairs", "p", 3})
+	if !reflect.DeepEqual(s, s2) {
+		t.Errorf("Wrong decoded values %v", s2)
+	}
+
+	o, fields, err := FindByKey(&s2, "/o/", "/o/tenants/t1/services/s1/A")
+	failIfError(t, err)
+	if o != 1 || !reflect.DeepEqual(fields, []interface{}{"Tenants", "t1", "s1", "A"}) {
+		t.Errorf("Wrong object %v %v", o, fields)
+	}
+
+	// Keys between a map key and the element format do not designate an object
+	_, err = UpdateKeyObject(&s2, "/o/", "/o/items/2/other/A", "2")
+	failIfErrorDifferent(t, err, ErrFindPathNotFound)
+
+	err, key := DeleteByFields(&s2, "/o/", "Tenants", "t1", "s1")
+	failIfError(t, err)
+	if key != "/o/tenants/t1/services/s1/" {
+		t.Errorf("Wrong deleted key %s", key)
+	}
+	err, key = DeleteByFields(&s2, "/o/", "Items", 2)
+	failIfError(t, err)
+	if key != "/o/items/2/meta/" {
+		t.Errorf("Wrong deleted key %s", key)
+	}
+	err, key = DeleteByFields(&s2, "/o/", "Pairs", "p")
+	failIfError(t, err)
+	if key != "/o/pairs/p/" || len(s2.Pairs) != 0 {
+		t.Errorf("Wrong deleted key %s", key)
+	}
+
+	fields, err = DeleteKeyObject(&s2, "/o/", "/o/tenants/t1")
+	failIfError(t, err)
+	if !reflect.DeepEqual(fields, []interface{}{"Tenants", "t1"}) || len(s2.Tenants) != 0 {
+		t.Errorf("Wrong deletion %v %v", fields, s2.Tenants)
+	}
+}
+
+func TestInvalidFormats(t *testing.T) {
+	m := map[string]map[string]S10{"a": {"b": {A: 1}}}
+	for format, expected := range map[string]error{
+		"/o/{key}/":             ErrMapFormat,
+		"/o//{key}":             ErrFormatEmptySegment,
+		"/o/a{key}/{key}":       ErrFormatKeySegment,
+		"/o/{key}{key}":         ErrFormatKeySegment,
+		"/o/{key}/{key}/{key}/": ErrStructFormat,
+		"/o/{key}/{key}/x":      nil,
+	} {
+		_, err := Encode(format, m)
+		failIfErrorDifferent(t, err, expected)
+	}
+
+	_, err := Encode("/o/", &struct {
+		A S10 `kvs:"a//b"`
+	}{})
+	failIfErrorDifferent(t, err, ErrFormatEmptySegment)
+
+	_, err = UpdateKeyObject(&m, "/o/{key}/{key}/{key}/", "/o/a/b/c/A", "1")
+	failIfErrorDifferent(t, err, ErrStructFormat)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PathError reports where an error occurred while looking up, encoding or decoding a sub-object.
+//
+// It wraps the cause of the error, such that errors.Is(err, ErrFindPathNotFound) can be used
+// to test for one of the errors of this package.
+type PathError struct {
+	// Key path of the sub-object, relative to the root of the key-value store.
+	Key string
+
+	// Field path to the sub-object from the root object.
+	Fields []interface{}
+
+	// Type of the sub-object, or nil when unknown.
+	Type reflect.Type
+
+	// The format element being processed (e.g. "{key}"), or empty when the whole format was consumed.
+	Segment string
+
+	// The cause of the error.
+	Err error
+}
+
+func (e *PathError) Error() string {
+	s := fmt.Sprintf("%v (key '%s', fields %v", e.Err, e.Key, e.Fields)
+	if e.Type != nil {
+		s += fmt.Sprintf(", type %v", e.Type)
+	}
+	if e.Segment != "" {
+		s += fmt.Sprintf(", format element '%s'", e.Segment)
+	}
+	return s + ")"
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the error annotated with the location of the object, unless it already carries a location.
+func (o *objectPath) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*PathError); ok {
+		return err
+	}
+
+	e := &PathError{
+		Key:    strings.Join(o.keypath, "/"),
+		Fields: append([]interface{}(nil), o.fields...),
+		Type:   o.vtype,
+		Err:    err,
+	}
+	if len(o.format) != 0 {
+		e.Segment = o.format[0]
+	}
+	return e
+}
+
+// Returns the error annotated with the key and field path of the object, when its type is unknown
+// (e.g. for errors returned by generated code).
+func keyError(key string, fields []interface{}, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*PathError); ok {
+		return err
+	}
+	return &PathError{
+		Key:    key,
+		Fields: fields,
+		Err:    err,
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testPathError(t *testing.T, err error, expected PathError) {
+	var perr *PathError
+	if !errors.As(err, &perr) {
+		t.Errorf("Error '%v' is not a *PathError", err)
+		return
+	}
+	if !errors.Is(err, expected.Err) {
+		t.Errorf("Error '%v' does not wrap '%v'", err, expected.Err)
+	}
+	if perr.Key != expected.Key || !reflect.DeepEqual(perr.Fields, expected.Fields) ||
+		perr.Type != expected.Type || perr.Segment != expected.Segment {
+		t.Errorf("Error %#v differs from expected %#v", *perr, expected)
+	}
+}
+
+type errBad struct {
+	F func()
+}
+
+type errInner struct {
+	In errBad `kvs:"in"`
+}
+
+type errOuter struct {
+	M map[string]*errInner `kvs:"m/{key}/"`
+}
+
+func TestPathError(t *testing.T) {
+	s := S7{}
+
+	// Setting a nested value of the wrong type
+	err := SetByFields(&s, "/o/", "a", "S6PtrMap", "x", "N")
+	testPathError(t, err, PathError{
+		Key:    "/o/s6_ptr_map/x/sub/N",
+		Fields: []interface{}{"S6PtrMap", "x", "N"},
+		Type:   reflect.TypeOf(0),
+		Err:    ErrFindSetWrongType,
+	})
+
+	// Wrong map key type
+	_, _, err = FindByFields(&s, "/o/", []interface{}{"S6PtrMap", 1})
+	testPathError(t, err, PathError{
+		Key:     "/o/s6_ptr_map",
+		Fields:  []interface{}{"S6PtrMap"},
+		Type:    reflect.TypeOf(map[string]*S6{}),
+		Segment: "{key}",
+		Err:     ErrFindKeyWrongType,
+	})
+
+	// Key which does not match any field
+	_, err = UpdateKeyObject(&s, "/o/", "/o/s6_ptr_map/x/sub/unknown", "1")
+	testPathError(t, err, PathError{
+		Key:     "/o/s6_ptr_map/x/sub",
+		Fields:  []interface{}{"S6PtrMap", "x"},
+		Type:    reflect.TypeOf(S6{}),
+		Segment: "",
+		Err:     ErrFindPathNotFound,
+	})
+
+	// Key below a value
+	_, err = UpdateKeyObject(&s, "/o/", "/o/I/x", "1")
+	testPathError(t, err, PathError{
+		Key:    "/o/I",
+		Fields: []interface{}{"I"},
+		Type:   reflect.TypeOf(0),
+		Err:    ErrFindPathPastObject,
+	})
+
+	// Encoding a map with an invalid format
+	m := map[string]int{"a": 1}
+	_, err = Encode("/m/", &m)
+	testPathError(t, err, PathError{
+		Key:     "/m",
+		Fields:  nil,
+		Type:    reflect.TypeOf(m),
+		Segment: "",
+		Err:     ErrMapFormat,
+	})
+
+	// Encoding a value which can't be serialized
+	o := errOuter{M: map[string]*errInner{"a": {In: errBad{F: func() {}}}}}
+	expectedErr := PathError{
+		Key:    "/x/m/a/in",
+		Fields: []interface{}{"M", "a", "In"},
+		Type:   reflect.TypeOf(errBad{}),
+	}
+	_, err = Encode("/x/", &o)
+	for _, err := range []error{err, EncodeStream("/x/", &o, &testSink{})} {
+		var jerr *json.UnsupportedTypeError
+		if !errors.As(err, &jerr) {
+			t.Fatalf("Wrong error %v", err)
+		}
+		expectedErr.Err = jerr
+		testPathError(t, err, expectedErr)
+	}
+
+	// Errors returned when decoding values and compiling layouts also wrap their cause
+	_, err = UpdateKeyObject(&s, "/o/", "/o/I", "x", StrictDecoding())
+	var verr *ValueError
+	if !errors.As(err, &verr) || errors.Unwrap(err) != verr.Err {
+		t.Errorf("Wrong error %v", err)
+	}
+	_, err = Compile("/m/", reflect.TypeOf(m))
+	if !errors.Is(err, ErrMapFormat) {
+		t.Errorf("Wrong error %v", err)
+	}
+	_, err = Compile("/m/{key},keywidth=a", reflect.TypeOf(m))
+	if !errors.Is(err, ErrFormatOption) {
+		t.Errorf("Wrong error %v", err)
+	}
+
+	expected := "Provided path goes past an encoded object (key '/o/I', fields [I], type int)"
+	if _, err = UpdateKeyObject(&s, "/o/", "/o/I/x", "1"); err.Error() != expected {
+		t.Errorf("Wrong error message '%v'", err)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GeneratedObject is implemented by pointers to types which encoding functions
+// were generated by kvsync-gen (see cmd/kvsync-gen).
+//
+// Encode, UpdateKeyObject, DeleteKeyObject and LookupKey use the generated functions instead of
+// reflection when the whole object is stored with a static recursive format without
+// options (e.g. "/db/"). Keys provided to the generated functions are relative to the
+// object path.
+type GeneratedObject interface {
+	// EncodeKV adds the object key-value pairs to kvs, with keys prefixed by prefix,
+	// and the keys of the empty fields omitted because of the 'omitempty' option to omitted.
+	EncodeKV(prefix string, kvs map[string]string, omitted *[]string) error
+
+	// UpdateKV sets a key, and returns the path to the modified field.
+	UpdateKV(key string, value string) ([]interface{}, error)
+
+	// DeleteKV handles a key deletion, and returns the path to the deleted field.
+	// The key may designate a sub-object stored as multiple keys (without trailing '/').
+	DeleteKV(key string) ([]interface{}, error)
+
+	// LookupKV returns a copy of the sub-object associated with a key, and the path to the sub-object,
+	// as LookupKey does. The key may designate a sub-object stored as multiple keys (without trailing '/').
+	LookupKV(key string) (interface{}, []interface{}, error)
+}
+
+// Returns the generated functions of an object, and the object path,
+// when they can be used with the given format.
+func generatedObject(object interface{}, format interface{}) (GeneratedObject, string, bool) {
+	g, ok := object.(GeneratedObject)
+	if !ok {
+		return nil, "", false
+	}
+
+	var path []string
+	var opts formatOptions
+	switch f := format.(type) {
+	case *Layout:
+		if f.Validate(object) != nil {
+			return nil, "", false
+		}
+		path, opts = f.path, f.opts
+	case string:
+		var err error
+		path, opts, err = parseFormat(f)
+		if err != nil {
+			return nil, "", false
+		}
+	default:
+		return nil, "", false
+	}
+
+	if opts.codec != "" || opts.keyWidth != 0 || opts.omitEmpty || opts.inline || len(opts.aliases) != 0 {
+		return nil, "", false
+	}
+	if path[len(path)-1] != "" {
+		return nil, "", false
+	}
+	for i, e := range path[:len(path)-1] {
+		if (e == "" && i != 0) || e == "{key}" || e == "{index}" {
+			return nil, "", false
+		}
+	}
+	return g, strings.Join(path, "/"), true
+}
+
+// MarshalValue serializes the value pointed by p with the default codec,
+// the same way a value stored as a single key is.
+// Pointers are dereferenced. This function is used by generated code.
+func MarshalValue(p interface{}) (string, error) {
+	v := reflect.ValueOf(p).Elem()
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	return serializeValue(v, nil)
+}
+
+// UnmarshalValue parses a value stored as a single key with the default codec,
+// and stores it in the value pointed by p, allocating nil pointers.
+// The value is reset to its zero value when parsing fails.
+// This function is used by generated code.
+func UnmarshalValue(data string, p interface{}) error {
+	v := reflect.ValueOf(p).Elem()
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	val, err := unserializeValue(data, v.Type(), nil)
+	if err != nil {
+		v.Set(reflect.Zero(v.Type()))
+		return err
+	}
+	v.Set(val)
+	return nil
+}
+
+// ParseInt parses a signed integer of the given bit size stored with the default codec,
+// as done by UnmarshalValue, and returns 0 when the value is invalid.
+// This function is used by generated code.
+func ParseInt(value string, bitSize int) int64 {
+	s := strings.Trim(value, jsonSpace)
+	if !isJSONInteger(s) {
+		return 0
+	}
+	i, err := strconv.ParseInt(s, 10, bitSize)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// ParseUint works like ParseInt for unsigned integers.
+// This function is used by generated code.
+func ParseUint(value string, bitSize int) uint64 {
+	s := strings.Trim(value, jsonSpace)
+	if !isJSONInteger(s) {
+		return 0
+	}
+	i, err := strconv.ParseUint(s, 10, bitSize)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// ParseBool parses a boolean stored with the default codec, as done by UnmarshalValue,
+// and returns false when the value is invalid.
+// This function is used by generated code.
+func ParseBool(value string) bool {
+	return strings.Trim(value, jsonSpace) == "true"
+}
+
+// Characters ignored around JSON values.
+const jsonSpace = " \t\n\r"
+
+// Returns whether s is a JSON number without fraction nor exponent.
+func isJSONInteger(s string) bool {
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	if s == "" || (s[0] == '0' && len(s) != 1) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// IsEmptyValue returns whether the value pointed by p is considered empty by the 'omitempty' option.
+// This function is used by generated code.
+func IsEmptyValue(p interface{}) bool {
+	return isEmptyValue(reflect.ValueOf(p).Elem())
+}
+
+// FormatMapKey returns the key path element storing the map key pointed by p.
+// This function is used by generated code.
+func FormatMapKey(p interface{}, keyWidth int) (string, error) {
+	return serializeMapKey(reflect.ValueOf(p).Elem(), keyWidth)
+}
+
+// ParseMapKey parses a key path element storing a map key, and stores it in the value pointed by p.
+// This function is used by generated code.
+func ParseMapKey(s string, p interface{}) error {
+	v := reflect.ValueOf(p).Elem()
+	k, err := unserializeMapKey(s, v.Type())
+	if err != nil {
+		return err
+	}
+	v.Set(k)
+	return nil
+}
+
+// EscapeMapKey escapes a string map key, as done for map keys of string kind.
+// This function is used by generated code.
+func EscapeMapKey(s string) string {
+	return escapeMapKey(s)
+}
+
+// UnescapeMapKey reverts EscapeMapKey.
+// This function is used by generated code.
+func UnescapeMapKey(s string) string {
+	return unescapeMapKey(s)
+}
+
+// PadMapKey pads an integer map key to the given width, as done by the 'keywidth' option.
+// This function is used by generated code.
+func PadMapKey(s string, keyWidth int) string {
+	return padMapKey(s, keyWidth)
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var ErrFormatType = errors.New("Format must be a string or a *Layout")
+var ErrLayoutType = errors.New("Object type does not match the layout type")
+
+// LayoutError reports an invalid format found when compiling a layout.
+type LayoutError struct {
+	// Path to the offending field from the root type (e.g. "Pool.Backends[key].Check"),
+	// or the root type name when the root format is invalid.
+	Field string
+
+	// The offending format.
+	Format string
+
+	// The cause of the error.
+	Err error
+}
+
+func (e *LayoutError) Error() string {
+	return fmt.Sprintf("Invalid format '%s' for '%s': %v", e.Format, e.Field, e.Err)
+}
+
+func (e *LayoutError) Unwrap() error {
+	return e.Err
+}
+
+// Layout is a format which was parsed and checked against a type.
+//
+// A Layout may be provided instead of a format string to all the encoding, store and sync
+// functions. Layouts are immutable and may be shared between goroutines.
+type Layout struct {
+	format string
+	path   []string
+	opts   formatOptions
+	t      reflect.Type
+}
+
+// Compile parses a root format as well as the struct tags of all the types reachable from t,
+// and checks that all formats are valid and compatible with the types they apply to.
+//
+// The returned error is a *LayoutError identifying the offending field.
+// When t is nil, only the root format is checked.
+func Compile(format string, t reflect.Type) (*Layout, error) {
+	name := "<nil>"
+	if t != nil {
+		name = t.String()
+	}
+
+	path, opts, err := parseFormat(format)
+	if err == nil && (opts.omitEmpty || opts.inline || len(opts.aliases) != 0) {
+		err = ErrFieldOption
+	}
+	if err == nil && opts.codec != "" {
+		_, err = getCodec(opts.codec)
+	}
+	if err != nil {
+		return nil, &LayoutError{Field: name, Format: format, Err: err}
+	}
+
+	if t != nil {
+		c := &layoutCompiler{
+			visited: make(map[string]bool),
+		}
+		err = c.check(t, path, name, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Layout{
+		format: format,
+		path:   path,
+		opts:   opts,
+		t:      t,
+	}, nil
+}
+
+// String returns the format the layout was compiled from.
+func (l *Layout) String() string {
+	return l.format
+}
+
+// Type returns the type the layout was compiled for.
+func (l *Layout) Type() reflect.Type {
+	return l.t
+}
+
+// Validate returns ErrLayoutType if the object can't be used with the layout.
+// Pointers to the layout type are accepted, as well as the value pointed by a pointer layout type.
+func (l *Layout) Validate(object interface{}) error {
+	t := reflect.TypeOf(object)
+	lt := l.t
+	if t == nil || lt == nil {
+		if t != lt {
+			return ErrLayoutType
+		}
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for lt.Kind() == reflect.Ptr {
+		lt = lt.Elem()
+	}
+	if t != lt {
+		return ErrLayoutType
+	}
+	return nil
+}
+
+type layoutCompiler struct {
+	// Types already checked with a given format, which avoids looping on recursive types.
+	visited map[string]bool
+}
+
+func (c *layoutCompiler) check(t reflect.Type, format []string, field string, tag string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Skip static path elements, including the leading empty element of root formats
+	for len(format) != 0 && (format[0] != "" || len(format) > 1) && format[0] != "{key}" && format[0] != "{index}" {
+		format = format[1:]
+	}
+
+	if len(format) == 0 || isKVUnmarshaler(t) || t.Implements(kvMarshalerType) || reflect.PtrTo(t).Implements(kvMarshalerType) {
+		// Stored as a single key, or by the type itself
+		return nil
+	}
+
+	id := t.String() + "|" + strings.Join(format, "/")
+	if c.visited[id] {
+		return nil
+	}
+	c.visited[id] = true
+
+	fail := func(err error) error {
+		return &LayoutError{Field: field, Format: tag, Err: err}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if len(format) != 1 || format[0] != "" {
+			return fail(ErrStructFormat)
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !isStoredField(f) {
+				continue
+			}
+			ftag := f.Tag.Get("kvs")
+			fformat, opts, err := getStructFieldFormat(f)
+			if err == nil && opts.codec != "" {
+				_, err = getCodec(opts.codec)
+			}
+			if err != nil {
+				return &LayoutError{Field: field + "." + f.Name, Format: ftag, Err: err}
+			}
+			err = c.check(f.Type, fformat, field+"."+f.Name, ftag)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if format[0] != "{key}" {
+			return fail(ErrMapFormat)
+		}
+		return c.check(t.Elem(), format[1:], field+"[key]", tag)
+	case reflect.Slice, reflect.Array:
+		return fail(ErrNotImplemented)
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Invalid, reflect.UnsafePointer:
+		return fail(ErrUnsupportedType)
+	default:
+		return fail(ErrScalarType)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"reflect"
+	"testing"
+)
+
+type Node struct {
+	Name     string
+	Parent   *Node           `kvs:"parent/"`
+	Children map[string]Node `kvs:"children/{key}/"`
+}
+
+type BadMap struct {
+	Name  string
+	Pools map[string]BadField `kvs:"pools/{key}/"`
+}
+
+type BadField struct {
+	M map[string]int `kvs:"m/"`
+}
+
+type BadTag struct {
+	A int `kvs:"/a"`
+}
+
+type BadCodec struct {
+	A int `kvs:"a,codec=unknown"`
+}
+
+func testCompileError(t *testing.T, format string, object interface{}, field string, expected error) {
+	_, err := Compile(format, reflect.TypeOf(object))
+	lerr, ok := err.(*LayoutError)
+	if !ok {
+		t.Errorf("Compile returned %v instead of a LayoutError", err)
+		return
+	}
+	if lerr.Field != field || lerr.Err != expected {
+		t.Errorf("Compile returned %v, expected field %s and error %v", err, field, expected)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	// Recursive types are only checked once
+	l, err := Compile("/nodes/", reflect.TypeOf(&Node{}))
+	failIfError(t, err)
+	if l.String() != "/nodes/" || l.Type() != reflect.TypeOf(&Node{}) {
+		t.Errorf("Wrong layout %v", l)
+	}
+
+	n := Node{
+		Name:     "root",
+		Children: map[string]Node{"c": {Name: "child"}},
+	}
+	c := map[string]string{
+		"/nodes/Name":            "root",
+		"/nodes/children/c/Name": "child",
+	}
+	testEncode(t, l, &n, c)
+
+	n2 := Node{}
+	testUpdateKeyObject(t, &n2, l, "/nodes/children/c/Name", "child", []interface{}{"Children", "c", "Name"})
+	failIfError(t, l.Validate(n2))
+	failIfError(t, SetByFields(&n2, l, "root", "Name"))
+	if !reflect.DeepEqual(n, n2) {
+		t.Errorf("Wrong decoded value %v", n2)
+	}
+
+	_, err = Encode(l, &S10{})
+	failIfErrorDifferent(t, err, ErrLayoutType)
+	_, err = Encode(1, &n)
+	failIfErrorDifferent(t, err, ErrFormatType)
+
+	testCompileError(t, "/o/", &BadMap{}, "*encoding.BadMap.Pools[key].M", ErrMapFormat)
+	testCompileError(t, "/o/", map[string]int{}, "map[string]int", ErrMapFormat)
+	testCompileError(t, "/o/{key}/", map[string]int{}, "map[string]int[key]", ErrScalarType)
+	testCompileError(t, "/o/,omitempty", &n, "*encoding.Node", ErrFieldOption)
+	testCompileError(t, "/o/,codec=unknown", &n, "*encoding.Node", ErrUnknownCodec)
+	testCompileError(t, "/o/", &BadTag{}, "*encoding.BadTag.A", ErrTagFirstSlash)
+	testCompileError(t, "/o/", &BadCodec{}, "*encoding.BadCodec.A", ErrUnknownCodec)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+var ErrKVWriterKey = errors.New("Sub-keys can only be written by objects stored with a recursive format")
+var ErrKVWriterValue = errors.New("Values can only be written by objects stored as a single key")
+
+// KVWriter is provided to KVMarshaler implementations in order to store key-value pairs.
+type KVWriter interface {
+	// Set stores a value at a key relative to the object path.
+	//
+	// When the object is stored as a single key (e.g. format "object"), the key must be empty,
+	// and the value is stored at the object path.
+	// When the object is stored recursively (e.g. format "object/"), the key must not be empty,
+	// and the value is stored at "object/<key>". The key may contain '/'.
+	Set(key string, value string) error
+}
+
+// KVMarshaler is implemented by types which control how they are stored as key-value pairs.
+//
+// It is used instead of the codec when storing a single value, or instead of the
+// default struct and map layouts when the object is stored recursively.
+type KVMarshaler interface {
+	MarshalKV(w KVWriter) error
+}
+
+// KVUnmarshaler is implemented by types which control how they are decoded from key-value pairs.
+//
+// UnmarshalKV is called for each key-value pair belonging to the object, with the key
+// relative to the object path, as provided to KVWriter.Set.
+// The value is nil when the key is deleted.
+type KVUnmarshaler interface {
+	UnmarshalKV(key string, value *string) error
+}
+
+var kvMarshalerType = reflect.TypeOf((*KVMarshaler)(nil)).Elem()
+var kvUnmarshalerType = reflect.TypeOf((*KVUnmarshaler)(nil)).Elem()
+
+// Returns the KVMarshaler implemented by the value or by a pointer to the value.
+func getKVMarshaler(v reflect.Value) (KVMarshaler, bool) {
+	t := v.Type()
+	info := getTypeInfo(t)
+	if info.kvMarshaler {
+		return v.Interface().(KVMarshaler), true
+	}
+	if !info.kvMarshalerPtr {
+		return nil, false
+	}
+	if v.CanAddr() {
+		return v.Addr().Interface().(KVMarshaler), true
+	}
+	p := reflect.New(t)
+	p.Elem().Set(v)
+	return p.Interface().(KVMarshaler), true
+}
+
+// Returns whether the values of a given type implement KVUnmarshaler, directly or through a pointer.
+func isKVUnmarshaler(t reflect.Type) bool {
+	return getTypeInfo(t).kvUnmarshaler
+}
+
+type kvWriter struct {
+	state *encodeState
+	o     objectPath
+}
+
+func (w *kvWriter) Set(key string, value string) error {
+	var k string
+	if key == "" {
+		if len(w.o.format) != 0 {
+			return ErrKVWriterValue
+		}
+		k = strings.Join(w.o.keypath, "/")
+	} else {
+		if len(w.o.format) == 0 {
+			return ErrKVWriterKey
+		}
+		k = strings.Join(append(w.o.keypath, key), "/")
+	}
+
+	return w.state.set(k, value)
+}
+
+func (state *encodeState) encodeMarshaler(o objectPath, m KVMarshaler) error {
+	if state.sink != nil {
+		// Keys may be written in any order
+		return state.buffer(func(inner *encodeState) error {
+			return inner.encodeMarshaler(o, m)
+		})
+	}
+	err := m.MarshalKV(&kvWriter{
+		state: state,
+		o:     o,
+	})
+	return o.wrap(err)
+}
+
+// Finds an object which keys are decoded by a KVUnmarshaler, and provides it with the key-value pair to set or delete.
+func findByKeyUnmarshaler(o objectPath, path []string, opt findOptions) (objectPath, error) {
+	var key string
+	if len(o.format) == 0 {
+		// The object is stored as a single key
+		if len(path) != 0 {
+			return o, o.wrap(ErrFindPathPastObject)
+		}
+	} else {
+		if len(path) == 0 {
+			// The path is a prefix of the object keys
+			return o, o.wrap(ErrFindKeyInvalid)
+		}
+		key = strings.Join(path, "/")
+	}
+
+	o.custom = true
+	if opt.SetValue == nil && !opt.DeleteValue {
+		return o, nil
+	}
+
+	if !o.value.IsValid() {
+		if opt.DeleteValue {
+			// Nothing to delete
+			return o, nil
+		}
+		return o, o.wrap(ErrFindSetNoExists)
+	}
+
+	var u KVUnmarshaler
+	if o.vtype.Implements(kvUnmarshalerType) {
+		u = o.value.Interface().(KVUnmarshaler)
+	} else if o.value.CanAddr() {
+		u = o.value.Addr().Interface().(KVUnmarshaler)
+	} else {
+		return findByKeyRevertAddressable(o, path, opt)
+	}
+
+	var err error
+	if opt.DeleteValue {
+		err = u.UnmarshalKV(key, nil)
+	} else {
+		err = u.UnmarshalKV(key, opt.SetValue)
+	}
+	if err != nil && !opt.IgnoreUnmarshalFailure {
+		return o, o.wrap(err)
+	}
+	return o, nil
+}
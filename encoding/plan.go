@@ -0,0 +1,190 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cached description of how a stored struct field is stored.
+// Formats must not be modified, as they are shared by all users of the plan.
+type fieldPlan struct {
+	index int
+	name  string
+	ftype reflect.Type
+
+	// The parsed field tag, or the error returned when parsing it.
+	format []string
+	opts   formatOptions
+	err    error
+
+	// The field formats used when decoding from one of the field aliases.
+	aliases [][]string
+
+	// The path of the field keys relative to the struct path (see keyPrefix).
+	prefix string
+}
+
+// Cached description of how a struct type is stored.
+type structPlan struct {
+	// Stored fields, in declaration order.
+	fields []fieldPlan
+
+	// Indexes in 'fields' of the fields which are not inlined, followed by inlined fields.
+	lookup []int
+
+	// Indexes in 'fields' by field name.
+	byName map[string]int
+
+	// Indexes in 'fields' of the fields sorted by key prefix, such that encoding
+	// the fields in that order provides keys in increasing order.
+	// It is nil when the struct has inlined fields, or fields which keys overlap.
+	ordered []int
+}
+
+// Cached properties of a type.
+type typeInfo struct {
+	// The type implements KVMarshaler.
+	kvMarshaler bool
+
+	// A pointer to the type implements KVMarshaler.
+	kvMarshalerPtr bool
+
+	// The type, or a pointer to the type, implements KVUnmarshaler.
+	kvUnmarshaler bool
+
+	// The type can be stored in text form (see isTextType).
+	text bool
+}
+
+var structPlans sync.Map // reflect.Type -> *structPlan
+var typeInfos sync.Map   // reflect.Type -> *typeInfo
+
+// Returns the plan of a struct type, computing it on first use.
+func getStructPlan(t reflect.Type) *structPlan {
+	if p, ok := structPlans.Load(t); ok {
+		return p.(*structPlan)
+	}
+
+	p := &structPlan{
+		byName: make(map[string]int),
+	}
+	var inlined []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !isStoredField(f) {
+			continue
+		}
+		fp := fieldPlan{
+			index: i,
+			name:  f.Name,
+			ftype: f.Type,
+		}
+		fp.format, fp.opts, fp.err = getStructFieldFormat(f)
+		for _, alias := range fp.opts.aliases {
+			fp.aliases = append(fp.aliases, aliasFormat(fp.format, alias))
+		}
+		p.byName[f.Name] = len(p.fields)
+		if fp.opts.inline {
+			inlined = append(inlined, len(p.fields))
+		} else {
+			p.lookup = append(p.lookup, len(p.fields))
+		}
+		p.fields = append(p.fields, fp)
+	}
+	p.lookup = append(p.lookup, inlined...)
+	if len(inlined) == 0 {
+		p.ordered = orderFields(p.fields)
+	}
+
+	actual, _ := structPlans.LoadOrStore(t, p)
+	return actual.(*structPlan)
+}
+
+// Returns the part of the keys of an object which is common to all of them, given its format:
+// the static path of the object, followed by '/' when it is stored as multiple keys.
+func keyPrefix(format []string) string {
+	i := 0
+	for i < len(format) && format[i] != "" && format[i] != "{key}" && format[i] != "{index}" {
+		i++
+	}
+	prefix := strings.Join(format[:i], "/")
+	if i != len(format) && i != 0 {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// Returns the fields indexes sorted by key prefix, or nil if the keys of different fields may be interleaved.
+func orderFields(fields []fieldPlan) []int {
+	ordered := make([]int, len(fields))
+	for i := range fields {
+		fields[i].prefix = keyPrefix(fields[i].format)
+		ordered[i] = i
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return fields[ordered[i]].prefix < fields[ordered[j]].prefix
+	})
+	for i := 1; i < len(ordered); i++ {
+		prev := &fields[ordered[i-1]]
+		if isStoredBelow(prev.format) && strings.HasPrefix(fields[ordered[i]].prefix, prev.prefix) {
+			// The previous field stores keys below the prefix of the next field
+			return nil
+		}
+	}
+	return ordered
+}
+
+// Returns whether an object is stored as multiple keys below its key prefix.
+func isStoredBelow(format []string) bool {
+	for _, e := range format {
+		if e == "" || e == "{key}" || e == "{index}" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the cached properties of a type, computing them on first use.
+func getTypeInfo(t reflect.Type) *typeInfo {
+	if i, ok := typeInfos.Load(t); ok {
+		return i.(*typeInfo)
+	}
+
+	pt := reflect.PtrTo(t)
+	i := &typeInfo{
+		kvMarshaler:    t.Implements(kvMarshalerType),
+		kvMarshalerPtr: pt.Implements(kvMarshalerType),
+		kvUnmarshaler:  t.Implements(kvUnmarshalerType) || pt.Implements(kvUnmarshalerType),
+		text: (t.Implements(textMarshalerType) || pt.Implements(textMarshalerType)) &&
+			pt.Implements(textUnmarshalerType),
+	}
+
+	actual, _ := typeInfos.LoadOrStore(t, i)
+	return actual.(*typeInfo)
+}
+
+// Continues the path with a struct field from a plan.
+func (o *objectPath) setFieldPlan(fp *fieldPlan) error {
+	if fp.err != nil {
+		return fp.err
+	}
+	o.format = fp.format
+	o.omitEmpty = fp.opts.omitEmpty
+	return o.setOptions(fp.opts)
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestStructPlan(t *testing.T) {
+	st := reflect.TypeOf(S16{})
+	p := getStructPlan(st)
+	if getStructPlan(st) != p {
+		t.Errorf("Plan was not cached")
+	}
+
+	// Skipped fields are not part of the plan, and inlined fields are looked up last
+	var names []string
+	for _, i := range p.lookup {
+		names = append(names, p.fields[i].name)
+	}
+	if !reflect.DeepEqual(names, []string{"Name", "Count", "Tags", "Addr", "Base"}) {
+		t.Errorf("Wrong lookup order %v", names)
+	}
+	if !reflect.DeepEqual(p.fields[p.byName["Addr"]].aliases, [][]string{{"addr", ""}, {"old", "address", ""}}) {
+		t.Errorf("Wrong aliases %v", p.fields[p.byName["Addr"]].aliases)
+	}
+
+	// Tag errors are returned when the field is used
+	_, err := Encode("/o/", &BadTag{})
+	failIfErrorDifferent(t, err, ErrTagFirstSlash)
+
+	if !getTypeInfo(reflect.TypeOf(Level(0))).text || getTypeInfo(reflect.TypeOf(0)).text {
+		t.Errorf("Wrong text type info")
+	}
+	if info := getTypeInfo(reflect.TypeOf(Temperature{})); !info.kvMarshaler || !info.kvUnmarshaler {
+		t.Errorf("Wrong marshaler type info")
+	}
+}
+
+// Removes all cached plans, such that the next use of each type computes its plan again.
+func clearPlans() {
+	structPlans.Range(func(k, v interface{}) bool {
+		structPlans.Delete(k)
+		return true
+	})
+	typeInfos.Range(func(k, v interface{}) bool {
+		typeInfos.Delete(k)
+		return true
+	})
+}
+
+type BenchEndpoint struct {
+	Address string `kvs:"address"`
+	Port    int    `kvs:"port"`
+	Weight  int    `kvs:"weight,omitempty"`
+	Enabled bool   `kvs:"enabled"`
+}
+
+type BenchService struct {
+	Name      string                   `kvs:"name"`
+	Protocol  string                   `kvs:"protocol"`
+	Level     Level                    `kvs:"level"`
+	Endpoints map[string]BenchEndpoint `kvs:"endpoints/{key}/"`
+}
+
+type BenchTenant struct {
+	Description string                  `kvs:"description"`
+	Services    map[uint32]BenchService `kvs:"services/{key}/,keywidth=6"`
+}
+
+type BenchRoot struct {
+	Tenants map[string]*BenchTenant `kvs:"tenants/{key}/"`
+}
+
+const benchTenants = 10
+const benchServices = 20
+const benchEndpoints = 5
+
+func benchObject() *BenchRoot {
+	r := &BenchRoot{
+		Tenants: make(map[string]*BenchTenant),
+	}
+	for t := 0; t < benchTenants; t++ {
+		tenant := &BenchTenant{
+			Description: "tenant",
+			Services:    make(map[uint32]BenchService),
+		}
+		for s := 0; s < benchServices; s++ {
+			service := BenchService{
+				Name:      fmt.Sprintf("service-%d", s),
+				Protocol:  "tcp",
+				Endpoints: make(map[string]BenchEndpoint),
+			}
+			for e := 0; e < benchEndpoints; e++ {
+				service.Endpoints[fmt.Sprintf("10.0.%d.%d", s, e)] = BenchEndpoint{
+					Address: fmt.Sprintf("10.0.%d.%d", s, e),
+					Port:    80,
+					Enabled: true,
+				}
+			}
+			tenant.Services[uint32(s)] = service
+		}
+		r.Tenants[fmt.Sprintf("tenant-%d", t)] = tenant
+	}
+	return r
+}
+
+func BenchmarkEncode(b *testing.B) {
+	r := benchObject()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := Encode("/root/", r)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchKeys(b *testing.B) ([]string, []string) {
+	kvs, err := Encode("/root/", benchObject())
+	if err != nil {
+		b.Fatal(err)
+	}
+	keys := make([]string, 0, len(kvs))
+	values := make([]string, 0, len(kvs))
+	for k, v := range kvs {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	return keys, values
+}
+
+func BenchmarkUpdateKeyObject(b *testing.B) {
+	keys, values := benchKeys(b)
+	r := &BenchRoot{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % len(keys)
+		_, err := UpdateKeyObject(r, "/root/", keys[j], values[j])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindByKey(b *testing.B) {
+	keys, _ := benchKeys(b)
+	r := benchObject()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := FindByKey(r, "/root/", keys[i%len(keys)])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Same as BenchmarkUpdateKeyObject, but without cached plans.
+func BenchmarkUpdateKeyObjectNoCache(b *testing.B) {
+	keys, values := benchKeys(b)
+	r := &BenchRoot{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clearPlans()
+		j := i % len(keys)
+		_, err := UpdateKeyObject(r, "/root/", keys[j], values[j])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// KeyPattern describes keys stored by the objects of a given type.
+type KeyPattern struct {
+	// The key pattern, with one '{key}' element per map level (e.g. "/db/Edges/{key}/node_id1").
+	// Patterns of nodes finish with '/'.
+	Pattern string
+
+	// Whether the pattern is a node containing other keys, rather than a key storing a value.
+	Node bool
+
+	// Path to the stored field from the root object (e.g. "Edges[key].NodeID1"),
+	// or the empty string for the root object.
+	Field string
+
+	// The type of the stored value, or of the object stored below the node.
+	Type reflect.Type
+
+	// The types of the map keys replacing the '{key}' elements of the pattern, in order.
+	Keys []reflect.Type
+
+	// The codec used to store the value, or the empty string for the default codec (JSON).
+	Codec string
+
+	// The value, or the keys below the node, are written by the KVMarshaler implemented by Type.
+	Custom bool
+
+	// The node stores an object of a type which is already stored by a parent node with the same format.
+	// The keys below the node are not listed, and follow the patterns below that parent node.
+	Recursive bool
+}
+
+type schemaWalker struct {
+	patterns []KeyPattern
+
+	// Types and formats of the parent nodes, which avoids looping on recursive types.
+	parents map[string]bool
+}
+
+// Schema returns the patterns of all the keys which objects of type t may store when stored
+// with the given format (a format string or a *Layout), including the nodes containing other keys.
+//
+// Patterns are listed in the order objects are traversed: nodes are followed by the patterns below them,
+// and struct fields are listed in declaration order, inlined fields last.
+// Keys of inlined fields which are shadowed by the parent struct fields are not listed.
+// The formats are checked as done by Compile.
+func Schema(format interface{}, t reflect.Type) ([]KeyPattern, error) {
+	var l *Layout
+	var err error
+	switch f := format.(type) {
+	case *Layout:
+		l = f
+		if l.t != t {
+			return nil, ErrLayoutType
+		}
+	case string:
+		l, err = Compile(f, t)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrFormatType
+	}
+
+	w := &schemaWalker{
+		parents: make(map[string]bool),
+	}
+	w.walk(t, l.path, nil, "", nil, l.opts.codec)
+	return w.patterns, nil
+}
+
+// MatchKey returns the field path of the object stored at a given key, for objects of type t stored
+// with the given format (a format string or a *Layout). It works like LookupKey, without
+// needing an object instance: map keys are parsed into values of the map key type.
+func MatchKey(format interface{}, t reflect.Type, key string) ([]interface{}, error) {
+	_, fields, err := LookupKey(reflect.New(t).Interface(), format, key)
+	return fields, err
+}
+
+// ValueError is returned by CheckKey, and by UpdateKeyObject in strict mode, when a value can't be decoded.
+type ValueError struct {
+	Key string
+
+	// Field path of the sub-object stored at the key.
+	Fields []interface{}
+
+	// The raw value.
+	Value string
+
+	// The decoding error.
+	Err error
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("Invalid value for key '%s': %v", e.Key, e.Err)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// CheckKey checks whether a key-value pair may have been stored by objects of type t stored
+// with the given format, and returns the field path of the object stored at the key.
+//
+// It returns the errors which are ignored when updating objects: errors finding the key
+// (e.g. ErrFindPathNotFound when no field is stored at that key, ErrFindPathPastObject when
+// the key is below a key storing a value, or ErrFindKeyInvalid when the key is a node containing
+// other keys), or a *ValueError when the value can't be decoded.
+func CheckKey(format interface{}, t reflect.Type, key string, value string) ([]interface{}, error) {
+	object := reflect.New(t).Interface()
+	root, err := rootObjectPath(object, format)
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Split(key, "/")
+	o, err := findByKey(root, path, findOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = findByKey(root, path, findOptions{
+		Create:   true,
+		SetValue: &value,
+	})
+	if perr, ok := err.(*PathError); ok {
+		// The location is given by the ValueError
+		err = perr.Err
+	}
+	if err != nil {
+		return o.fields, &ValueError{Key: key, Fields: o.fields, Value: value, Err: err}
+	}
+	return o.fields, nil
+}
+
+func (w *schemaWalker) add(p KeyPattern) {
+	for _, q := range w.patterns {
+		if q.Pattern == p.Pattern {
+			// Shadowed by a parent field
+			return
+		}
+	}
+	w.patterns = append(w.patterns, p)
+}
+
+func (w *schemaWalker) walk(t reflect.Type, format []string, keypath []string, field string, keys []reflect.Type, codec string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Stack static path elements, including the leading empty element of root formats
+	for len(format) != 0 && (format[0] != "" || len(format) > 1) && format[0] != "{key}" && format[0] != "{index}" {
+		keypath = append(keypath, format[0])
+		format = format[1:]
+	}
+
+	pattern := strings.Join(keypath, "/")
+	p := KeyPattern{
+		Pattern: pattern,
+		Field:   field,
+		Type:    t,
+		Keys:    keys,
+		Codec:   codec,
+		Custom:  t.Implements(kvMarshalerType) || reflect.PtrTo(t).Implements(kvMarshalerType),
+	}
+	if len(format) == 0 {
+		w.add(p)
+		return
+	}
+
+	p.Pattern += "/"
+	p.Node = true
+	p.Codec = ""
+	id := t.String() + "|" + strings.Join(format, "/")
+	p.Recursive = w.parents[id]
+	w.add(p)
+	if p.Custom || p.Recursive {
+		return
+	}
+	w.parents[id] = true
+	defer delete(w.parents, id)
+
+	switch t.Kind() {
+	case reflect.Struct:
+		plan := getStructPlan(t)
+		for _, i := range plan.lookup {
+			fp := &plan.fields[i]
+			fcodec := codec
+			if fp.opts.codec != "" {
+				fcodec = fp.opts.codec
+			}
+			fname := fp.name
+			if field != "" {
+				fname = field + "." + fp.name
+			}
+			w.walk(fp.ftype, fp.format, keypath, fname, keys, fcodec)
+		}
+	case reflect.Map:
+		elemKeys := append(append([]reflect.Type(nil), keys...), t.Key())
+		w.walk(t.Elem(), format[1:], append(keypath, "{key}"), field+"[key]", elemKeys, codec)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type Person struct {
+	Age    int
+	Name   string  `kvs:"name,codec=text"`
+	Parent *Person `kvs:"parent/"`
+}
+
+type Edge struct {
+	NodeID1 string `kvs:"node_id1"`
+	NodeID2 string `kvs:"node_id2"`
+}
+
+type Graph struct {
+	Nodes  map[string]Person `kvs:"Nodes/{key}"`
+	Edges  map[int]Edge      `kvs:"Edges/{key}/"`
+	Labels Labels            `kvs:"labels/"`
+	Owner  Person            `kvs:"owner/"`
+}
+
+func testSchema(t *testing.T, format interface{}, typ reflect.Type, expected []KeyPattern) {
+	patterns, err := Schema(format, typ)
+	failIfError(t, err)
+	if len(patterns) != len(expected) {
+		t.Errorf("Wrong number of patterns %d instead of %d: %v", len(patterns), len(expected), patterns)
+		return
+	}
+	for i := range patterns {
+		if !reflect.DeepEqual(patterns[i], expected[i]) {
+			t.Errorf("Wrong pattern %v instead of %v", patterns[i], expected[i])
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	intType := reflect.TypeOf(0)
+	stringType := reflect.TypeOf("")
+	personType := reflect.TypeOf(Person{})
+	edgeType := reflect.TypeOf(Edge{})
+	graphType := reflect.TypeOf(Graph{})
+
+	testSchema(t, "/db/", graphType, []KeyPattern{
+		{Pattern: "/db/", Node: true, Type: graphType},
+		{Pattern: "/db/Nodes/", Node: true, Field: "Nodes", Type: reflect.TypeOf(map[string]Person{})},
+		{Pattern: "/db/Nodes/{key}", Field: "Nodes[key]", Type: personType, Keys: []reflect.Type{stringType}},
+		{Pattern: "/db/Edges/", Node: true, Field: "Edges", Type: reflect.TypeOf(map[int]Edge{})},
+		{Pattern: "/db/Edges/{key}/", Node: true, Field: "Edges[key]", Type: edgeType, Keys: []reflect.Type{intType}},
+		{Pattern: "/db/Edges/{key}/node_id1", Field: "Edges[key].NodeID1", Type: stringType, Keys: []reflect.Type{intType}},
+		{Pattern: "/db/Edges/{key}/node_id2", Field: "Edges[key].NodeID2", Type: stringType, Keys: []reflect.Type{intType}},
+		{Pattern: "/db/labels/", Node: true, Field: "Labels", Type: reflect.TypeOf(Labels{}), Custom: true},
+		{Pattern: "/db/owner/", Node: true, Field: "Owner", Type: personType},
+		{Pattern: "/db/owner/Age", Field: "Owner.Age", Type: intType},
+		{Pattern: "/db/owner/name", Field: "Owner.Name", Type: stringType, Codec: "text"},
+		{Pattern: "/db/owner/parent/", Node: true, Field: "Owner.Parent", Type: personType, Recursive: true},
+	})
+
+	// Inlined fields shadowed by the parent fields are not listed
+	s16 := reflect.TypeOf(S16{})
+	s10 := reflect.TypeOf(S10{})
+	testSchema(t, "/o/", s16, []KeyPattern{
+		{Pattern: "/o/", Node: true, Type: s16},
+		{Pattern: "/o/name", Field: "Name", Type: stringType},
+		{Pattern: "/o/count", Field: "Count", Type: intType},
+		{Pattern: "/o/tags/", Node: true, Field: "Tags", Type: reflect.TypeOf(map[string]string{})},
+		{Pattern: "/o/tags/{key}", Field: "Tags[key]", Type: stringType, Keys: []reflect.Type{stringType}},
+		{Pattern: "/o/address/", Node: true, Field: "Addr", Type: s10},
+		{Pattern: "/o/address/A", Field: "Addr.A", Type: intType},
+		{Pattern: "/o/id", Field: "Base.ID", Type: stringType},
+	})
+
+	// Nested maps and root maps
+	l, err := Compile("/m/{key}/x/{key},codec=text", reflect.TypeOf(map[string]map[int]int{}))
+	failIfError(t, err)
+	testSchema(t, l, reflect.TypeOf(map[string]map[int]int{}), []KeyPattern{
+		{Pattern: "/m/", Node: true, Type: reflect.TypeOf(map[string]map[int]int{})},
+		{Pattern: "/m/{key}/x/", Node: true, Field: "[key]", Type: reflect.TypeOf(map[int]int{}), Keys: []reflect.Type{stringType}},
+		{Pattern: "/m/{key}/x/{key}", Field: "[key][key]", Type: intType, Keys: []reflect.Type{stringType, intType}, Codec: "text"},
+	})
+
+	_, err = Schema("/m/", reflect.TypeOf(map[string]int{}))
+	if lerr, ok := err.(*LayoutError); !ok || lerr.Err != ErrMapFormat {
+		t.Errorf("Wrong error %v", err)
+	}
+	_, err = Schema(l, graphType)
+	failIfErrorDifferent(t, err, ErrLayoutType)
+}
+
+func TestMatchKey(t *testing.T) {
+	graphType := reflect.TypeOf(Graph{})
+	for _, c := range []struct {
+		key    string
+		fields []interface{}
+		err    error
+	}{
+		{"/db/Nodes/a", []interface{}{"Nodes", "a"}, nil},
+		{"/db/Edges/12/node_id2", []interface{}{"Edges", 12, "NodeID2"}, nil},
+		{"/db/labels/a/b", []interface{}{"Labels"}, nil},
+		{"/db/owner/parent/parent/name", []interface{}{"Owner", "Parent", "Parent", "Name"}, nil},
+		{"/db/Nodes/a/Age", nil, ErrFindPathPastObject},
+		{"/db/unknown", nil, ErrFindPathNotFound},
+	} {
+		fields, err := MatchKey("/db/", graphType, c.key)
+		if !errors.Is(err, c.err) {
+			t.Errorf("Key %s: wrong error %v", c.key, err)
+		}
+		if err == nil && !reflect.DeepEqual(fields, c.fields) {
+			t.Errorf("Key %s: wrong fields %v", c.key, fields)
+		}
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	graphType := reflect.TypeOf(Graph{})
+
+	fields, err := CheckKey("/db/", graphType, "/db/Edges/1/node_id1", "n1")
+	failIfError(t, err)
+	if !reflect.DeepEqual(fields, []interface{}{"Edges", 1, "NodeID1"}) {
+		t.Errorf("Wrong fields %v", fields)
+	}
+
+	for _, key := range []string{"/db/owner/Age", "/db/Nodes/a"} {
+		fields, err = CheckKey("/db/", graphType, key, "{invalid")
+		if verr, ok := err.(*ValueError); !ok || verr.Key != key {
+			t.Errorf("Key %s: wrong error %v", key, err)
+		}
+		if len(fields) != 2 {
+			t.Errorf("Key %s: wrong fields %v", key, fields)
+		}
+	}
+
+	_, err = CheckKey("/db/", graphType, "/db/owner/Age/x", "1")
+	failIfErrorDifferent(t, err, ErrFindPathPastObject)
+	_, err = CheckKey("/db/", graphType, "/db/Edges/1", "1")
+	failIfErrorDifferent(t, err, ErrFindKeyInvalid)
+	_, err = CheckKey("/db/", graphType, "/db/Old", "1")
+	failIfErrorDifferent(t, err, ErrFindPathNotFound)
+	_, err = CheckKey("/db/", graphType, "/db/Edges/x/node_id1", "1")
+	if _, ok := err.(*ValueError); err == nil || ok {
+		t.Errorf("Wrong error %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"reflect"
+	"sort"
+)
+
+// KVSink receives the keys of an object encoded by EncodeStream.
+type KVSink interface {
+	// Set is called for each stored key-value pair.
+	Set(key string, value string) error
+
+	// Omit is called for each key of an empty field which was omitted because of
+	// the 'omitempty' tag option, and should therefore be deleted.
+	// Keys of fields stored as multiple keys finish with '/'.
+	Omit(key string) error
+}
+
+// EncodeStream works like EncodeWithDeletions, but provides the keys to the sink
+// instead of returning them. Keys are provided in increasing order, calls to Set and Omit
+// being interleaved. Encoding stops at the first error returned by the sink.
+//
+// Keys are provided while the object is encoded, such that the whole encoded object is not
+// kept in memory. Only the keys of structs which fields are inlined or stored with overlapping
+// paths, of objects implementing KVMarshaler, and of generated objects are sorted in memory first.
+func EncodeStream(format interface{}, object interface{}, sink KVSink, fields ...interface{}) error {
+	state := &encodeState{
+		sink: sink,
+	}
+
+	if g, prefix, ok := generatedObject(object, format); ok && len(fields) == 0 {
+		return state.buffer(func(inner *encodeState) error {
+			return g.EncodeKV(prefix, inner.kvs, &inner.omitted)
+		})
+	}
+
+	o, err := rootObjectPath(object, format)
+	if err != nil {
+		return err
+	}
+
+	o, err = findByFields(o, fields, findOptions{})
+	if err != nil {
+		return err
+	}
+	if !o.value.IsValid() {
+		return o.wrap(ErrFindObjectNotFound)
+	}
+
+	return state.encode(o)
+}
+
+// Stores a key-value pair, or provides it to the sink.
+func (state *encodeState) set(key string, value string) error {
+	if state.sink == nil {
+		if _, ok := state.kvs[key]; ok {
+			return keyError(key, nil, ErrKeyConflict)
+		}
+		state.kvs[key] = value
+		return nil
+	}
+
+	if state.started && key <= state.lastKey {
+		if key == state.lastKey {
+			return keyError(key, nil, ErrKeyConflict)
+		}
+		return keyError(key, nil, ErrKeyOrder)
+	}
+	state.started, state.lastKey = true, key
+	return state.sink.Set(key, value)
+}
+
+// Records an omitted key, or provides it to the sink.
+func (state *encodeState) omitKey(key string) error {
+	if state.sink == nil {
+		state.omitted = append(state.omitted, key)
+		return nil
+	}
+
+	if state.started && key <= state.lastKey {
+		return keyError(key, nil, ErrKeyOrder)
+	}
+	state.started, state.lastKey = true, key
+	return state.sink.Omit(key)
+}
+
+// Encodes keys which can't be provided in order into a temporary state,
+// and provides them to the sink once sorted.
+func (state *encodeState) buffer(encode func(inner *encodeState) error) error {
+	inner := &encodeState{
+		kvs: make(map[string]string),
+	}
+	err := encode(inner)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(inner.kvs))
+	for k := range inner.kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sort.Strings(inner.omitted)
+
+	omitted := inner.omitted
+	for _, k := range keys {
+		for len(omitted) != 0 && omitted[0] < k {
+			err = state.omitKey(omitted[0])
+			if err != nil {
+				return err
+			}
+			omitted = omitted[1:]
+		}
+		err = state.set(k, inner.kvs[k])
+		if err != nil {
+			return err
+		}
+	}
+	for _, k := range omitted {
+		err = state.omitKey(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encodes the fields of a struct in key order.
+func (state *encodeState) encodeStructOrdered(o objectPath, plan *structPlan) error {
+	v := o.value
+	codec, keyWidth, fields := o.codec, o.keyWidth, o.fields
+	for _, i := range plan.ordered {
+		fp := &plan.fields[i]
+		o.value, o.vtype, o.fields = v.Field(fp.index), fp.ftype, append(fields, fp.name)
+		o.codec, o.keyWidth = codec, keyWidth
+		err := o.setFieldPlan(fp)
+		if err != nil {
+			return o.wrap(err)
+		}
+
+		err = state.encode(o)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encodes the elements of a map in key order.
+// The "{key}" element is already removed from the format.
+func (state *encodeState) encodeMapOrdered(o objectPath) error {
+	type element struct {
+		key    reflect.Value
+		str    string
+		prefix string
+	}
+
+	v := o.value
+	elements := make([]element, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		str, err := serializeMapKey(k, o.keyWidth)
+		if err != nil {
+			return o.wrap(err)
+		}
+		prefix := str
+		if len(o.format) != 0 {
+			// The element keys are below the map key
+			prefix += "/"
+		}
+		elements = append(elements, element{key: k, str: str, prefix: prefix})
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].prefix < elements[j].prefix
+	})
+
+	fields := o.fields
+	for _, e := range elements {
+		o.value, o.vtype, o.fields = reflect.Indirect(v.MapIndex(e.key)), mapElemType(v.Type()), append(fields, e.key.Interface())
+		o.keypath = append(o.keypath, e.str)
+		err := state.encode(o)
+		if err != nil {
+			return err
+		}
+		o.keypath = o.keypath[:len(o.keypath)-1]
+	}
+	return nil
+}
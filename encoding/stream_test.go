@@ -0,0 +1,143 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type S20 struct {
+	Z     string            `kvs:"z"`
+	Sub   S10               `kvs:"a/"`
+	Dirs  map[string]S10    `kvs:"dirs/{key}/"`
+	Blobs map[string]string `kvs:"dirs-blobs/{key}"`
+	Empty map[string]int    `kvs:"empty/{key},omitempty"`
+	Y     int               `kvs:"a-y"`
+}
+
+// Keys of different fields are interleaved
+type S21 struct {
+	A S10 `kvs:"a/"`
+	B int `kvs:"a/b"`
+}
+
+type sinkOp struct {
+	key   string
+	value string
+	omit  bool
+}
+
+type testSink struct {
+	ops []sinkOp
+	max int
+}
+
+var errSinkFull = errors.New("Sink is full")
+
+func (s *testSink) add(op sinkOp) error {
+	if s.max != 0 && len(s.ops) == s.max {
+		return errSinkFull
+	}
+	s.ops = append(s.ops, op)
+	return nil
+}
+
+func (s *testSink) Set(key string, value string) error {
+	return s.add(sinkOp{key: key, value: value})
+}
+
+func (s *testSink) Omit(key string) error {
+	return s.add(sinkOp{key: key, omit: true})
+}
+
+func testEncodeStream(t *testing.T, format interface{}, object interface{}) {
+	kvs, omitted, err := EncodeWithDeletions(format, object)
+	failIfError(t, err)
+
+	sink := &testSink{}
+	err = EncodeStream(format, object, sink)
+	failIfError(t, err)
+
+	kvs2 := make(map[string]string)
+	var omitted2 []string
+	for i, op := range sink.ops {
+		if i != 0 && op.key <= sink.ops[i-1].key {
+			t.Errorf("Key '%s' provided after '%s'", op.key, sink.ops[i-1].key)
+		}
+		if op.omit {
+			omitted2 = append(omitted2, op.key)
+		} else {
+			kvs2[op.key] = op.value
+		}
+	}
+	if !reflect.DeepEqual(kvs, kvs2) {
+		t.Errorf("Streamed keys %v instead of %v", kvs2, kvs)
+	}
+	sort.Strings(omitted)
+	if !reflect.DeepEqual(omitted, omitted2) {
+		t.Errorf("Streamed omitted keys %v instead of %v", omitted2, omitted)
+	}
+}
+
+func TestEncodeStream(t *testing.T) {
+	s20 := &S20{
+		Z:     "z",
+		Sub:   S10{A: 1},
+		Dirs:  map[string]S10{"a": {A: 2}, "a-": {A: 3}, "a/b": {A: 4}, "b": {A: 5}},
+		Blobs: map[string]string{"x": "1", "x-y": "2"},
+		Y:     6,
+	}
+	testEncodeStream(t, "/o/", s20)
+	testEncodeStream(t, "/o/", &S21{A: S10{A: 1}, B: 2})
+	testEncodeStream(t, "/o/", &S16{Base: Base{ID: "id", Name: "base"}, Name: "name", Addr: S10{A: 1}})
+	testEncodeStream(t, "/o/", &S14{
+		IP:     net.ParseIP("10.0.0.1"),
+		Temp:   Temperature{Celsius: 20},
+		Labels: Labels{m: map[string]string{"b": "1", "a/c": "2", "a": "3"}},
+		Temps:  map[string]Temperature{"x": {Celsius: 1}, "w": {Celsius: 2}},
+	})
+	testEncodeStream(t, "/o/", &S19{
+		Tenants: map[string]map[string]S10{"t1": {"s2": {A: 1}, "s1": {A: 2}}, "t": {"s": {A: 3}}},
+		Items:   map[int]S10{10: {A: 1}, 9: {A: 2}},
+	})
+	testEncodeStream(t, "/m/{key}/,keywidth=3", &map[int]S20{12: *s20, 3: {}})
+
+	if getStructPlan(reflect.TypeOf(S20{})).ordered == nil {
+		t.Errorf("S20 fields should be ordered")
+	}
+	if getStructPlan(reflect.TypeOf(S21{})).ordered != nil || getStructPlan(reflect.TypeOf(S16{})).ordered != nil {
+		t.Errorf("S16 and S21 fields should not be ordered")
+	}
+
+	// Encoding stops at the first sink error
+	sink := &testSink{max: 3}
+	err := EncodeStream("/o/", s20, sink)
+	failIfErrorDifferent(t, err, errSinkFull)
+	if len(sink.ops) != 3 || sink.ops[0].key != "/o/a-y" || sink.ops[2].key != "/o/dirs-blobs/x" {
+		t.Errorf("Wrong streamed keys %v", sink.ops)
+	}
+
+	// Duplicated keys are detected
+	type S22 struct {
+		A int `kvs:"a"`
+		B int `kvs:"a"`
+	}
+	err = EncodeStream("/o/", &S22{}, &testSink{})
+	failIfNotError(t, err)
+}
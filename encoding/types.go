@@ -0,0 +1,137 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+var ErrUnregisteredType = errors.New("Type of interface value is not registered")
+var ErrUnknownTypeName = errors.New("Unknown type name")
+var ErrTypeNotAssignable = errors.New("Registered type does not implement the interface")
+
+// Registry of the concrete types which may be stored in interface values.
+var types = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+// RegisterType makes the type of the provided value storable in interface
+// fields and map values, under the given name.
+//
+// Interface values are stored as a single key, using a JSON envelope
+// containing the type name and the JSON encoded value
+// (e.g. {"type":"http","value":{"URL":"http://example.com"}}).
+// Decoding the envelope creates a value of the registered type.
+// Registering a pointer (e.g. &HTTPCheck{}) will decode pointers.
+//
+// Values of unregistered types stored in empty interfaces (interface{}) are
+// stored as plain JSON, and decoded as by json.Unmarshal (e.g. into a map[string]interface{}).
+func RegisterType(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	types.Lock()
+	defer types.Unlock()
+	if old, ok := types.byName[name]; ok {
+		delete(types.byType, old)
+	}
+	types.byName[name] = t
+	types.byType[t] = name
+}
+
+// Envelope used to store interface values.
+type typeEnvelope struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Serializes the concrete value of a non-nil interface value.
+func serializeInterface(v reflect.Value) (string, error) {
+	e := v.Elem()
+	types.RLock()
+	name, ok := types.byType[e.Type()]
+	types.RUnlock()
+	if !ok && v.NumMethod() != 0 {
+		// The value could not be decoded without its type
+		return "", ErrUnregisteredType
+	}
+
+	value, err := json.Marshal(e.Interface())
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return string(value), nil
+	}
+	arr, err := json.Marshal(typeEnvelope{
+		Type:  name,
+		Value: value,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(arr), nil
+}
+
+// Returns an interface value of type t, containing the value stored in the envelope.
+// Empty interfaces also accept plain JSON values, stored without envelope.
+func unserializeInterface(val string, t reflect.Type) (reflect.Value, error) {
+	var env typeEnvelope
+	err := json.Unmarshal([]byte(val), &env)
+
+	types.RLock()
+	ct, ok := types.byName[env.Type]
+	types.RUnlock()
+	if (err != nil || !ok) && t.NumMethod() == 0 {
+		return unserializePlainInterface(val, t)
+	}
+	if err != nil {
+		return reflect.Zero(t), err
+	}
+	if !ok {
+		return reflect.Zero(t), ErrUnknownTypeName
+	}
+	if !ct.AssignableTo(t) {
+		return reflect.Zero(t), ErrTypeNotAssignable
+	}
+
+	c := reflect.New(ct)
+	if len(env.Value) != 0 {
+		err = json.Unmarshal(env.Value, c.Interface())
+		if err != nil {
+			return reflect.Zero(t), err
+		}
+	}
+
+	v := reflect.New(t).Elem()
+	v.Set(c.Elem())
+	return v, nil
+}
+
+// Returns an empty interface value of type t, containing a plain JSON value.
+func unserializePlainInterface(val string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t)
+	err := json.Unmarshal([]byte(val), v.Interface())
+	if err != nil {
+		return reflect.Zero(t), err
+	}
+	return v.Elem(), nil
+}
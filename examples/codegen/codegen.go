@@ -0,0 +1,102 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Example storing and synchronizing objects with generated encoding functions.
+package main
+
+//go:generate go run github.com/Oryon/kvsync/cmd/kvsync-gen -type Directory
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Oryon/kvsync/kvs/gomap"
+	"github.com/Oryon/kvsync/store"
+	"github.com/Oryon/kvsync/sync"
+)
+
+// Directory is stored with format '/dir/'.
+// kvsync-gen generates its encoding functions, as well as the ones of
+// Student and Address which are stored recursively below it.
+type Directory struct {
+	Name     string
+	Students map[int]Student     `kvs:"students/{key}/,keywidth=4"`
+	Admins   map[uint16]*Student `kvs:"admins/{key}/info/"`
+	Tags     map[string]string   `kvs:"tags/{key}/value"`
+	Counter  uint32              `kvs:"stats/counter"`
+	Enabled  bool
+	Meta     Meta `kvs:"meta/,omitempty"`
+}
+
+type Student struct {
+	Name    string
+	Age     int                `kvs:"age,omitempty"`
+	Address *Address           `kvs:"address/"`
+	Grades  map[string]float64 `kvs:"grades/{key}"`
+	IP      net.IP             `kvs:"ip,omitempty"`
+	Notes   string             `kvs:"-"`
+}
+
+type Address struct {
+	Street string
+	City   string `kvs:"city"`
+}
+
+type Meta struct {
+	Version int64
+	Labels  map[string]string `kvs:"labels"`
+}
+
+func main() {
+	c := context.Background()
+	gm := gomap.Create()
+
+	dir := Directory{}
+	s := sync.Sync{
+		Sync: gm,
+	}
+	err := s.SyncObject(sync.SyncObject{
+		Object: &dir,
+		Format: "/dir/",
+		Callback: func(e *sync.SyncEvent) error {
+			// Field name constants are generated for each stored field
+			var id int
+			if e2 := e.Field(DirectoryFieldStudents).Value(&id).Field(StudentFieldName); e2.Error() == nil {
+				name, _ := e2.Current()
+				fmt.Printf("Student %d is named %v\n", id, name)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = store.Set(gm, c, &Directory{}, "/dir/", Student{
+		Name:    "Alice",
+		Address: &Address{City: "Paris"},
+	}, DirectoryFieldStudents, 1)
+	if err != nil {
+		panic(err)
+	}
+
+	for len(dir.Students) == 0 || dir.Students[1].Address == nil {
+		if err := s.Next(c); err != nil {
+			panic(err)
+		}
+	}
+
+	fmt.Printf("Final KV state %v\n", gm.GetBackingMap())
+}
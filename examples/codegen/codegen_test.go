@@ -0,0 +1,331 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Oryon/kvsync/encoding"
+	"github.com/Oryon/kvsync/kvs/gomap"
+	"github.com/Oryon/kvsync/store"
+	"github.com/Oryon/kvsync/sync"
+)
+
+// Same as Directory, without the generated methods, such that reflection is used.
+type plainDirectory Directory
+
+const format = "/dir/"
+
+func testDirectory() Directory {
+	return Directory{
+		Name: "dir",
+		Students: map[int]Student{
+			1: {
+				Name:    "Alice",
+				Age:     20,
+				Address: &Address{Street: "1 rue de Rivoli", City: "Paris"},
+				Grades:  map[string]float64{"math": 4.5, "a/b": 3},
+				IP:      net.ParseIP("10.0.0.1"),
+				Notes:   "not stored",
+			},
+			42: {
+				Name: "Bob",
+			},
+		},
+		Admins: map[uint16]*Student{
+			3: {Name: "Carol", Age: 40},
+		},
+		Tags:    map[string]string{"a": "b", "c%d": "e"},
+		Counter: 7,
+		Enabled: true,
+		Meta: Meta{
+			Version: 2,
+			Labels:  map[string]string{"x": "y"},
+		},
+	}
+}
+
+// Returns the stored state of an object.
+func stored(t *testing.T, object interface{}) map[string]string {
+	kvs, err := encoding.Encode(format, object)
+	if err != nil {
+		t.Fatalf("Encode returned %v", err)
+	}
+	return kvs
+}
+
+func TestGeneratedEncode(t *testing.T) {
+	for _, d := range []Directory{{}, testDirectory()} {
+		kvs, omitted, err := encoding.EncodeWithDeletions(format, &d)
+		if err != nil {
+			t.Fatalf("EncodeWithDeletions returned %v", err)
+		}
+		kvs2, omitted2, err := encoding.EncodeWithDeletions(format, (*plainDirectory)(&d))
+		if err != nil {
+			t.Fatalf("EncodeWithDeletions returned %v", err)
+		}
+		if !reflect.DeepEqual(kvs, kvs2) {
+			t.Errorf("Generated encoding %v differs from %v", kvs, kvs2)
+		}
+		if !reflect.DeepEqual(omitted, omitted2) {
+			t.Errorf("Generated omitted keys %v differ from %v", omitted, omitted2)
+		}
+	}
+}
+
+// Keys which are not stored by Directory objects.
+// Directory keys (finishing with '/') are not used, as they are never updated nor deleted.
+var testKeys = []string{
+	"/dir",
+	"/dir/unknown",
+	"/dir/Name/sub",
+	"/dir/stats",
+	"/dir/students",
+	"/dir/students/0001/unknown",
+	"/dir/students/0001/address",
+	"/dir/students/0001/grades/math/x",
+	"/dir/students/0001/Age",
+	"/dir/students/0001/grades/x",
+	"/dir/students/0999",
+	"/dir/students/xyz/Name",
+	"/dir/admins/3/other/Name",
+	"/dir/admins/70000/info/Name",
+	"/dir/admins/9",
+	"/dir/tags/a",
+	"/dir/tags/a/other",
+	"/dir/tags/a/value/x",
+	"/dir/tags/x",
+	"/dir/tags/x/value",
+	"/dir/tags/%zz/value",
+	"/dir/meta",
+	"/dir/meta/labels",
+}
+
+// Returns the cause of an error, without its location which generated code does not know.
+func errorCause(err error) error {
+	var perr *encoding.PathError
+	if errors.As(err, &perr) {
+		return perr.Err
+	}
+	return err
+}
+
+// Compares the errors and field paths returned by generated and reflection functions.
+func compareResults(t *testing.T, k string, fields []interface{}, err error, fields2 []interface{}, err2 error) {
+	err, err2 = errorCause(err), errorCause(err2)
+	if err != err2 && (err == nil || err2 == nil || err.Error() != err2.Error()) {
+		t.Errorf("Key %s: generated error %v differs from %v", k, err, err2)
+	}
+	if !reflect.DeepEqual(fields, fields2) {
+		t.Errorf("Key %s: generated fields %v differ from %v", k, fields, fields2)
+	}
+}
+
+func TestGeneratedUpdate(t *testing.T) {
+	kvs := stored(t, &Directory{})
+	for k := range stored(t, (*plainDirectory)(&Directory{Students: testDirectory().Students})) {
+		kvs[k] = "invalid"
+	}
+	full := plainDirectory(testDirectory())
+	for k, v := range stored(t, &full) {
+		kvs[k] = v
+	}
+
+	var keys []string
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var d Directory
+	var p plainDirectory
+	for _, k := range append(keys, keys...) {
+		fields, err := encoding.UpdateKeyObject(&d, format, k, kvs[k])
+		fields2, err2 := encoding.UpdateKeyObject(&p, format, k, kvs[k])
+		compareResults(t, k, fields, err, fields2, err2)
+		if !reflect.DeepEqual(d, Directory(p)) {
+			t.Fatalf("Key %s: generated object %v differs from %v", k, d, p)
+		}
+	}
+
+	// Reflection may create empty map elements when a key is not found,
+	// so the objects are not compared.
+	for _, k := range append(testKeys, "/dir/students/0001", "/dir/admins/3", "/dir/admins/3/info") {
+		fields, err := encoding.UpdateKeyObject(&Directory{}, format, k, "1")
+		fields2, err2 := encoding.UpdateKeyObject(&plainDirectory{}, format, k, "1")
+		compareResults(t, k, fields, err, fields2, err2)
+	}
+}
+
+// Values which are not all valid JSON, parsed into scalar fields.
+var testValues = []string{
+	"7", "007", " 7", "7 ", "+7", "-7", "-0", "7.0", "1e2", "99999999999", "0x7", "",
+	"true", " true\n", "True", "TRUE", "false", "1", "null", "\"7\"",
+}
+
+func TestGeneratedValues(t *testing.T) {
+	keys := []string{"/dir/stats/counter", "/dir/Enabled", "/dir/meta/Version", "/dir/students/0001/age"}
+	for _, k := range keys {
+		for _, v := range testValues {
+			d := testDirectory()
+			p := plainDirectory(testDirectory())
+			fields, err := encoding.UpdateKeyObject(&d, format, k, v)
+			fields2, err2 := encoding.UpdateKeyObject(&p, format, k, v)
+			compareResults(t, k, fields, err, fields2, err2)
+			if !reflect.DeepEqual(d, Directory(p)) {
+				t.Errorf("Key %s, value '%s': generated object %v differs from %v", k, v, d, p)
+			}
+		}
+	}
+}
+
+func TestGeneratedDelete(t *testing.T) {
+	var keys []string
+	for k := range stored(t, &Directory{}) {
+		keys = append(keys, k)
+	}
+	keys = append(keys, testKeys...)
+	keys = append(keys, "/dir/students/0001", "/dir/admins/3", "/dir/admins/3/info")
+	for k := range stored(t, (*plainDirectory)(&Directory{Admins: testDirectory().Admins})) {
+		keys = append(keys, k)
+	}
+	d := testDirectory()
+	for k := range stored(t, &d) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		d := testDirectory()
+		p := plainDirectory(testDirectory())
+		fields, err := encoding.DeleteKeyObject(&d, format, k)
+		fields2, err2 := encoding.DeleteKeyObject(&p, format, k)
+		compareResults(t, k, fields, err, fields2, err2)
+		if !reflect.DeepEqual(d, Directory(p)) {
+			t.Errorf("Key %s: generated object %v differs from %v", k, d, p)
+		}
+	}
+}
+
+func TestGeneratedLookup(t *testing.T) {
+	d := testDirectory()
+	keys := []string{
+		"/dir", "/dir/students", "/dir/students/0001", "/dir/students/0042/address", "/dir/students/0042/address/city",
+		"/dir/students/0099/address/city", "/dir/admins/3", "/dir/admins/3/info", "/dir/admins/4/info/Name",
+		"/dir/tags", "/dir/tags/a", "/dir/stats", "/dir/meta", "/dir/meta/labels", "/dir/students/0001/grades",
+		"/dir/students/0001/grades/x", "/dir/other", "/other",
+	}
+	for k := range stored(t, &d) {
+		keys = append(keys, k)
+	}
+	keys = append(keys, testKeys...)
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		d := testDirectory()
+		p := plainDirectory(testDirectory())
+		v, fields, err := encoding.LookupKey(&d, format, k)
+		v2, fields2, err2 := encoding.LookupKey(&p, format, k)
+		compareResults(t, k, fields, err, fields2, err2)
+		if pd, ok := v2.(*plainDirectory); ok {
+			v2 = (*Directory)(pd)
+		}
+		if !reflect.DeepEqual(v, v2) {
+			t.Errorf("Key %s: generated value %v differs from %v", k, v, v2)
+		}
+	}
+
+	// Values are copies
+	v, _, err := encoding.LookupKey(&d, format, "/dir/admins/3")
+	if err != nil || v.(*Student) == d.Admins[3] {
+		t.Errorf("Pointer values should be copied %v", err)
+	}
+}
+
+func TestGeneratedStoreSync(t *testing.T) {
+	c := context.Background()
+	gm := gomap.Create()
+
+	var synced Directory
+	var paths [][]interface{}
+	s := sync.Sync{
+		Sync: gm,
+	}
+	err := s.SyncObject(sync.SyncObject{
+		Object: &synced,
+		Format: format,
+		Callback: func(e *sync.SyncEvent) error {
+			var id int
+			if e2 := e.Field(DirectoryFieldStudents).Value(&id).Field(StudentFieldAddress).Field(AddressFieldCity); e2.Error() == nil {
+				paths = append(paths, []interface{}{id})
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := testDirectory()
+	err = store.Store(gm, c, &d, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(gm.GetBackingMap()) != 0 && !reflect.DeepEqual(stored(t, &synced), stored(t, &d)) {
+		if err := s.Next(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(paths, [][]interface{}{{1}}) {
+		t.Errorf("Wrong callbacks %v", paths)
+	}
+
+	if !reflect.DeepEqual(gm.GetBackingMap(), stored(t, (*plainDirectory)(&d))) {
+		t.Errorf("Wrong stored state %v", gm.GetBackingMap())
+	}
+}
+
+func BenchmarkGeneratedEncode(b *testing.B) {
+	d := testDirectory()
+	for i := 0; i < b.N; i++ {
+		encoding.Encode(format, &d)
+	}
+}
+
+func BenchmarkReflectionEncode(b *testing.B) {
+	d := plainDirectory(testDirectory())
+	for i := 0; i < b.N; i++ {
+		encoding.Encode(format, &d)
+	}
+}
+
+func BenchmarkGeneratedUpdate(b *testing.B) {
+	var d Directory
+	for i := 0; i < b.N; i++ {
+		encoding.UpdateKeyObject(&d, format, "/dir/students/0001/address/city", "Paris")
+	}
+}
+
+func BenchmarkReflectionUpdate(b *testing.B) {
+	var d plainDirectory
+	for i := 0; i < b.N; i++ {
+		encoding.UpdateKeyObject(&d, format, "/dir/students/0001/address/city", "Paris")
+	}
+}
@@ -0,0 +1,867 @@
+// Code generated by kvsync-gen. DO NOT EDIT.
+
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Oryon/kvsync/encoding"
+)
+
+// Names of the stored fields of Directory, for SyncEvent navigation.
+const (
+	DirectoryFieldName     = "Name"
+	DirectoryFieldStudents = "Students"
+	DirectoryFieldAdmins   = "Admins"
+	DirectoryFieldTags     = "Tags"
+	DirectoryFieldCounter  = "Counter"
+	DirectoryFieldEnabled  = "Enabled"
+	DirectoryFieldMeta     = "Meta"
+)
+
+var _ encoding.GeneratedObject = (*Directory)(nil)
+
+// EncodeKV implements encoding.GeneratedObject.
+func (o *Directory) EncodeKV(prefix string, kvs map[string]string, omitted *[]string) error {
+	kvs[prefix+"Name"] = o.Name
+	for k, v := range o.Students {
+		ks := encoding.PadMapKey(strconv.FormatInt(int64(k), 10), 4)
+		if err := v.EncodeKV(prefix+"students/"+ks+"/", kvs, omitted); err != nil {
+			return err
+		}
+	}
+	for k, v := range o.Admins {
+		ks := strconv.FormatUint(uint64(k), 10)
+		if v == nil {
+			continue
+		}
+		if err := v.EncodeKV(prefix+"admins/"+ks+"/info/", kvs, omitted); err != nil {
+			return err
+		}
+	}
+	for k, v := range o.Tags {
+		ks := encoding.EscapeMapKey(k)
+		kvs[prefix+"tags/"+ks+"/value"] = v
+	}
+	kvs[prefix+"stats/counter"] = strconv.FormatUint(uint64(o.Counter), 10)
+	kvs[prefix+"Enabled"] = strconv.FormatBool(o.Enabled)
+	if encoding.IsEmptyValue(&o.Meta) {
+		*omitted = append(*omitted, prefix+"meta/")
+	} else {
+		if err := o.Meta.EncodeKV(prefix+"meta/", kvs, omitted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateKV implements encoding.GeneratedObject.
+func (o *Directory) UpdateKV(key string, value string) ([]interface{}, error) {
+	if key == "" {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if key == "Name" {
+		o.Name = value
+		return []interface{}{"Name"}, nil
+	}
+	if strings.HasPrefix(key, "Name/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "students/") {
+		rest := key[9:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		i, err := strconv.ParseInt(seg, 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		k := int(i)
+		if !hasSub {
+			return nil, encoding.ErrFindKeyInvalid
+		}
+		elemKey := sub
+		if o.Students == nil {
+			o.Students = make(map[int]Student)
+		}
+		e := o.Students[k]
+		fields, err := e.UpdateKV(elemKey, value)
+		if err != nil {
+			return nil, err
+		}
+		o.Students[k] = e
+		return append([]interface{}{"Students", k}, fields...), nil
+	}
+	if strings.HasPrefix("students/", key+"/") {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if strings.HasPrefix(key, "admins/") {
+		rest := key[7:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		i, err := strconv.ParseUint(seg, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		k := uint16(i)
+		if !hasSub || strings.HasPrefix("info/", sub+"/") {
+			return nil, encoding.ErrFindKeyInvalid
+		}
+		if !strings.HasPrefix(sub, "info/") {
+			return nil, encoding.ErrFindPathNotFound
+		}
+		elemKey := sub[5:]
+		if o.Admins == nil {
+			o.Admins = make(map[uint16]*Student)
+		}
+		e := o.Admins[k]
+		if e == nil {
+			e = new(Student)
+		}
+		fields, err := e.UpdateKV(elemKey, value)
+		if err != nil {
+			return nil, err
+		}
+		o.Admins[k] = e
+		return append([]interface{}{"Admins", k}, fields...), nil
+	}
+	if strings.HasPrefix("admins/", key+"/") {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if strings.HasPrefix(key, "tags/") {
+		rest := key[5:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		k := encoding.UnescapeMapKey(seg)
+		if sub != "value" {
+			if !hasSub || strings.HasPrefix("value/", sub+"/") {
+				return nil, encoding.ErrFindKeyInvalid
+			}
+			if strings.HasPrefix(sub, "value/") {
+				return nil, encoding.ErrFindPathPastObject
+			}
+			return nil, encoding.ErrFindPathNotFound
+		}
+		if o.Tags == nil {
+			o.Tags = make(map[string]string)
+		}
+		var e string
+		e = value
+		o.Tags[k] = e
+		return []interface{}{"Tags", k}, nil
+	}
+	if strings.HasPrefix("tags/", key+"/") {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if key == "stats/counter" {
+		o.Counter = uint32(encoding.ParseUint(value, 32))
+		return []interface{}{"Counter"}, nil
+	}
+	if strings.HasPrefix(key, "stats/counter/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix("stats/counter/", key+"/") {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if key == "Enabled" {
+		o.Enabled = encoding.ParseBool(value)
+		return []interface{}{"Enabled"}, nil
+	}
+	if strings.HasPrefix(key, "Enabled/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "meta/") {
+		fields, err := o.Meta.UpdateKV(key[5:], value)
+		if err != nil {
+			return nil, err
+		}
+		return append([]interface{}{"Meta"}, fields...), nil
+	}
+	if strings.HasPrefix("meta/", key+"/") {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// DeleteKV implements encoding.GeneratedObject.
+func (o *Directory) DeleteKV(key string) ([]interface{}, error) {
+	if key == "" {
+		*o = Directory{}
+		return nil, nil
+	}
+	if key == "Name" || strings.HasPrefix("Name/", key+"/") {
+		o.Name = ""
+		return []interface{}{"Name"}, nil
+	}
+	if strings.HasPrefix(key, "Name/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "students/") {
+		rest := key[9:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		i, err := strconv.ParseInt(seg, 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		k := int(i)
+		if !hasSub {
+			if _, ok := o.Students[k]; !ok {
+				return nil, encoding.ErrFindObjectNotFound
+			}
+			delete(o.Students, k)
+			return []interface{}{"Students", k}, nil
+		}
+		elemKey := sub
+		e, ok := o.Students[k]
+		if !ok {
+			return nil, encoding.ErrFindObjectNotFound
+		}
+		fields, err := e.DeleteKV(elemKey)
+		if err != nil {
+			return nil, err
+		}
+		o.Students[k] = e
+		return append([]interface{}{"Students", k}, fields...), nil
+	}
+	if strings.HasPrefix("students/", key+"/") {
+		o.Students = nil
+		return []interface{}{"Students"}, nil
+	}
+	if strings.HasPrefix(key, "admins/") {
+		rest := key[7:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		i, err := strconv.ParseUint(seg, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		k := uint16(i)
+		if !hasSub || strings.HasPrefix("info/", sub+"/") {
+			if _, ok := o.Admins[k]; !ok {
+				return nil, encoding.ErrFindObjectNotFound
+			}
+			delete(o.Admins, k)
+			return []interface{}{"Admins", k}, nil
+		}
+		if !strings.HasPrefix(sub, "info/") {
+			return nil, encoding.ErrFindPathNotFound
+		}
+		elemKey := sub[5:]
+		e, ok := o.Admins[k]
+		if !ok {
+			return nil, encoding.ErrFindObjectNotFound
+		}
+		fields, err := e.DeleteKV(elemKey)
+		if err != nil {
+			return nil, err
+		}
+		return append([]interface{}{"Admins", k}, fields...), nil
+	}
+	if strings.HasPrefix("admins/", key+"/") {
+		o.Admins = nil
+		return []interface{}{"Admins"}, nil
+	}
+	if strings.HasPrefix(key, "tags/") {
+		rest := key[5:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		k := encoding.UnescapeMapKey(seg)
+		if hasSub && !strings.HasPrefix("value/", sub+"/") {
+			if strings.HasPrefix(sub, "value/") {
+				return nil, encoding.ErrFindPathPastObject
+			}
+			return nil, encoding.ErrFindPathNotFound
+		}
+		if _, ok := o.Tags[k]; !ok {
+			return nil, encoding.ErrFindObjectNotFound
+		}
+		delete(o.Tags, k)
+		return []interface{}{"Tags", k}, nil
+	}
+	if strings.HasPrefix("tags/", key+"/") {
+		o.Tags = nil
+		return []interface{}{"Tags"}, nil
+	}
+	if key == "stats/counter" || strings.HasPrefix("stats/counter/", key+"/") {
+		o.Counter = 0
+		return []interface{}{"Counter"}, nil
+	}
+	if strings.HasPrefix(key, "stats/counter/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if key == "Enabled" || strings.HasPrefix("Enabled/", key+"/") {
+		o.Enabled = false
+		return []interface{}{"Enabled"}, nil
+	}
+	if strings.HasPrefix(key, "Enabled/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "meta/") {
+		fields, err := o.Meta.DeleteKV(key[5:])
+		if err != nil {
+			return nil, err
+		}
+		return append([]interface{}{"Meta"}, fields...), nil
+	}
+	if strings.HasPrefix("meta/", key+"/") {
+		o.Meta = Meta{}
+		return []interface{}{"Meta"}, nil
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// LookupKV implements encoding.GeneratedObject.
+func (o *Directory) LookupKV(key string) (interface{}, []interface{}, error) {
+	if key == "" {
+		c := *o
+		return &c, nil, nil
+	}
+	if key == "Name" || strings.HasPrefix("Name/", key+"/") {
+		return o.Name, []interface{}{"Name"}, nil
+	}
+	if strings.HasPrefix(key, "Name/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "students/") {
+		rest := key[9:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		i, err := strconv.ParseInt(seg, 10, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		k := int(i)
+		if !hasSub {
+			e, ok := o.Students[k]
+			if !ok {
+				return nil, []interface{}{"Students", k}, nil
+			}
+			return e, []interface{}{"Students", k}, nil
+		}
+		elemKey := sub
+		e, ok := o.Students[k]
+		if !ok {
+			_, fields, err := new(Student).LookupKV(elemKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			return nil, append([]interface{}{"Students", k}, fields...), nil
+		}
+		v, fields, err := e.LookupKV(elemKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, append([]interface{}{"Students", k}, fields...), nil
+	}
+	if strings.HasPrefix("students/", key+"/") {
+		return o.Students, []interface{}{"Students"}, nil
+	}
+	if strings.HasPrefix(key, "admins/") {
+		rest := key[7:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		i, err := strconv.ParseUint(seg, 10, 16)
+		if err != nil {
+			return nil, nil, err
+		}
+		k := uint16(i)
+		if !hasSub || strings.HasPrefix("info/", sub+"/") {
+			e, ok := o.Admins[k]
+			if !ok {
+				return nil, []interface{}{"Admins", k}, nil
+			}
+			if e == nil {
+				return nil, []interface{}{"Admins", k}, nil
+			}
+			c := *e
+			return &c, []interface{}{"Admins", k}, nil
+		}
+		if !strings.HasPrefix(sub, "info/") {
+			return nil, nil, encoding.ErrFindPathNotFound
+		}
+		elemKey := sub[5:]
+		e := o.Admins[k]
+		if e == nil {
+			_, fields, err := new(Student).LookupKV(elemKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			return nil, append([]interface{}{"Admins", k}, fields...), nil
+		}
+		v, fields, err := e.LookupKV(elemKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, append([]interface{}{"Admins", k}, fields...), nil
+	}
+	if strings.HasPrefix("admins/", key+"/") {
+		return o.Admins, []interface{}{"Admins"}, nil
+	}
+	if strings.HasPrefix(key, "tags/") {
+		rest := key[5:]
+		seg, sub, hasSub := rest, "", false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, sub, hasSub = rest[:i], rest[i+1:], true
+		}
+		k := encoding.UnescapeMapKey(seg)
+		if hasSub && !strings.HasPrefix("value/", sub+"/") {
+			if strings.HasPrefix(sub, "value/") {
+				return nil, nil, encoding.ErrFindPathPastObject
+			}
+			return nil, nil, encoding.ErrFindPathNotFound
+		}
+		e, ok := o.Tags[k]
+		if !ok {
+			return nil, []interface{}{"Tags", k}, nil
+		}
+		return e, []interface{}{"Tags", k}, nil
+	}
+	if strings.HasPrefix("tags/", key+"/") {
+		return o.Tags, []interface{}{"Tags"}, nil
+	}
+	if key == "stats/counter" || strings.HasPrefix("stats/counter/", key+"/") {
+		return o.Counter, []interface{}{"Counter"}, nil
+	}
+	if strings.HasPrefix(key, "stats/counter/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	if key == "Enabled" || strings.HasPrefix("Enabled/", key+"/") {
+		return o.Enabled, []interface{}{"Enabled"}, nil
+	}
+	if strings.HasPrefix(key, "Enabled/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "meta/") {
+		v, fields, err := o.Meta.LookupKV(key[5:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, append([]interface{}{"Meta"}, fields...), nil
+	}
+	if strings.HasPrefix("meta/", key+"/") {
+		return o.Meta, []interface{}{"Meta"}, nil
+	}
+	return nil, nil, encoding.ErrFindPathNotFound
+}
+
+// Names of the stored fields of Student, for SyncEvent navigation.
+const (
+	StudentFieldName    = "Name"
+	StudentFieldAge     = "Age"
+	StudentFieldAddress = "Address"
+	StudentFieldGrades  = "Grades"
+	StudentFieldIP      = "IP"
+)
+
+var _ encoding.GeneratedObject = (*Student)(nil)
+
+// EncodeKV implements encoding.GeneratedObject.
+func (o *Student) EncodeKV(prefix string, kvs map[string]string, omitted *[]string) error {
+	kvs[prefix+"Name"] = o.Name
+	if o.Age == 0 {
+		*omitted = append(*omitted, prefix+"age")
+	} else {
+		kvs[prefix+"age"] = strconv.FormatInt(int64(o.Age), 10)
+	}
+	if o.Address != nil {
+		if err := o.Address.EncodeKV(prefix+"address/", kvs, omitted); err != nil {
+			return err
+		}
+	}
+	for k, v := range o.Grades {
+		ks := encoding.EscapeMapKey(k)
+		if s, err := encoding.MarshalValue(&v); err != nil {
+			return err
+		} else {
+			kvs[prefix+"grades/"+ks] = s
+		}
+	}
+	if encoding.IsEmptyValue(&o.IP) {
+		*omitted = append(*omitted, prefix+"ip")
+	} else {
+		if s, err := encoding.MarshalValue(&o.IP); err != nil {
+			return err
+		} else {
+			kvs[prefix+"ip"] = s
+		}
+	}
+	return nil
+}
+
+// UpdateKV implements encoding.GeneratedObject.
+func (o *Student) UpdateKV(key string, value string) ([]interface{}, error) {
+	if key == "" {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if key == "Name" {
+		o.Name = value
+		return []interface{}{"Name"}, nil
+	}
+	if strings.HasPrefix(key, "Name/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if key == "age" {
+		o.Age = int(encoding.ParseInt(value, 0))
+		return []interface{}{"Age"}, nil
+	}
+	if strings.HasPrefix(key, "age/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "address/") {
+		if o.Address == nil {
+			o.Address = new(Address)
+		}
+		fields, err := o.Address.UpdateKV(key[8:], value)
+		if err != nil {
+			return nil, err
+		}
+		return append([]interface{}{"Address"}, fields...), nil
+	}
+	if strings.HasPrefix("address/", key+"/") {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if strings.HasPrefix(key, "grades/") {
+		rest := key[7:]
+		seg, hasSub := rest, false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, hasSub = rest[:i], true
+		}
+		k := encoding.UnescapeMapKey(seg)
+		if hasSub {
+			return nil, encoding.ErrFindPathPastObject
+		}
+		if o.Grades == nil {
+			o.Grades = make(map[string]float64)
+		}
+		var e float64
+		_ = encoding.UnmarshalValue(value, &e)
+		o.Grades[k] = e
+		return []interface{}{"Grades", k}, nil
+	}
+	if strings.HasPrefix("grades/", key+"/") {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if key == "ip" {
+		_ = encoding.UnmarshalValue(value, &o.IP)
+		return []interface{}{"IP"}, nil
+	}
+	if strings.HasPrefix(key, "ip/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// DeleteKV implements encoding.GeneratedObject.
+func (o *Student) DeleteKV(key string) ([]interface{}, error) {
+	if key == "" {
+		*o = Student{}
+		return nil, nil
+	}
+	if key == "Name" || strings.HasPrefix("Name/", key+"/") {
+		o.Name = ""
+		return []interface{}{"Name"}, nil
+	}
+	if strings.HasPrefix(key, "Name/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if key == "age" || strings.HasPrefix("age/", key+"/") {
+		o.Age = 0
+		return []interface{}{"Age"}, nil
+	}
+	if strings.HasPrefix(key, "age/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "address/") {
+		if o.Address == nil {
+			return nil, encoding.ErrFindObjectNotFound
+		}
+		fields, err := o.Address.DeleteKV(key[8:])
+		if err != nil {
+			return nil, err
+		}
+		return append([]interface{}{"Address"}, fields...), nil
+	}
+	if strings.HasPrefix("address/", key+"/") {
+		o.Address = nil
+		return []interface{}{"Address"}, nil
+	}
+	if strings.HasPrefix(key, "grades/") {
+		rest := key[7:]
+		seg, hasSub := rest, false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, hasSub = rest[:i], true
+		}
+		k := encoding.UnescapeMapKey(seg)
+		if hasSub {
+			return nil, encoding.ErrFindPathPastObject
+		}
+		if _, ok := o.Grades[k]; !ok {
+			return nil, encoding.ErrFindObjectNotFound
+		}
+		delete(o.Grades, k)
+		return []interface{}{"Grades", k}, nil
+	}
+	if strings.HasPrefix("grades/", key+"/") {
+		o.Grades = nil
+		return []interface{}{"Grades"}, nil
+	}
+	if key == "ip" || strings.HasPrefix("ip/", key+"/") {
+		o.IP = *new(net.IP)
+		return []interface{}{"IP"}, nil
+	}
+	if strings.HasPrefix(key, "ip/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// LookupKV implements encoding.GeneratedObject.
+func (o *Student) LookupKV(key string) (interface{}, []interface{}, error) {
+	if key == "" {
+		c := *o
+		return &c, nil, nil
+	}
+	if key == "Name" || strings.HasPrefix("Name/", key+"/") {
+		return o.Name, []interface{}{"Name"}, nil
+	}
+	if strings.HasPrefix(key, "Name/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	if key == "age" || strings.HasPrefix("age/", key+"/") {
+		return o.Age, []interface{}{"Age"}, nil
+	}
+	if strings.HasPrefix(key, "age/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	if strings.HasPrefix(key, "address/") {
+		if o.Address == nil {
+			_, fields, err := new(Address).LookupKV(key[8:])
+			if err != nil {
+				return nil, nil, err
+			}
+			return nil, append([]interface{}{"Address"}, fields...), nil
+		}
+		v, fields, err := o.Address.LookupKV(key[8:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return v, append([]interface{}{"Address"}, fields...), nil
+	}
+	if strings.HasPrefix("address/", key+"/") {
+		if o.Address == nil {
+			return nil, []interface{}{"Address"}, nil
+		}
+		c := *o.Address
+		return &c, []interface{}{"Address"}, nil
+	}
+	if strings.HasPrefix(key, "grades/") {
+		rest := key[7:]
+		seg, hasSub := rest, false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			seg, hasSub = rest[:i], true
+		}
+		k := encoding.UnescapeMapKey(seg)
+		if hasSub {
+			return nil, nil, encoding.ErrFindPathPastObject
+		}
+		e, ok := o.Grades[k]
+		if !ok {
+			return nil, []interface{}{"Grades", k}, nil
+		}
+		return e, []interface{}{"Grades", k}, nil
+	}
+	if strings.HasPrefix("grades/", key+"/") {
+		return o.Grades, []interface{}{"Grades"}, nil
+	}
+	if key == "ip" || strings.HasPrefix("ip/", key+"/") {
+		return o.IP, []interface{}{"IP"}, nil
+	}
+	if strings.HasPrefix(key, "ip/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	return nil, nil, encoding.ErrFindPathNotFound
+}
+
+// Names of the stored fields of Meta, for SyncEvent navigation.
+const (
+	MetaFieldVersion = "Version"
+	MetaFieldLabels  = "Labels"
+)
+
+var _ encoding.GeneratedObject = (*Meta)(nil)
+
+// EncodeKV implements encoding.GeneratedObject.
+func (o *Meta) EncodeKV(prefix string, kvs map[string]string, omitted *[]string) error {
+	kvs[prefix+"Version"] = strconv.FormatInt(int64(o.Version), 10)
+	if s, err := encoding.MarshalValue(&o.Labels); err != nil {
+		return err
+	} else {
+		kvs[prefix+"labels"] = s
+	}
+	return nil
+}
+
+// UpdateKV implements encoding.GeneratedObject.
+func (o *Meta) UpdateKV(key string, value string) ([]interface{}, error) {
+	if key == "" {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if key == "Version" {
+		o.Version = int64(encoding.ParseInt(value, 64))
+		return []interface{}{"Version"}, nil
+	}
+	if strings.HasPrefix(key, "Version/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if key == "labels" {
+		_ = encoding.UnmarshalValue(value, &o.Labels)
+		return []interface{}{"Labels"}, nil
+	}
+	if strings.HasPrefix(key, "labels/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// DeleteKV implements encoding.GeneratedObject.
+func (o *Meta) DeleteKV(key string) ([]interface{}, error) {
+	if key == "" {
+		*o = Meta{}
+		return nil, nil
+	}
+	if key == "Version" || strings.HasPrefix("Version/", key+"/") {
+		o.Version = 0
+		return []interface{}{"Version"}, nil
+	}
+	if strings.HasPrefix(key, "Version/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if key == "labels" || strings.HasPrefix("labels/", key+"/") {
+		o.Labels = nil
+		return []interface{}{"Labels"}, nil
+	}
+	if strings.HasPrefix(key, "labels/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// LookupKV implements encoding.GeneratedObject.
+func (o *Meta) LookupKV(key string) (interface{}, []interface{}, error) {
+	if key == "" {
+		c := *o
+		return &c, nil, nil
+	}
+	if key == "Version" || strings.HasPrefix("Version/", key+"/") {
+		return o.Version, []interface{}{"Version"}, nil
+	}
+	if strings.HasPrefix(key, "Version/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	if key == "labels" || strings.HasPrefix("labels/", key+"/") {
+		return o.Labels, []interface{}{"Labels"}, nil
+	}
+	if strings.HasPrefix(key, "labels/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	return nil, nil, encoding.ErrFindPathNotFound
+}
+
+// Names of the stored fields of Address, for SyncEvent navigation.
+const (
+	AddressFieldStreet = "Street"
+	AddressFieldCity   = "City"
+)
+
+var _ encoding.GeneratedObject = (*Address)(nil)
+
+// EncodeKV implements encoding.GeneratedObject.
+func (o *Address) EncodeKV(prefix string, kvs map[string]string, omitted *[]string) error {
+	kvs[prefix+"Street"] = o.Street
+	kvs[prefix+"city"] = o.City
+	return nil
+}
+
+// UpdateKV implements encoding.GeneratedObject.
+func (o *Address) UpdateKV(key string, value string) ([]interface{}, error) {
+	if key == "" {
+		return nil, encoding.ErrFindKeyInvalid
+	}
+	if key == "Street" {
+		o.Street = value
+		return []interface{}{"Street"}, nil
+	}
+	if strings.HasPrefix(key, "Street/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if key == "city" {
+		o.City = value
+		return []interface{}{"City"}, nil
+	}
+	if strings.HasPrefix(key, "city/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// DeleteKV implements encoding.GeneratedObject.
+func (o *Address) DeleteKV(key string) ([]interface{}, error) {
+	if key == "" {
+		*o = Address{}
+		return nil, nil
+	}
+	if key == "Street" || strings.HasPrefix("Street/", key+"/") {
+		o.Street = ""
+		return []interface{}{"Street"}, nil
+	}
+	if strings.HasPrefix(key, "Street/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	if key == "city" || strings.HasPrefix("city/", key+"/") {
+		o.City = ""
+		return []interface{}{"City"}, nil
+	}
+	if strings.HasPrefix(key, "city/") {
+		return nil, encoding.ErrFindPathPastObject
+	}
+	return nil, encoding.ErrFindPathNotFound
+}
+
+// LookupKV implements encoding.GeneratedObject.
+func (o *Address) LookupKV(key string) (interface{}, []interface{}, error) {
+	if key == "" {
+		c := *o
+		return &c, nil, nil
+	}
+	if key == "Street" || strings.HasPrefix("Street/", key+"/") {
+		return o.Street, []interface{}{"Street"}, nil
+	}
+	if strings.HasPrefix(key, "Street/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	if key == "city" || strings.HasPrefix("city/", key+"/") {
+		return o.City, []interface{}{"City"}, nil
+	}
+	if strings.HasPrefix(key, "city/") {
+		return nil, nil, encoding.ErrFindPathPastObject
+	}
+	return nil, nil, encoding.ErrFindPathNotFound
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Example command checking the keys stored in etcd by the sync1 example.
+//
+//	fsck -endpoint http://localhost:2379/ -type data -format /db/stored/here/ -delete-orphans
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/Oryon/kvsync/fsck"
+	"github.com/Oryon/kvsync/kvs/etcd"
+)
+
+type Data struct {
+	Nodes    map[string]Node `kvs:"Nodes/{key}"`
+	Edges    map[string]Edge `kvs:"Edges/{key}/"`
+	QuitDemo bool
+}
+
+type Node struct {
+	ID          string
+	Name        string
+	Description string
+}
+
+type Edge struct {
+	NodeID1 string `kvs:"node_id1"`
+	NodeID2 string `kvs:"node_id2"`
+}
+
+func main() {
+	cmd := &fsck.Command{
+		Types: map[string]reflect.Type{
+			"data": reflect.TypeOf(Data{}),
+		},
+		Open: func(endpoint string) (fsck.Store, error) {
+			return etcd.CreateFromEndpoint(endpoint, "/")
+		},
+		DefaultEndpoint: "http://localhost:2379/",
+		Out:             os.Stdout,
+	}
+	err := cmd.Run(context.Background(), os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
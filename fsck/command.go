@@ -0,0 +1,121 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsck
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Oryon/kvsync/kvs"
+)
+
+var ErrInconsistent = errors.New("The key-value store is not consistent with the layout")
+
+// Store is a key-value store which keys can be listed and deleted.
+type Store interface {
+	kvs.Store
+	kvs.List
+}
+
+// Command implements a command line tool checking a key-value store.
+//
+// Since types are compiled in, programs provide the types which can be checked,
+// and the way to connect to the key-value store. See examples/fsck.
+type Command struct {
+	// Types which can be checked, by name (-type flag).
+	Types map[string]reflect.Type
+
+	// Connects to the key-value store (-endpoint flag).
+	Open func(endpoint string) (Store, error)
+
+	// Default value of the -endpoint flag.
+	DefaultEndpoint string
+
+	// Output of the command.
+	Out io.Writer
+}
+
+// Run parses the command line arguments (without the program name), checks the keys,
+// and prints the keys which are not valid followed by a summary.
+// ErrInconsistent is returned when keys which are not valid remain.
+func (cmd *Command) Run(c context.Context, args []string) error {
+	var names []string
+	for name := range cmd.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	flags.SetOutput(cmd.Out)
+	endpoint := flags.String("endpoint", cmd.DefaultEndpoint, "key-value store endpoint")
+	typeName := flags.String("type", "", "checked type ("+strings.Join(names, ", ")+")")
+	format := flags.String("format", "", "format the objects are stored with (e.g. '/db/')")
+	deleteOrphans := flags.Bool("delete-orphans", false, "delete orphan keys")
+	verbose := flags.Bool("v", false, "also print valid keys")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	t, ok := cmd.Types[*typeName]
+	if !ok {
+		return fmt.Errorf("Unknown type '%s', expected one of: %s", *typeName, strings.Join(names, ", "))
+	}
+	if *format == "" {
+		return fmt.Errorf("Missing -format")
+	}
+
+	s, err := cmd.Open(*endpoint)
+	if err != nil {
+		return err
+	}
+
+	results, err := Check(c, s, *format, t)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[Class]int)
+	for _, r := range results {
+		counts[r.Class]++
+		if r.Class != Valid {
+			fmt.Fprintf(cmd.Out, "%s\t%s\t%v\n", r.Class, r.Key, r.Err)
+		} else if *verbose {
+			fmt.Fprintf(cmd.Out, "%s\t%s\t%v\n", r.Class, r.Key, r.Fields)
+		}
+	}
+	fmt.Fprintf(cmd.Out, "%d keys: %d valid, %d orphan, %d unparsable, %d conflict\n",
+		len(results), counts[Valid], counts[Orphan], counts[Unparsable], counts[Conflict])
+
+	if *deleteOrphans && counts[Orphan] != 0 {
+		n, err := DeleteOrphans(c, s, results)
+		fmt.Fprintf(cmd.Out, "%d orphan keys deleted\n", n)
+		if err != nil {
+			return err
+		}
+		counts[Orphan] = 0
+	}
+
+	if counts[Orphan]+counts[Unparsable]+counts[Conflict] != 0 {
+		return ErrInconsistent
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Functions checking the consistency of a key-value store with the layout of a type.
+package fsck
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Oryon/kvsync/encoding"
+	"github.com/Oryon/kvsync/kvs"
+)
+
+// Class of a stored key.
+type Class int
+
+const (
+	// The key may have been stored by an object of the checked type.
+	Valid Class = iota
+
+	// No field of the checked type is stored at that key (e.g. renamed fields or invalid map keys).
+	Orphan
+
+	// A field is stored at that key, but the value can't be decoded.
+	Unparsable
+
+	// The key stores a value where the checked type stores other keys, or is below a key storing a value.
+	Conflict
+)
+
+func (c Class) String() string {
+	switch c {
+	case Valid:
+		return "valid"
+	case Orphan:
+		return "orphan"
+	case Unparsable:
+		return "unparsable"
+	case Conflict:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+// Result of checking a key.
+type Result struct {
+	Key   string
+	Value string
+	Class Class
+
+	// Field path of the object stored at the key, for valid and unparsable keys.
+	Fields []interface{}
+
+	// The reason why the key is not valid.
+	Err error
+}
+
+// Classify checks a key-value pair against the layout of type t stored with the given format
+// (a format string or an *encoding.Layout).
+func Classify(format interface{}, t reflect.Type, key string, value string) Result {
+	r := Result{
+		Key:   key,
+		Value: value,
+	}
+	r.Fields, r.Err = encoding.CheckKey(format, t, key, value)
+	var verr *encoding.ValueError
+	switch {
+	case r.Err == nil:
+		r.Class = Valid
+	case errors.Is(r.Err, encoding.ErrFindPathPastObject), errors.Is(r.Err, encoding.ErrFindKeyInvalid):
+		r.Class = Conflict
+	case errors.As(r.Err, &verr):
+		r.Class = Unparsable
+	default:
+		r.Class = Orphan
+	}
+	return r
+}
+
+// Check lists the keys stored below the path of objects of type t stored with the given format,
+// and classifies them. Results are sorted by key.
+func Check(c context.Context, l kvs.List, format interface{}, t reflect.Type) ([]Result, error) {
+	patterns, err := encoding.Schema(format, t)
+	if err != nil {
+		return nil, err
+	}
+	// Path of the object, without the trailing '/' of nodes
+	path := strings.TrimSuffix(patterns[0].Pattern, "/")
+
+	pairs, err := l.List(c, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for k := range pairs {
+		if k == path || strings.HasPrefix(k, path+"/") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	results := make([]Result, 0, len(keys))
+	for _, k := range keys {
+		results = append(results, Classify(format, t, k, pairs[k]))
+	}
+	return results, nil
+}
+
+// DeleteOrphans deletes the keys classified as orphans, and returns the number of deleted keys.
+func DeleteOrphans(c context.Context, s kvs.Store, results []Result) (int, error) {
+	n := 0
+	for _, r := range results {
+		if r.Class != Orphan {
+			continue
+		}
+		err := s.Delete(c, r.Key)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
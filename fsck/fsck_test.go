@@ -0,0 +1,207 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsck
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Oryon/kvsync/kvs/gomap"
+)
+
+type Node struct {
+	Name string
+}
+
+type Edge struct {
+	NodeID1 string `kvs:"node_id1"`
+	NodeID2 int    `kvs:"node_id2"`
+}
+
+type Data struct {
+	Nodes map[string]Node `kvs:"Nodes/{key}"`
+	Edges map[int]Edge    `kvs:"Edges/{key}/"`
+	Quit  bool
+}
+
+func testStore(t *testing.T) *gomap.Gomap {
+	m := gomap.Create()
+	c := context.Background()
+	for k, v := range map[string]string{
+		"/db/Nodes/a":             `{"Name":"a"}`,
+		"/db/Edges/1/node_id1":    "a",
+		"/db/Edges/1/node_id2":    "2",
+		"/db/Quit":                "true",
+		"/db/Old":                 "1",         // Orphan: unknown field
+		"/db/Edges/x/node_id1":    "a",         // Orphan: invalid map key
+		"/db/Edges/2/node_id2":    "two",       // Unparsable
+		"/db/Nodes/b":             "{",         // Unparsable
+		"/db/Nodes/c/Name":        "c",         // Conflict: below a blob
+		"/db/Edges/3":             `{"a":"b"}`, // Conflict: value where sub-keys are stored
+		"/dbx/Quit":               "true",      // Not below the prefix
+		"/other/Edges/1/node_id1": "a",         // Not below the prefix
+	} {
+		if err := m.Set(c, k, v); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	return m
+}
+
+func TestCheck(t *testing.T) {
+	m := testStore(t)
+	c := context.Background()
+
+	results, err := Check(c, m, "/db/", reflect.TypeOf(Data{}))
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	expected := []struct {
+		key    string
+		class  Class
+		fields []interface{}
+	}{
+		{"/db/Edges/1/node_id1", Valid, []interface{}{"Edges", 1, "NodeID1"}},
+		{"/db/Edges/1/node_id2", Valid, []interface{}{"Edges", 1, "NodeID2"}},
+		{"/db/Edges/2/node_id2", Unparsable, []interface{}{"Edges", 2, "NodeID2"}},
+		{"/db/Edges/3", Conflict, nil},
+		{"/db/Edges/x/node_id1", Orphan, nil},
+		{"/db/Nodes/a", Valid, []interface{}{"Nodes", "a"}},
+		{"/db/Nodes/b", Unparsable, []interface{}{"Nodes", "b"}},
+		{"/db/Nodes/c/Name", Conflict, nil},
+		{"/db/Old", Orphan, nil},
+		{"/db/Quit", Valid, []interface{}{"Quit"}},
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("Got %d results, expected %d: %v", len(results), len(expected), results)
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Key != e.key || r.Class != e.class {
+			t.Errorf("Result %d: got %s %s (%v), expected %s %s", i, r.Class, r.Key, r.Err, e.class, e.key)
+			continue
+		}
+		if (r.Err == nil) != (e.class == Valid) {
+			t.Errorf("%s: unexpected error %v", r.Key, r.Err)
+		}
+		if e.fields != nil && !reflect.DeepEqual(r.Fields, e.fields) {
+			t.Errorf("%s: got fields %v, expected %v", r.Key, r.Fields, e.fields)
+		}
+	}
+
+	_, err = Check(c, m, "/db/{key}", reflect.TypeOf(Data{}))
+	if err == nil {
+		t.Errorf("Check should fail with an invalid format")
+	}
+}
+
+func TestDeleteOrphans(t *testing.T) {
+	m := testStore(t)
+	c := context.Background()
+
+	results, err := Check(c, m, "/db/", reflect.TypeOf(Data{}))
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	n, err := DeleteOrphans(c, m, results)
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteOrphans returned %d, %v", n, err)
+	}
+
+	backing := m.GetBackingMap()
+	for _, k := range []string{"/db/Old", "/db/Edges/x/node_id1"} {
+		if _, ok := backing[k]; ok {
+			t.Errorf("Orphan %s was not deleted", k)
+		}
+	}
+	for _, k := range []string{"/db/Quit", "/db/Nodes/b", "/db/Edges/3", "/dbx/Quit"} {
+		if _, ok := backing[k]; !ok {
+			t.Errorf("Key %s was deleted", k)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	m := testStore(t)
+	c := context.Background()
+	out := &bytes.Buffer{}
+	endpoint := ""
+	cmd := &Command{
+		Types: map[string]reflect.Type{
+			"data": reflect.TypeOf(Data{}),
+		},
+		Open: func(e string) (Store, error) {
+			endpoint = e
+			return m, nil
+		},
+		DefaultEndpoint: "default",
+		Out:             out,
+	}
+
+	err := cmd.Run(c, []string{"-type", "data", "-format", "/db/"})
+	if err != ErrInconsistent {
+		t.Fatalf("Run returned %v", err)
+	}
+	if endpoint != "default" {
+		t.Errorf("Opened endpoint '%s'", endpoint)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "unparsable\t/db/Edges/2/node_id2\t") {
+		t.Errorf("Unexpected first line '%s'", lines[0])
+	}
+	if lines[6] != "10 keys: 4 valid, 2 orphan, 2 unparsable, 2 conflict" {
+		t.Errorf("Unexpected summary '%s'", lines[6])
+	}
+
+	// Remove other problems, such that only orphans remain
+	for _, k := range []string{"/db/Edges/2/node_id2", "/db/Nodes/b", "/db/Nodes/c/Name", "/db/Edges/3"} {
+		m.Delete(c, k)
+	}
+	out.Reset()
+	err = cmd.Run(c, []string{"-endpoint", "e", "-type", "data", "-format", "/db/", "-delete-orphans"})
+	if err != nil {
+		t.Fatalf("Run returned %v:\n%s", err, out.String())
+	}
+	if endpoint != "e" {
+		t.Errorf("Opened endpoint '%s'", endpoint)
+	}
+	if !strings.Contains(out.String(), "2 orphan keys deleted\n") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	err = cmd.Run(c, []string{"-type", "data", "-format", "/db/", "-v"})
+	if err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+	if !strings.Contains(out.String(), "valid\t/db/Quit\t[Quit]\n") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+
+	if cmd.Run(c, []string{"-type", "unknown", "-format", "/db/"}) == nil {
+		t.Errorf("Run should fail with an unknown type")
+	}
+	if cmd.Run(c, []string{"-type", "data"}) == nil {
+		t.Errorf("Run should fail without format")
+	}
+}
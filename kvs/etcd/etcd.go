@@ -17,6 +17,8 @@ package etcd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/Oryon/kvsync/kvs"
 	"go.etcd.io/etcd/client"
 	"sync"
@@ -28,6 +30,7 @@ type Etcd struct {
 	kapi          client.KeysAPI
 	listing       []*client.Node
 	lastEtcdIndex uint64
+	listed        bool
 	watcher       client.Watcher
 	err           error
 	mux           sync.Mutex
@@ -83,6 +86,9 @@ func (etcd *Etcd) Set(c context.Context, key string, value string) error {
 
 func (etcd *Etcd) Delete(c context.Context, key string) error {
 	_, err := etcd.kapi.Delete(c, key, &client.DeleteOptions{Recursive: true})
+	if e, ok := err.(client.Error); ok && e.Code == client.ErrorCodeKeyNotFound {
+		return fmt.Errorf("%w: '%s'", kvs.ErrNoSuchKey, key)
+	}
 	return err
 }
 
@@ -97,6 +103,31 @@ func (etcd *Etcd) Get(c context.Context, key string) (string, error) {
 	return r.Node.Value, nil
 }
 
+// Lists the keys below a directory (when the prefix finishes with '/'), or a single key.
+// Other prefixes are not supported by etcd v2 API.
+func (etcd *Etcd) List(c context.Context, prefix string) (map[string]string, error) {
+	l := make(map[string]string)
+	r, err := etcd.kapi.Get(c, prefix, &client.GetOptions{Recursive: true})
+	if err != nil {
+		if e, ok := err.(client.Error); ok && e.Code == client.ErrorCodeKeyNotFound {
+			return l, nil
+		}
+		return nil, err
+	}
+
+	nodes := []*client.Node{r.Node}
+	for len(nodes) != 0 {
+		n := nodes[0]
+		nodes = nodes[1:]
+		if n.Dir {
+			nodes = append(nodes, n.Nodes...)
+		} else {
+			l[n.Key] = n.Value
+		}
+	}
+	return l, nil
+}
+
 func (etcd *Etcd) Next(c context.Context) (*kvs.Update, error) {
 	if etcd.err != nil {
 		// We had an error, just return it
@@ -104,26 +135,29 @@ func (etcd *Etcd) Next(c context.Context) (*kvs.Update, error) {
 	}
 
 	if etcd.watcher == nil {
-		l, err := etcd.kapi.Get(c, etcd.directory, &client.GetOptions{Recursive: true})
-		if err != nil {
-			e := err.(client.Error)
-			if e.Code != client.ErrorCodeKeyNotFound {
-				etcd.err = err
-				return nil, err
-			}
-
-			// In case etcd.directory, we still need to retrieve an index
-			l, err := etcd.kapi.Get(c, "/", nil)
+		// Failures happening before the listing are not sticky, such that Next may be called again
+		if !etcd.listed {
+			l, err := etcd.kapi.Get(c, etcd.directory, &client.GetOptions{Recursive: true})
 			if err != nil {
-				etcd.err = err
-				return nil, err
+				e, ok := err.(client.Error)
+				if !ok || e.Code != client.ErrorCodeKeyNotFound {
+					return nil, err
+				}
+
+				// In case etcd.directory, we still need to retrieve an index
+				l, err := etcd.kapi.Get(c, "/", nil)
+				if err != nil {
+					return nil, err
+				}
+				etcd.lastEtcdIndex = l.Index
+			} else {
+				etcd.listing = append(etcd.listing, l.Node)
+				etcd.lastEtcdIndex = l.Index
 			}
-			etcd.lastEtcdIndex = l.Index
-		} else {
-			etcd.listing = append(etcd.listing, l.Node)
-			etcd.lastEtcdIndex = l.Index
+			etcd.listed = true
 		}
 
+		// Watching resumes after the last returned event
 		etcd.watcher = etcd.kapi.Watcher(etcd.directory, &client.WatcherOptions{Recursive: true, AfterIndex: etcd.lastEtcdIndex})
 	}
 
@@ -142,10 +176,14 @@ func (etcd *Etcd) Next(c context.Context) (*kvs.Update, error) {
 
 	r, err := etcd.watcher.Next(c)
 	if err != nil {
-		etcd.err = err
 		etcd.watcher = nil
+		if e, ok := err.(client.Error); ok && e.Code == client.ErrorCodeEventIndexCleared {
+			// Events were missed, which can't be recovered
+			etcd.err = err
+		}
 		return nil, err
 	}
+	etcd.lastEtcdIndex = r.Node.ModifiedIndex
 
 	var prev *string = nil
 	if r.PrevNode != nil {
@@ -160,3 +198,20 @@ func (etcd *Etcd) Next(c context.Context) (*kvs.Update, error) {
 	e := &kvs.Update{Key: r.Node.Key, Value: new, Previous: prev}
 	return e, nil
 }
+
+// Returns whether an error returned by etcd is transient, such that the operation may be retried
+// (e.g. timeouts, unavailable cluster or leader election). See retry.Policy.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		// Requests time out after HeaderTimeoutPerRequest
+		return true
+	}
+	switch e := err.(type) {
+	case *client.ClusterError:
+		return true
+	case client.Error:
+		return e.Code == client.ErrorCodeRaftInternal || e.Code == client.ErrorCodeLeaderElect ||
+			e.Code == client.ErrorCodeWatcherCleared
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Generic kvs interface implementation injecting faults into another implementation, for testing.
+package faulty
+
+import (
+	"context"
+	"errors"
+	"github.com/Oryon/kvsync/kvs"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInjected = errors.New("Injected failure")
+var ErrDisconnected = errors.New("Disconnected from the key-value store")
+var ErrNotImplemented = errors.New("Not implemented by the key-value store")
+
+// Operations in which faults are injected.
+type Op int
+
+const (
+	OpSet Op = iota
+	OpDelete
+	OpGet
+	OpNext
+	OpTxn
+	OpList
+)
+
+// Faults describes the faults to inject.
+// All random decisions are taken from a generator initialized with Seed,
+// such that a sequence of operations always sees the same faults.
+type Faults struct {
+	Seed int64
+
+	// Probability that an operation fails with ErrInjected, per operation.
+	// Failed operations are not applied.
+	ErrorRates map[Op]float64
+
+	// Calls which fail with ErrInjected, per operation, counted from 1
+	// (e.g. {OpSet: {1, 3}} fails the first and third calls to Set).
+	Schedule map[Op][]int
+
+	// Maximum latency added to every operation. The actual latency is random.
+	Latency time.Duration
+
+	// Probability that an update returned by Next is returned again by a later call.
+	DuplicateRate float64
+
+	// Probability that Next waits for one more update before returning one of them.
+	// Updates of a same key (or of keys within a deleted directory) are never reordered.
+	// Waiting for more updates blocks Next until they arrive, or until its context expires.
+	ReorderRate float64
+
+	// Probability that an operation disconnects the key-value store, and
+	// number of operations which then fail with ErrDisconnected.
+	DisconnectRate   float64
+	DisconnectLength int
+}
+
+// Clock provides the injected latency, such that tests can control it.
+type Clock interface {
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Faulty implements kvs.Store, kvs.Get, kvs.Sync and kvs.List by calling a key-value store,
+// and injecting faults. Operations not implemented by the key-value store return ErrNotImplemented.
+type Faulty struct {
+	// The clock used to add latency. When not set, the actual time is used.
+	Clock Clock
+
+	store  kvs.Store
+	faults Faults
+
+	mutex        sync.Mutex
+	rand         *rand.Rand
+	calls        map[Op]int
+	disconnected int
+	pending      []kvs.Update
+}
+
+func Create(store kvs.Store, faults Faults) *Faulty {
+	return &Faulty{
+		store:  store,
+		faults: faults,
+		rand:   rand.New(rand.NewSource(faults.Seed)),
+		calls:  make(map[Op]int),
+	}
+}
+
+// TxnFaulty also implements kvs.Txn.
+type TxnFaulty struct {
+	*Faulty
+}
+
+type txnStore interface {
+	kvs.Store
+	kvs.Txn
+}
+
+func CreateTxn(store txnStore, faults Faults) *TxnFaulty {
+	return &TxnFaulty{Faulty: Create(store, faults)}
+}
+
+// Disconnects the key-value store: all operations fail with ErrDisconnected until Reconnect is called.
+func (f *Faulty) Disconnect() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.disconnected = -1
+}
+
+func (f *Faulty) Reconnect() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.disconnected = 0
+}
+
+// Returns the number of calls to an operation.
+func (f *Faulty) Calls(op Op) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[op]
+}
+
+func (f *Faulty) chance(p float64) bool {
+	return p > 0 && f.rand.Float64() < p
+}
+
+// Counts a call to an operation, waits for the latency, and returns the injected error, if any.
+func (f *Faulty) inject(c context.Context, op Op) error {
+	f.mutex.Lock()
+	f.calls[op]++
+	n := f.calls[op]
+	var latency time.Duration
+	if f.faults.Latency > 0 {
+		latency = time.Duration(f.rand.Int63n(int64(f.faults.Latency) + 1))
+	}
+
+	var err error
+	if f.disconnected == 0 && f.chance(f.faults.DisconnectRate) {
+		f.disconnected = f.faults.DisconnectLength
+	}
+	if f.disconnected != 0 {
+		if f.disconnected > 0 {
+			f.disconnected--
+		}
+		err = ErrDisconnected
+	} else if f.chance(f.faults.ErrorRates[op]) {
+		err = ErrInjected
+	} else {
+		for _, i := range f.faults.Schedule[op] {
+			if i == n {
+				err = ErrInjected
+			}
+		}
+	}
+	f.mutex.Unlock()
+
+	if latency > 0 {
+		clock := f.Clock
+		if clock == nil {
+			clock = realClock{}
+		}
+		select {
+		case <-clock.After(latency):
+		case <-c.Done():
+			return c.Err()
+		}
+	}
+	return err
+}
+
+func (f *Faulty) Lock() {
+	f.store.Lock()
+}
+
+func (f *Faulty) Unlock() {
+	f.store.Unlock()
+}
+
+func (f *Faulty) Set(c context.Context, key string, value string) error {
+	err := f.inject(c, OpSet)
+	if err != nil {
+		return err
+	}
+	return f.store.Set(c, key, value)
+}
+
+func (f *Faulty) Delete(c context.Context, key string) error {
+	err := f.inject(c, OpDelete)
+	if err != nil {
+		return err
+	}
+	return f.store.Delete(c, key)
+}
+
+func (f *Faulty) Get(c context.Context, key string) (string, error) {
+	g, ok := f.store.(kvs.Get)
+	if !ok {
+		return "", ErrNotImplemented
+	}
+	err := f.inject(c, OpGet)
+	if err != nil {
+		return "", err
+	}
+	return g.Get(c, key)
+}
+
+func (f *Faulty) List(c context.Context, prefix string) (map[string]string, error) {
+	l, ok := f.store.(kvs.List)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	err := f.inject(c, OpList)
+	if err != nil {
+		return nil, err
+	}
+	return l.List(c, prefix)
+}
+
+func (f *TxnFaulty) Txn(c context.Context, ops []kvs.Op) error {
+	err := f.inject(c, OpTxn)
+	if err != nil {
+		return err
+	}
+	return f.store.(kvs.Txn).Txn(c, ops)
+}
+
+// Returns the next update, possibly duplicated or reordered.
+// Updates are not lost: those received before an injected error are returned by the following calls.
+func (f *Faulty) Next(c context.Context) (*kvs.Update, error) {
+	s, ok := f.store.(kvs.Sync)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	err := f.inject(c, OpNext)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	wait := len(f.pending) == 0 || f.chance(f.faults.ReorderRate)
+	f.mutex.Unlock()
+	for wait {
+		u, err := s.Next(c)
+		f.mutex.Lock()
+		if err != nil {
+			empty := len(f.pending) == 0
+			f.mutex.Unlock()
+			if empty {
+				return nil, err
+			}
+			// Return the updates which were already received
+			break
+		}
+		f.pending = append(f.pending, *u)
+		wait = f.chance(f.faults.ReorderRate)
+		f.mutex.Unlock()
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Pick one of the updates which does not follow an update of the same key
+	var candidates []int
+	for i, u := range f.pending {
+		first := true
+		for _, p := range f.pending[:i] {
+			if sameKeySpace(u.Key, p.Key) {
+				first = false
+				break
+			}
+		}
+		if first {
+			candidates = append(candidates, i)
+		}
+	}
+	i := candidates[f.rand.Intn(len(candidates))]
+	u := f.pending[i]
+	f.pending = append(f.pending[:i], f.pending[i+1:]...)
+
+	if f.chance(f.faults.DuplicateRate) {
+		f.pending = append([]kvs.Update{u}, f.pending...)
+	}
+	return &u, nil
+}
+
+// Returns whether two keys are equal, or one is a directory containing the other.
+func sameKeySpace(k1 string, k2 string) bool {
+	return k1 == k2 || (strings.HasSuffix(k1, "/") && strings.HasPrefix(k2, k1)) ||
+		(strings.HasSuffix(k2, "/") && strings.HasPrefix(k1, k2))
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package faulty
+
+import (
+	"context"
+	"fmt"
+	"github.com/Oryon/kvsync/kvs"
+	"github.com/Oryon/kvsync/kvs/gomap"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns immediately, recording the waited durations.
+type fakeClock struct {
+	sleeps []time.Duration
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.sleeps = append(f.sleeps, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+// Returns the errors of n calls to Set.
+func setErrors(f *Faulty, n int) []error {
+	var errs []error
+	for i := 0; i < n; i++ {
+		errs = append(errs, f.Set(context.Background(), fmt.Sprintf("k%d", i), "v"))
+	}
+	return errs
+}
+
+func TestErrors(t *testing.T) {
+	faults := Faults{
+		Seed:       1,
+		ErrorRates: map[Op]float64{OpSet: 0.5},
+	}
+
+	// The same seed injects the same errors
+	errs := setErrors(Create(gomap.Create(), faults), 20)
+	if !reflect.DeepEqual(errs, setErrors(Create(gomap.Create(), faults), 20)) {
+		t.Errorf("Errors are not deterministic")
+	}
+	failures := 0
+	for _, err := range errs {
+		if err == ErrInjected {
+			failures++
+		} else if err != nil {
+			t.Errorf("Set returned %v", err)
+		}
+	}
+	if failures == 0 || failures == 20 {
+		t.Errorf("%d failures out of 20", failures)
+	}
+
+	// Failed operations are not applied
+	gm := gomap.Create()
+	f := Create(gm, Faults{Schedule: map[Op][]int{OpSet: {2, 3}, OpGet: {1}}})
+	errs = setErrors(f, 4)
+	if !reflect.DeepEqual(errs, []error{nil, ErrInjected, ErrInjected, nil}) {
+		t.Errorf("Set returned %v", errs)
+	}
+	if !reflect.DeepEqual(gm.GetBackingMap(), map[string]string{"k0": "v", "k3": "v"}) {
+		t.Errorf("Wrong map %v", gm.GetBackingMap())
+	}
+	if _, err := f.Get(context.Background(), "k0"); err != ErrInjected {
+		t.Errorf("Get returned %v", err)
+	}
+	if v, err := f.Get(context.Background(), "k0"); err != nil || v != "v" {
+		t.Errorf("Get returned '%s' %v", v, err)
+	}
+	if f.Calls(OpSet) != 4 || f.Calls(OpGet) != 2 {
+		t.Errorf("Wrong calls %d %d", f.Calls(OpSet), f.Calls(OpGet))
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	f := Create(gomap.Create(), Faults{})
+	f.Disconnect()
+	if err := f.Delete(context.Background(), "a"); err != ErrDisconnected {
+		t.Errorf("Delete returned %v", err)
+	}
+	if _, err := f.Next(context.Background()); err != ErrDisconnected {
+		t.Errorf("Next returned %v", err)
+	}
+	f.Reconnect()
+	if err := f.Set(context.Background(), "a", "1"); err != nil {
+		t.Errorf("Set returned %v", err)
+	}
+
+	// Random disconnections last a given number of operations
+	f = Create(gomap.Create(), Faults{DisconnectRate: 1, DisconnectLength: 2})
+	errs := setErrors(f, 3)
+	if !reflect.DeepEqual(errs, []error{ErrDisconnected, ErrDisconnected, ErrDisconnected}) {
+		t.Errorf("Set returned %v", errs)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	clock := &fakeClock{}
+	f := Create(gomap.Create(), Faults{Seed: 1, Latency: time.Second})
+	f.Clock = clock
+	setErrors(f, 10)
+	if len(clock.sleeps) == 0 {
+		t.Errorf("No latency was added")
+	}
+	for _, d := range clock.sleeps {
+		if d > time.Second {
+			t.Errorf("Latency %v is too long", d)
+		}
+	}
+
+	// Waiting stops when the context expires
+	f.Clock = nil
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.faults.Latency = time.Hour
+	if err := f.Set(c, "a", "1"); err != context.Canceled {
+		t.Errorf("Set returned %v", err)
+	}
+}
+
+func TestNext(t *testing.T) {
+	gm := gomap.Create()
+	f := CreateTxn(gm, Faults{
+		Seed:          3,
+		ErrorRates:    map[Op]float64{OpNext: 0.3},
+		DuplicateRate: 0.3,
+		ReorderRate:   0.5,
+	})
+	values := []string{"1", "2", "3"}
+	var ops []kvs.Op
+	for i := 0; i < 10; i++ {
+		for j := range values {
+			ops = append(ops, kvs.Op{Key: fmt.Sprintf("k%d", i), Value: &values[j]})
+		}
+	}
+	ops = append(ops, kvs.Op{Key: "k0"})
+	err := f.Txn(context.Background(), ops)
+	if err != nil {
+		t.Fatalf("Txn returned %v", err)
+	}
+
+	// All updates are returned, in order for each key, until no more updates arrive
+	c, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	received := make(map[string][]string)
+	var errors, updates int
+	for {
+		u, err := f.Next(c)
+		if err == ErrInjected {
+			errors++
+			continue
+		} else if err != nil {
+			break
+		}
+		updates++
+		v := "-"
+		if u.Value != nil {
+			v = *u.Value
+		}
+		r := received[u.Key]
+		if len(r) == 0 || r[len(r)-1] != v {
+			received[u.Key] = append(r, v)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		expected := values
+		if i == 0 {
+			expected = append(values, "-")
+		}
+		key := fmt.Sprintf("k%d", i)
+		if !reflect.DeepEqual(received[key], expected) {
+			t.Errorf("Received %v for key %s", received[key], key)
+		}
+	}
+	if errors == 0 || updates <= len(ops) {
+		t.Errorf("%d errors and %d updates", errors, updates)
+	}
+}
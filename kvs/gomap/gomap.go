@@ -28,6 +28,9 @@ type Gomap struct {
 	mutex   sync.Mutex
 	channel chan int
 	queue   []kvs.Update
+
+	// Identifier of the last transaction
+	txn uint64
 }
 
 func CreateFromExistingMap(gomap map[string]string) *Gomap {
@@ -59,14 +62,62 @@ func (m *Gomap) Unlock() {
 }
 
 func (m *Gomap) Set(c context.Context, key string, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.set(key, value, 0)
+	m.notify()
+	return nil
+}
+
+func (m *Gomap) Delete(c context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.delete(key, 0)
+	if err != nil {
+		return err
+	}
+	m.notify()
+	return nil
+}
+
+// Applies all the operations while holding the lock, such that
+// no other change is interleaved.
+// The resulting updates share the same kvs.Update.Txn identifier.
+func (m *Gomap) Txn(c context.Context, ops []kvs.Op) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.txn++
+	for _, op := range ops {
+		if op.Value != nil {
+			m.set(op.Key, *op.Value, m.txn)
+		} else {
+			// Deleting a key which does not exist is not an error
+			m.delete(op.Key, m.txn)
+		}
+	}
+	m.notify()
+	return nil
+}
+
+// Wakes up Next.
+func (m *Gomap) notify() {
+	select {
+	case m.channel <- 2: // Put 2 in the channel unless it is full
+	default:
+	}
+}
+
+func (m *Gomap) set(key string, value string, txn uint64) {
 	u := kvs.Update{
 		Key:      key,
 		Value:    &value,
 		Previous: nil,
+		Txn:      txn,
 	}
 
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
 	s, ok := m.gomap[key]
 	if ok {
 		u.Previous = &s
@@ -75,21 +126,12 @@ func (m *Gomap) Set(c context.Context, key string, value string) error {
 	m.gomap[key] = value
 
 	m.queue = append(m.queue, u)
-
-	select {
-	case m.channel <- 2: // Put 2 in the channel unless it is full
-	default:
-	}
-	return nil
 }
 
-func (m *Gomap) Delete(c context.Context, key string) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
+func (m *Gomap) delete(key string, txn uint64) error {
 	found := false
 
-	if key[len(key)-1] == '/' {
+	if strings.HasSuffix(key, "/") {
 		var us []kvs.Update
 
 		for k, v := range m.gomap {
@@ -99,18 +141,20 @@ func (m *Gomap) Delete(c context.Context, key string) error {
 					Key:      k,
 					Value:    nil,
 					Previous: &s,
+					Txn:      txn,
 				}
 				us = append(us, u)
 				found = true
 			}
 		}
 		if !found {
-			return fmt.Errorf("Key '%s' is not in map", key)
+			return fmt.Errorf("%w: '%s'", kvs.ErrNoSuchKey, key)
 		}
 		u := kvs.Update{
 			Key:      key,
 			Value:    nil,
 			Previous: &key,
+			Txn:      txn,
 		}
 		m.queue = append(m.queue, u)
 
@@ -121,12 +165,13 @@ func (m *Gomap) Delete(c context.Context, key string) error {
 	} else {
 		s, ok := m.gomap[key]
 		if !ok {
-			return fmt.Errorf("Key '%s' is not in map", key)
+			return fmt.Errorf("%w: '%s'", kvs.ErrNoSuchKey, key)
 		}
 		u := kvs.Update{
 			Key:      key,
 			Value:    nil,
 			Previous: &s,
+			Txn:      txn,
 		}
 		delete(m.gomap, u.Key)
 		m.queue = append(m.queue, u)
@@ -154,8 +199,6 @@ func (m *Gomap) Next(c context.Context) (*kvs.Update, error) {
 			return nil, c.Err()
 		}
 	}
-
-	return nil, fmt.Errorf("Next not implemented")
 }
 
 func (m *Gomap) Get(c context.Context, key string) (string, error) {
@@ -166,6 +209,19 @@ func (m *Gomap) Get(c context.Context, key string) (string, error) {
 	return v, nil
 }
 
+func (m *Gomap) List(c context.Context, prefix string) (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l := make(map[string]string)
+	for k, v := range m.gomap {
+		if strings.HasPrefix(k, prefix) {
+			l[k] = v
+		}
+	}
+	return l, nil
+}
+
 func (m *Gomap) GetBackingMap() map[string]string {
 	return m.gomap
 }
@@ -17,6 +17,7 @@ package gomap
 import (
 	"context"
 	"github.com/Oryon/kvsync/kvs"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -44,6 +45,9 @@ func testNext(t *testing.T, sync kvs.Sync, updates []kvs.Update) {
 		}
 		testStringPointers(t, "value", r.Value, u.Value)
 		testStringPointers(t, "previous", r.Previous, u.Previous)
+		if r.Txn != u.Txn {
+			t.Errorf("Unexpected transaction %d instead of %d", r.Txn, u.Txn)
+		}
 	}
 }
 
@@ -93,8 +97,9 @@ func TestInit(t *testing.T) {
 		t.Error("Should have returned error")
 	}
 
-	c, _ := context.WithTimeout(context.Background(), time.Microsecond)
+	c, cancel := context.WithTimeout(context.Background(), time.Microsecond)
 	u, e := m.Next(c)
+	cancel()
 	if u != nil {
 		t.Error("Update should be nil")
 	}
@@ -142,3 +147,58 @@ func TestInit(t *testing.T) {
 	m.Delete(c, "b/")
 	testNext(t, m, expected)
 }
+
+func TestTxn(t *testing.T) {
+	m := Create()
+	v1 := "1"
+	v2 := "2"
+	m.Set(context.Background(), "a/1", v1)
+	testNext(t, m, []kvs.Update{{Key: "a/1", Value: &v1}})
+
+	e := m.Txn(context.Background(), []kvs.Op{
+		{Key: "a/2", Value: &v2},
+		{Key: "b", Value: nil},
+		{Key: "a/1", Value: nil},
+		{Key: "c/", Value: nil},
+	})
+	if e != nil {
+		t.Errorf("Txn returned error: %v", e)
+	}
+	if len(m.gomap) != 1 || m.gomap["a/2"] != "2" {
+		t.Errorf("Unexpected map %v", m.gomap)
+	}
+	testNext(t, m, []kvs.Update{
+		{Key: "a/2", Value: &v2, Txn: 1},
+		{Key: "a/1", Value: nil, Previous: &v1, Txn: 1},
+	})
+
+	// Each transaction has its own identifier
+	m.Txn(context.Background(), []kvs.Op{{Key: "a/1", Value: &v1}})
+	m.Set(context.Background(), "a/2", v1)
+	testNext(t, m, []kvs.Update{
+		{Key: "a/1", Value: &v1, Txn: 2},
+		{Key: "a/2", Value: &v1, Previous: &v2},
+	})
+}
+
+func TestList(t *testing.T) {
+	m := Create()
+	c := context.Background()
+	m.Set(c, "a/1", "1")
+	m.Set(c, "a/2", "2")
+	m.Set(c, "ab", "3")
+	m.Set(c, "b", "4")
+
+	l, e := m.List(c, "a/")
+	if e != nil || !reflect.DeepEqual(l, map[string]string{"a/1": "1", "a/2": "2"}) {
+		t.Errorf("Unexpected list %v (%v)", l, e)
+	}
+	l, e = m.List(c, "")
+	if e != nil || len(l) != 4 {
+		t.Errorf("Unexpected list %v (%v)", l, e)
+	}
+	l, e = m.List(c, "c")
+	if e != nil || len(l) != 0 {
+		t.Errorf("Unexpected list %v (%v)", l, e)
+	}
+}
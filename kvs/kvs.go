@@ -25,7 +25,8 @@ type Store interface {
 	// Sets a string value at a key position
 	Set(c context.Context, key string, value string) error
 
-	// Deletes a key, or a repertory if the key finishes with '/'
+	// Deletes a key, or a repertory if the key finishes with '/'.
+	// Deleting a key which does not exist returns an error wrapping ErrNoSuchKey.
 	Delete(c context.Context, key string) error
 	
 	// Lock the underlying object for write access
@@ -45,6 +46,10 @@ type Update struct {
 
 	// The previous value, or nil if the pair is being created.
 	Previous *string
+
+	// A non-zero identifier shared by all the updates applied by the same transaction,
+	// or 0 if the update was not applied by a transaction (or if the store does not tell).
+	Txn uint64
 }
 
 // This interface provides synchronization capability.
@@ -66,3 +71,26 @@ type Get interface {
 	// Other errors might be returned depending on the underlying storage.
 	Get(c context.Context, key string) (string, error)
 }
+
+// This struct contains an operation applied by a transaction.
+type Op struct {
+	// The key to set or delete.
+	// Deleting a key finishing with '/' deletes the whole repertory.
+	Key string
+
+	// The value to set, or nil if the key is being deleted.
+	Value *string
+}
+
+// This interface provides a way to apply multiple operations atomically.
+type Txn interface {
+	// Txn applies all the operations, in order, or none of them.
+	// Deleting a key which does not exist is not an error.
+	Txn(c context.Context, ops []Op) error
+}
+
+// This interface provides a way to list the stored keys.
+type List interface {
+	// List returns all the key-value pairs which keys start with the given prefix.
+	List(c context.Context, prefix string) (map[string]string, error)
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Recording of kvs update streams, and kvs.Sync implementation replaying them.
+//
+// Records are NDJSON files: each line is the JSON encoding of an Entry.
+package record
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Oryon/kvsync/kvs"
+	"io"
+	"sync"
+	"time"
+)
+
+// Entry is a recorded kvs.Update.
+type Entry struct {
+	Key      string    `json:"key"`
+	Value    *string   `json:"value"`
+	Previous *string   `json:"previous"`
+	Txn      uint64    `json:"txn,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Recorder implements kvs.Sync by calling another kvs.Sync,
+// and writes every returned update to a record.
+type Recorder struct {
+	// Returns the time of the updates. When not set, time.Now is used.
+	Now func() time.Time
+
+	sync  kvs.Sync
+	enc   *json.Encoder
+	mutex sync.Mutex
+	err   error
+}
+
+func CreateRecorder(s kvs.Sync, w io.Writer) *Recorder {
+	return &Recorder{
+		sync: s,
+		enc:  json.NewEncoder(w),
+	}
+}
+
+// Returns the next update, once written.
+// Failing to write an update does not prevent it from being returned, see Err.
+func (r *Recorder) Next(c context.Context) (*kvs.Update, error) {
+	u, err := r.sync.Next(c)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.Now
+	if now == nil {
+		now = time.Now
+	}
+	e := Entry{
+		Key:      u.Key,
+		Value:    u.Value,
+		Previous: u.Previous,
+		Txn:      u.Txn,
+		Time:     now(),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	err = r.enc.Encode(&e)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return u, nil
+}
+
+// Returns the first error which happened when writing the record, if any.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// Clock provides the time to wait between updates, such that tests can control it.
+type Clock interface {
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Replay implements kvs.Sync by returning the updates of a record.
+// Once all the updates were returned, Next returns io.EOF.
+type Replay struct {
+	// When set, Next waits between two updates as long as between their recording.
+	Timing bool
+
+	// The clock used to wait between updates. When not set, the actual time is used.
+	Clock Clock
+
+	scanner *bufio.Scanner
+	line    int
+	last    time.Time
+
+	// Entry read but not returned yet, because the context expired while waiting.
+	next *Entry
+}
+
+// Maximum length of a record line.
+var MaxLineSize = 16 * 1024 * 1024
+
+func CreateReplay(r io.Reader) *Replay {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxLineSize)
+	return &Replay{
+		scanner: scanner,
+	}
+}
+
+// Reads the next entry of the record.
+func (r *Replay) read() (*Entry, error) {
+	for {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		r.line++
+		if len(r.scanner.Bytes()) != 0 {
+			break
+		}
+	}
+
+	e := &Entry{}
+	err := json.Unmarshal(r.scanner.Bytes(), e)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid record at line %d: %w", r.line, err)
+	}
+	return e, nil
+}
+
+func (r *Replay) Next(c context.Context) (*kvs.Update, error) {
+	if r.next == nil {
+		e, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		r.next = e
+	}
+	e := r.next
+
+	if r.Timing && !r.last.IsZero() && e.Time.After(r.last) {
+		clock := r.Clock
+		if clock == nil {
+			clock = realClock{}
+		}
+		select {
+		case <-clock.After(e.Time.Sub(r.last)):
+		case <-c.Done():
+			return nil, c.Err()
+		}
+	}
+	r.last = e.Time
+	r.next = nil
+
+	return &kvs.Update{
+		Key:      e.Key,
+		Value:    e.Value,
+		Previous: e.Previous,
+		Txn:      e.Txn,
+	}, nil
+}
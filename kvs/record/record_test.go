@@ -0,0 +1,184 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Oryon/kvsync/kvs"
+	"github.com/Oryon/kvsync/kvs/gomap"
+	"github.com/Oryon/kvsync/store"
+	"github.com/Oryon/kvsync/sync"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns immediately, recording the waited durations.
+type fakeClock struct {
+	sleeps []time.Duration
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.sleeps = append(f.sleeps, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+// Returns a time starting at t0, increasing by a second at each call.
+func fakeNow() func() time.Time {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+const testRecord = `{"key":"a","value":"1","previous":null,"time":"2019-01-01T00:00:01Z"}
+{"key":"a","value":"2","previous":"1","time":"2019-01-01T00:00:02Z"}
+{"key":"b","value":"3","previous":null,"txn":1,"time":"2019-01-01T00:00:03Z"}
+{"key":"a","value":null,"previous":"2","txn":1,"time":"2019-01-01T00:00:04Z"}
+`
+
+func TestRecorder(t *testing.T) {
+	gm := gomap.Create()
+	c := context.Background()
+	gm.Set(c, "a", "1")
+	gm.Set(c, "a", "2")
+	v := "3"
+	gm.Txn(c, []kvs.Op{{Key: "b", Value: &v}, {Key: "a"}})
+
+	var b bytes.Buffer
+	r := CreateRecorder(gm, &b)
+	r.Now = fakeNow()
+	for i := 0; i < 4; i++ {
+		_, err := r.Next(c)
+		if err != nil {
+			t.Fatalf("Next returned %v", err)
+		}
+	}
+	if b.String() != testRecord || r.Err() != nil {
+		t.Errorf("Wrong record %s (%v)", b.String(), r.Err())
+	}
+}
+
+func TestReplay(t *testing.T) {
+	c := context.Background()
+	r := CreateReplay(strings.NewReader(testRecord + "\n"))
+	clock := &fakeClock{}
+	r.Clock = clock
+	r.Timing = true
+
+	var updates []kvs.Update
+	for {
+		u, err := r.Next(c)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next returned %v", err)
+		}
+		updates = append(updates, *u)
+	}
+	v := []string{"1", "2", "3"}
+	expected := []kvs.Update{
+		{Key: "a", Value: &v[0]},
+		{Key: "a", Value: &v[1], Previous: &v[0]},
+		{Key: "b", Value: &v[2], Txn: 1},
+		{Key: "a", Previous: &v[1], Txn: 1},
+	}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("Wrong updates %v", updates)
+	}
+	if !reflect.DeepEqual(clock.sleeps, []time.Duration{time.Second, time.Second, time.Second}) {
+		t.Errorf("Wrong timing %v", clock.sleeps)
+	}
+
+	// Updates are not lost when the context expires while waiting
+	r = CreateReplay(strings.NewReader(testRecord))
+	r.Timing = true
+	r.Next(c)
+	cc, cancel := context.WithCancel(c)
+	cancel()
+	if _, err := r.Next(cc); err != context.Canceled {
+		t.Errorf("Next returned %v", err)
+	}
+	r.Timing = false
+	if u, err := r.Next(c); err != nil || *u.Value != "2" {
+		t.Errorf("Next returned %v %v", u, err)
+	}
+
+	r = CreateReplay(strings.NewReader("{\"key\":\"a\"}\n{"))
+	r.Next(c)
+	var serr *json.SyntaxError
+	if _, err := r.Next(c); !errors.As(err, &serr) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Next returned %v", err)
+	}
+}
+
+type S1 struct {
+	A int
+	B map[string]int `kvs:"b/{key}"`
+}
+
+func TestReplaySync(t *testing.T) {
+	c := context.Background()
+	gm := gomap.Create()
+	w := S1{A: 1, B: map[string]int{"x": 1, "y": 2}}
+	store.Store(gm, c, &w, "/o/")
+	store.Delete(gm, c, &w, "/o/", "B", "x")
+	store.Move(gm, c, &w, "/o/", []interface{}{"B", "y"}, []interface{}{"B", "z"})
+
+	// Record the updates received by an object
+	var b bytes.Buffer
+	rec := CreateRecorder(gm, &b)
+	o1 := S1{}
+	s := sync.Sync{Sync: rec}
+	s.SyncObject(sync.SyncObject{Format: "/o/", Object: &o1, Callback: func(e *sync.SyncEvent) error { return nil }})
+	cc, cancel := context.WithTimeout(c, 50*time.Millisecond)
+	defer cancel()
+	for s.Next(cc) == nil {
+	}
+
+	// Replaying the updates reproduces the same object
+	o2 := S1{}
+	var moves [][]interface{}
+	s = sync.Sync{Sync: CreateReplay(&b)}
+	s.SyncObject(sync.SyncObject{Format: "/o/", Object: &o2, Callback: func(e *sync.SyncEvent) error {
+		var to []interface{}
+		e.MovedTo(&to)
+		if to != nil {
+			moves = append(moves, to)
+		}
+		return nil
+	}})
+	var err error
+	for err == nil {
+		err = s.Next(c)
+	}
+	if err != io.EOF {
+		t.Errorf("Next returned %v", err)
+	}
+	if !reflect.DeepEqual(o1, w) || !reflect.DeepEqual(o2, w) {
+		t.Errorf("Wrong objects %v %v (should be %v)", o1, o2, w)
+	}
+	if !reflect.DeepEqual(moves, [][]interface{}{{"B", "z"}}) {
+		t.Errorf("Wrong moves %v", moves)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Generic kvs interface implementation retrying the failed operations of another implementation.
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/Oryon/kvsync/kvs"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var ErrNotImplemented = errors.New("Not implemented by the key-value store")
+
+// Policy describes when and how often failed operations are retried.
+type Policy struct {
+	// Maximum number of attempts of an operation, or 0 to retry until the context expires.
+	MaxAttempts int
+
+	// Delay before the first retry.
+	InitialBackoff time.Duration
+
+	// Maximum delay between two attempts, or 0 for no maximum.
+	MaxBackoff time.Duration
+
+	// Factor applied to the delay after each retry. Values below 1 keep the delay constant.
+	Multiplier float64
+
+	// Fraction of the delay randomly added or removed (e.g. 0.2 for +/- 20%).
+	Jitter float64
+
+	// Returns whether a failed operation may be retried.
+	// When not set, all errors are retried except kvs.ErrNoSuchKey.
+	Retryable func(err error) bool
+}
+
+var DefaultPolicy = Policy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Returns the delay before the given retry (the first retry being 1),
+// given a random number in [0, 1).
+func (p Policy) backoff(retry int, random float64) time.Duration {
+	d := float64(p.InitialBackoff)
+	if p.Multiplier > 1 {
+		d *= math.Pow(p.Multiplier, float64(retry-1))
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d += d * p.Jitter * (2*random - 1)
+	return time.Duration(d)
+}
+
+func (p Policy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return !errors.Is(err, kvs.ErrNoSuchKey)
+}
+
+// Clock provides the time to wait between attempts, such that tests can control it.
+type Clock interface {
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Retry implements kvs.Store, kvs.Get, kvs.Sync and kvs.List by calling a key-value store,
+// and retrying the failed operations according to a Policy.
+// Operations not implemented by the key-value store return ErrNotImplemented.
+//
+// Waiting between attempts is interrupted when the context of the operation expires.
+type Retry struct {
+	Policy Policy
+
+	// The clock used to wait between attempts. When not set, the actual time is used.
+	Clock Clock
+
+	// Returns random numbers in [0, 1) used to apply the jitter. When not set, math/rand is used.
+	Random func() float64
+
+	store kvs.Store
+}
+
+func Create(store kvs.Store, policy Policy) *Retry {
+	return &Retry{
+		Policy: policy,
+		store:  store,
+	}
+}
+
+// TxnRetry also implements kvs.Txn, retrying failed transactions.
+type TxnRetry struct {
+	*Retry
+}
+
+type txnStore interface {
+	kvs.Store
+	kvs.Txn
+}
+
+func CreateTxn(store txnStore, policy Policy) *TxnRetry {
+	return &TxnRetry{Retry: Create(store, policy)}
+}
+
+// Calls op until it succeeds, returns an error which is not retryable,
+// the maximum number of attempts is reached or the context expires.
+func (r *Retry) do(c context.Context, op func() error) error {
+	clock := r.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	random := r.Random
+	if random == nil {
+		random = rand.Float64
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || c.Err() != nil || !r.Policy.retryable(err) ||
+			(r.Policy.MaxAttempts > 0 && attempt >= r.Policy.MaxAttempts) {
+			return err
+		}
+
+		select {
+		case <-clock.After(r.Policy.backoff(attempt, random())):
+		case <-c.Done():
+			return c.Err()
+		}
+	}
+}
+
+func (r *Retry) Lock() {
+	r.store.Lock()
+}
+
+func (r *Retry) Unlock() {
+	r.store.Unlock()
+}
+
+func (r *Retry) Set(c context.Context, key string, value string) error {
+	return r.do(c, func() error {
+		return r.store.Set(c, key, value)
+	})
+}
+
+// When an attempt deleted the key but still failed, the next attempt
+// returns an error wrapping kvs.ErrNoSuchKey.
+func (r *Retry) Delete(c context.Context, key string) error {
+	return r.do(c, func() error {
+		return r.store.Delete(c, key)
+	})
+}
+
+func (r *Retry) Get(c context.Context, key string) (string, error) {
+	g, ok := r.store.(kvs.Get)
+	if !ok {
+		return "", ErrNotImplemented
+	}
+
+	var value string
+	err := r.do(c, func() error {
+		var err error
+		value, err = g.Get(c, key)
+		return err
+	})
+	return value, err
+}
+
+func (r *Retry) Next(c context.Context) (*kvs.Update, error) {
+	s, ok := r.store.(kvs.Sync)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+
+	var u *kvs.Update
+	err := r.do(c, func() error {
+		var err error
+		u, err = s.Next(c)
+		return err
+	})
+	return u, err
+}
+
+func (r *Retry) List(c context.Context, prefix string) (map[string]string, error) {
+	l, ok := r.store.(kvs.List)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+
+	var pairs map[string]string
+	err := r.do(c, func() error {
+		var err error
+		pairs, err = l.List(c, prefix)
+		return err
+	})
+	return pairs, err
+}
+
+func (r *TxnRetry) Txn(c context.Context, ops []kvs.Op) error {
+	t := r.store.(kvs.Txn)
+	return r.do(c, func() error {
+		return t.Txn(c, ops)
+	})
+}
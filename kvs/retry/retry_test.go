@@ -0,0 +1,236 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/Oryon/kvsync/kvs"
+	"github.com/Oryon/kvsync/kvs/gomap"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("Transient failure")
+
+// Fails a given number of operations before calling the gomap.
+type flakyStore struct {
+	*gomap.Gomap
+	failures int
+	calls    int
+}
+
+func (f *flakyStore) fail() error {
+	f.calls++
+	if f.failures == 0 {
+		return nil
+	}
+	f.failures--
+	return errTransient
+}
+
+func (f *flakyStore) Set(c context.Context, key string, value string) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Gomap.Set(c, key, value)
+}
+
+func (f *flakyStore) Delete(c context.Context, key string) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Gomap.Delete(c, key)
+}
+
+func (f *flakyStore) Get(c context.Context, key string) (string, error) {
+	if err := f.fail(); err != nil {
+		return "", err
+	}
+	return f.Gomap.Get(c, key)
+}
+
+func (f *flakyStore) Next(c context.Context) (*kvs.Update, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.Gomap.Next(c)
+}
+
+func (f *flakyStore) Txn(c context.Context, ops []kvs.Op) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Gomap.Txn(c, ops)
+}
+
+// Returns immediately, recording the waited durations.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.sleeps = append(f.sleeps, d)
+	f.now = f.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- f.now
+	return ch
+}
+
+// Never returns, but cancels the context.
+type cancelClock struct {
+	cancel context.CancelFunc
+}
+
+func (f cancelClock) After(d time.Duration) <-chan time.Time {
+	f.cancel()
+	return make(chan time.Time)
+}
+
+func testRetry(f *flakyStore, policy Policy) (*TxnRetry, *fakeClock) {
+	clock := &fakeClock{}
+	r := CreateTxn(f, policy)
+	r.Clock = clock
+	r.Random = func() float64 { return 0.5 }
+	return r, clock
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+	for _, c := range []struct {
+		retry    int
+		random   float64
+		expected time.Duration
+	}{
+		{1, 0.5, 100 * time.Millisecond},
+		{2, 0.5, 200 * time.Millisecond},
+		{4, 0.5, 800 * time.Millisecond},
+		{5, 0.5, time.Second},
+		{10, 0.5, time.Second},
+		{1, 0, 50 * time.Millisecond},
+		{2, 0.75, 250 * time.Millisecond},
+	} {
+		d := p.backoff(c.retry, c.random)
+		if d != c.expected {
+			t.Errorf("Backoff of retry %d with %f is %v instead of %v", c.retry, c.random, d, c.expected)
+		}
+	}
+
+	p.Multiplier = 0
+	if d := p.backoff(3, 0.5); d != 100*time.Millisecond {
+		t.Errorf("Constant backoff is %v", d)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	f := &flakyStore{Gomap: gomap.Create(), failures: 2}
+	r, clock := testRetry(f, DefaultPolicy)
+
+	err := r.Set(context.Background(), "a", "1")
+	if err != nil {
+		t.Errorf("Set returned %v", err)
+	}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	if f.calls != 3 || !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Errorf("Set was called %d times, waiting %v", f.calls, clock.sleeps)
+	}
+
+	f.failures = 1
+	v, err := r.Get(context.Background(), "a")
+	if err != nil || v != "1" {
+		t.Errorf("Get returned '%s' %v", v, err)
+	}
+
+	f.failures = 1
+	u, err := r.Next(context.Background())
+	if err != nil || u.Key != "a" {
+		t.Errorf("Next returned %v %v", u, err)
+	}
+
+	f.failures = 1
+	v2 := "2"
+	err = r.Txn(context.Background(), []kvs.Op{{Key: "b", Value: &v2}})
+	if err != nil || f.GetBackingMap()["b"] != "2" {
+		t.Errorf("Txn returned %v", err)
+	}
+
+	// Errors which are not retryable are returned immediately
+	f.calls = 0
+	err = r.Delete(context.Background(), "c")
+	if !errors.Is(err, kvs.ErrNoSuchKey) || f.calls != 1 {
+		t.Errorf("Delete returned %v after %d calls", err, f.calls)
+	}
+	r.Policy.Retryable = func(err error) bool { return err != errTransient }
+	f.failures = 1
+	f.calls = 0
+	err = r.Delete(context.Background(), "a")
+	if err != errTransient || f.calls != 1 {
+		t.Errorf("Delete returned %v after %d calls", err, f.calls)
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	f := &flakyStore{Gomap: gomap.Create(), failures: 10}
+	r, clock := testRetry(f, Policy{MaxAttempts: 3, InitialBackoff: time.Second})
+
+	err := r.Set(context.Background(), "a", "1")
+	if err != errTransient || f.calls != 3 || len(clock.sleeps) != 2 {
+		t.Errorf("Set returned %v after %d calls", err, f.calls)
+	}
+
+	// Without maximum, operations are retried until they succeed
+	r.Policy.MaxAttempts = 0
+	f.calls = 0
+	err = r.Set(context.Background(), "a", "1")
+	if err != nil || f.calls != 8 {
+		t.Errorf("Set returned %v after %d calls", err, f.calls)
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	f := &flakyStore{Gomap: gomap.Create(), failures: 10}
+	r := Create(f, DefaultPolicy)
+
+	// Waiting is interrupted when the context expires
+	c, cancel := context.WithCancel(context.Background())
+	r.Clock = cancelClock{cancel: cancel}
+	err := r.Set(c, "a", "1")
+	if err != context.Canceled || f.calls != 1 {
+		t.Errorf("Set returned %v after %d calls", err, f.calls)
+	}
+
+	// Operations are not retried once the context expired
+	f.calls = 0
+	err = r.Set(c, "a", "1")
+	if err != errTransient || f.calls != 1 {
+		t.Errorf("Set returned %v after %d calls", err, f.calls)
+	}
+
+	_, err = r.List(context.Background(), "")
+	if err != nil {
+		t.Errorf("List returned %v", err)
+	}
+	_, err = Create(&struct{ kvs.Store }{f}, DefaultPolicy).Get(context.Background(), "a")
+	if err != ErrNotImplemented {
+		t.Errorf("Get returned %v", err)
+	}
+}
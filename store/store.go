@@ -20,49 +20,318 @@ import (
 	"errors"
 	"github.com/Oryon/kvsync/encoding"
 	"github.com/Oryon/kvsync/kvs"
+	"reflect"
+	"sort"
 )
 
 var ErrNotImplemented = errors.New("Not implemented")
 
-// Puts an object into the key-value store
-func Store(s kvs.Store, c context.Context, object interface{}, format string, fields ...interface{}) error {
-	m, err := encoding.Encode(format, object, fields...)
-	if err != nil {
-		return err
-	}
+// Maximum number of operations per transaction used by Store, when the
+// key-value store implements kvs.Txn (etcd default limit is 128).
+var MaxTxnOps = 128
+
+// Puts an object into the key-value store.
+// Keys of empty fields tagged with 'omitempty' are deleted.
+//
+// Keys are written in increasing order while the object is encoded. When the key-value store
+// implements kvs.Txn, keys are written by transactions of at most MaxTxnOps operations.
+// Each transaction is atomic, but the object as a whole is not stored atomically.
+//
+// In all store functions, the format is either a format string or an *encoding.Layout.
+func Store(s kvs.Store, c context.Context, object interface{}, format interface{}, fields ...interface{}) error {
+	return store(s, c, object, format, MaxTxnOps, fields...)
+}
 
-	for k, v := range m {
-		err = s.Set(c, k, v)
+// Puts an object into the key-value store, by transactions of at most maxOps
+// operations when the key-value store implements kvs.Txn, or by a single
+// transaction when maxOps is 0.
+func store(s kvs.Store, c context.Context, object interface{}, format interface{}, maxOps int, fields ...interface{}) error {
+	if t, ok := s.(kvs.Txn); ok {
+		w := &txnSink{
+			txn:    t,
+			c:      c,
+			maxOps: maxOps,
+		}
+		err := encoding.EncodeStream(format, object, w, fields...)
 		if err != nil {
 			return err
 		}
+		return w.commit()
 	}
-	return nil
+
+	return encoding.EncodeStream(format, object, &storeSink{s: s, c: c}, fields...)
+}
+
+// Sink writing keys one by one.
+type storeSink struct {
+	s kvs.Store
+	c context.Context
+}
+
+func (w *storeSink) Set(key string, value string) error {
+	return w.s.Set(w.c, key, value)
+}
+
+func (w *storeSink) Omit(key string) error {
+	return deleteKey(w.s, w.c, key)
+}
+
+// Deletes a key, which may not be stored.
+func deleteKey(s kvs.Store, c context.Context, key string) error {
+	err := s.Delete(c, key)
+	if errors.Is(err, kvs.ErrNoSuchKey) {
+		// Keys of empty sub-objects are usually not stored, so failing to find them is expected
+		return nil
+	}
+	return err
+}
+
+// Sink writing keys by transactions of at most maxOps operations,
+// or by a single transaction when maxOps is 0.
+type txnSink struct {
+	txn    kvs.Txn
+	c      context.Context
+	maxOps int
+	ops    []kvs.Op
+}
+
+func (w *txnSink) add(op kvs.Op) error {
+	w.ops = append(w.ops, op)
+	if w.maxOps == 0 || len(w.ops) < w.maxOps {
+		return nil
+	}
+	return w.commit()
 }
 
-// Set a value and store it into the KV store
-func Set(s kvs.Store, c context.Context, object interface{}, format string, value interface{}, fields ...interface{}) error {
+func (w *txnSink) commit() error {
+	if len(w.ops) == 0 {
+		return nil
+	}
+	err := w.txn.Txn(w.c, w.ops)
+	w.ops = w.ops[:0]
+	return err
+}
+
+func (w *txnSink) Set(key string, value string) error {
+	return w.add(kvs.Op{Key: key, Value: &value})
+}
+
+func (w *txnSink) Omit(key string) error {
+	return w.add(kvs.Op{Key: key})
+}
+
+// Set a value and store it into the KV store.
+//
+// When the key-value store implements kvs.Txn, the keys are written by a single
+// transaction, regardless of MaxTxnOps, such that the value is either entirely stored or not at all.
+// When the value can't be stored, the object is restored to its previous state
+// and the key-value store error is returned.
+func Set(s kvs.Store, c context.Context, object interface{}, format interface{}, value interface{}, fields ...interface{}) error {
 	s.Lock()
 
+	snap := takeSnapshot(object, format, fields)
 	err := encoding.SetByFields(object, format, value, fields...)
 	s.Unlock()
 	if err != nil {
 		return err
 	}
 
-	Store(s, c, object, format, fields...)
+	err = store(s, c, object, format, 0, fields...)
+	if err != nil {
+		snap.restore(s)
+		return err
+	}
 	return nil
 }
 
-// Deletes a part of an object in the KV Store and push the change to the underlying KVStore
-func Delete(s kvs.Store, c context.Context, object interface{}, format string, fields ...interface{}) error {
+// Deletes a part of an object in the KV Store and push the change to the underlying KVStore.
+//
+// Any sub-object may be deleted (see encoding.DeleteFields): map and slice elements are removed,
+// and other sub-objects are reset to their zero value. The keys and directories storing the
+// sub-object are deleted, and values containing the sub-object are stored again.
+// When the key-value store implements kvs.Txn, all the changes are applied by a single
+// transaction, regardless of MaxTxnOps.
+//
+// When the changes can't be applied, the object is restored to its previous state
+// and the key-value store error is returned.
+func Delete(s kvs.Store, c context.Context, object interface{}, format interface{}, fields ...interface{}) error {
 	s.Lock()
 
-	err, key := encoding.DeleteByFields(object, format, fields...)
+	snap := takeSnapshot(object, format, storedFields(object, format, fields))
+	d, err := encoding.DeleteFields(object, format, fields...)
 	s.Unlock()
 	if err != nil {
 		return err
 	}
-	s.Delete(c, key)
-	return err
+
+	err = applyChanges(s, c, changeOps(d))
+	if err != nil {
+		snap.restore(s)
+		return err
+	}
+	return nil
+}
+
+// Moves a sub-object of an object to another field path (e.g. renames a map element),
+// and pushes the change to the underlying KVStore.
+//
+// The sub-object is stored under its new keys, replacing any sub-object stored there,
+// and the keys storing it at its previous location are deleted (see encoding.MoveFields).
+// When the key-value store implements kvs.Txn, all the changes are applied by a single
+// transaction, regardless of MaxTxnOps, such that synchronized objects can recognize
+// the move (see sync.SyncEvent.MovedTo).
+//
+// When the changes can't be applied, the object is restored to its previous state
+// and the key-value store error is returned.
+func Move(s kvs.Store, c context.Context, object interface{}, format interface{}, from []interface{}, to []interface{}) error {
+	s.Lock()
+
+	snapTo := takeSnapshot(object, format, to)
+	snapFrom := takeSnapshot(object, format, from)
+	dst, src, err := encoding.MoveFields(object, format, from, to)
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = applyChanges(s, c, append(changeOps(dst), changeOps(src)...))
+	if err != nil {
+		snapTo.restore(s)
+		snapFrom.restore(s)
+		return err
+	}
+	return nil
+}
+
+// Applies operations by a single transaction when the key-value store implements kvs.Txn,
+// or one by one otherwise.
+func applyChanges(s kvs.Store, c context.Context, ops []kvs.Op) error {
+	if t, ok := s.(kvs.Txn); ok {
+		return t.Txn(c, ops)
+	}
+	return applyOps(s, c, ops)
+}
+
+// Applies operations one by one.
+func applyOps(s kvs.Store, c context.Context, ops []kvs.Op) error {
+	for _, op := range ops {
+		var err error
+		if op.Value == nil {
+			err = deleteKey(s, c, op.Key)
+		} else {
+			err = s.Set(c, op.Key, *op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the operations applying the changes: keys are deleted first,
+// followed by values set in increasing key order.
+func changeOps(d *encoding.Changes) []kvs.Op {
+	ops := make([]kvs.Op, 0, len(d.Keys)+len(d.Values))
+	for _, key := range d.Keys {
+		ops = append(ops, kvs.Op{Key: key})
+	}
+	keys := make([]string, 0, len(d.Values))
+	for key := range d.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := d.Values[key]
+		ops = append(ops, kvs.Op{Key: key, Value: &value})
+	}
+	return ops
+}
+
+// Copy of a sub-object, used to restore an object when its changes could not be stored.
+type snapshot struct {
+	object interface{}
+	format interface{}
+
+	// Field path of the sub-object, or of its closest parent which did not exist.
+	fields []interface{}
+
+	// Copy of the sub-object, or an invalid value if it did not exist.
+	value reflect.Value
+}
+
+// Takes a snapshot of the sub-object designated by fields.
+func takeSnapshot(object interface{}, format interface{}, fields []interface{}) snapshot {
+	snap := snapshot{
+		object: object,
+		format: format,
+		fields: fields,
+	}
+
+	v := reflect.ValueOf(object)
+	for i := 0; ; i++ {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				snap.fields = fields[:i]
+				return snap
+			}
+			v = v.Elem()
+		}
+		if i == len(fields) {
+			break
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			name, _ := fields[i].(string)
+			v = v.FieldByName(name)
+		case reflect.Map:
+			k := reflect.ValueOf(fields[i])
+			if !k.IsValid() || k.Type() != v.Type().Key() {
+				return snap
+			}
+			v = v.MapIndex(k)
+		case reflect.Slice, reflect.Array:
+			index, ok := fields[i].(int)
+			if !ok || index < 0 || index >= v.Len() {
+				return snap
+			}
+			v = v.Index(index)
+		default:
+			return snap
+		}
+		if !v.IsValid() {
+			snap.fields = fields[:i+1]
+			return snap
+		}
+	}
+
+	snap.value = reflect.New(v.Type()).Elem()
+	snap.value.Set(v)
+	return snap
+}
+
+// Restores the sub-object.
+func (snap snapshot) restore(s kvs.Store) {
+	s.Lock()
+	defer s.Unlock()
+
+	// The snapshot was taken from the object, so restoring it does not fail
+	if snap.value.IsValid() {
+		encoding.SetByFields(snap.object, snap.format, snap.value.Interface(), snap.fields...)
+	} else {
+		encoding.DeleteFields(snap.object, snap.format, snap.fields...)
+	}
+}
+
+// Returns the prefix of the field path designating the sub-object stored at its own keys.
+func storedFields(object interface{}, format interface{}, fields []interface{}) []interface{} {
+	n := len(fields)
+	for n > 0 {
+		_, _, err := encoding.FindByFields(object, format, fields[:n])
+		if !errors.Is(err, encoding.ErrFindPathPastObject) {
+			break
+		}
+		n--
+	}
+	return fields[:n]
 }
@@ -16,9 +16,13 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Oryon/kvsync/encoding"
+	"github.com/Oryon/kvsync/kvs"
+	"github.com/Oryon/kvsync/kvs/faulty"
 	"github.com/Oryon/kvsync/kvs/gomap"
+	"github.com/Oryon/kvsync/kvs/retry"
 	"reflect"
 	"testing"
 )
@@ -34,9 +38,9 @@ type S2 struct {
 	M map[int]S1 `kvs:"map/{key}/s1/"`
 }
 
-func testStore(t *testing.T, gm *gomap.Gomap, obj interface{}, format string, truth map[string]string, err error, fields ...interface{}) {
+func testStore(t *testing.T, gm *gomap.Gomap, obj interface{}, format interface{}, truth map[string]string, err error, fields ...interface{}) {
 	e := Store(gm, context.Background(), obj, format, fields...)
-	if e != err {
+	if !errors.Is(e, err) {
 		fmt.Printf("FAIL::::: Set returned %v\n", e)
 		t.Errorf("Set returned %v", e)
 	}
@@ -48,7 +52,7 @@ func testStore(t *testing.T, gm *gomap.Gomap, obj interface{}, format string, tr
 
 func testDelete(t *testing.T, gm *gomap.Gomap, obj interface{}, format string, truth map[string]string, err error, fields ...interface{}) {
 	e := Delete(gm, context.Background(), obj, format, fields...)
-	if e != err {
+	if !errors.Is(e, err) {
 		fmt.Printf("FAIL::::: Set returned %v\n", e)
 		t.Errorf("Set returned %v", e)
 	}
@@ -98,9 +102,9 @@ func TestStore(t *testing.T) {
 	testDelete(t, gm, &st, "/here/", m, nil, "M", 2)
 }
 
-func testSet(t *testing.T, gm *gomap.Gomap, obj interface{}, format string, val interface{}, truth map[string]string, err error, fields ...interface{}) {
+func testSet(t *testing.T, gm *gomap.Gomap, obj interface{}, format interface{}, val interface{}, truth map[string]string, err error, fields ...interface{}) {
 	e := Set(gm, context.Background(), obj, format, val, fields...)
-	if e != err {
+	if !errors.Is(e, err) {
 		fmt.Printf("FAIL::::: Set returned %v\n", e)
 		t.Errorf("Set returned %v", e)
 	}
@@ -144,3 +148,429 @@ func TestSet(t *testing.T) {
 	testSet(t, gm, &st, "/here/", 14, m, nil, "M", 2, "A")
 	testSet(t, gm, &st, "/here/", "str", m, encoding.ErrFindSetWrongType, "M", 2, "A")
 }
+
+type S3 struct {
+	A int `kvs:"a,codec=text"`
+	B S1  `kvs:"b"`
+}
+
+func TestSetCodec(t *testing.T) {
+	gm := gomap.Create()
+	st := S3{}
+
+	m := make(map[string]string)
+	m["/here/a"] = "1"
+	testSet(t, gm, &st, "/here/,codec=text", 1, m, nil, "A")
+
+	m["/here/b"] = "{\"A\":2,\"B\":0}"
+	testSet(t, gm, &st, "/here/", S1{A: 2}, m, nil, "B")
+}
+
+type S4 struct {
+	A int            `kvs:"a,omitempty"`
+	M map[string]int `kvs:"m/{key},omitempty"`
+	L int            `kvs:"-"`
+}
+
+func TestSetOmitEmpty(t *testing.T) {
+	gm := gomap.Create()
+	st := S4{}
+
+	m := make(map[string]string)
+	m["/here/a"] = "1"
+	testSet(t, gm, &st, "/here/", 1, m, nil, "A")
+
+	m["/here/m/x"] = "2"
+	testSet(t, gm, &st, "/here/", 2, m, nil, "M", "x")
+
+	delete(m, "/here/a")
+	testSet(t, gm, &st, "/here/", 0, m, nil, "A")
+
+	delete(m, "/here/m/x")
+	testSet(t, gm, &st, "/here/", map[string]int{}, m, nil, "M")
+
+	testSet(t, gm, &st, "/here/", 3, m, encoding.ErrFieldSkipped, "L")
+}
+
+func TestStoreLayout(t *testing.T) {
+	gm := gomap.Create()
+	st := S2{S: S1{A: 1}}
+
+	l, err := encoding.Compile("/here/", reflect.TypeOf(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := map[string]string{
+		"/here/S/A": "1",
+		"/here/S/B": "0",
+		"/here/B":   "",
+	}
+	testStore(t, gm, &st, l, m, nil)
+
+	m["/here/B"] = "b"
+	testSet(t, gm, &st, l, "b", m, nil, "B")
+}
+
+// Records the transactions applied to a gomap.
+type txnRecorder struct {
+	*gomap.Gomap
+	txns [][]kvs.Op
+}
+
+func (r *txnRecorder) Txn(c context.Context, ops []kvs.Op) error {
+	r.txns = append(r.txns, append([]kvs.Op(nil), ops...))
+	return r.Gomap.Txn(c, ops)
+}
+
+// Hides the Txn method of a gomap.
+type noTxnStore struct {
+	kvs.Store
+}
+
+type S5 struct {
+	P *S1        `kvs:"p/"`
+	L []int      `kvs:"l"`
+	M map[int]S1 `kvs:"m/{key}"`
+}
+
+func TestDelete(t *testing.T) {
+	gm := gomap.Create()
+	st := S5{
+		P: &S1{A: 1, B: 2},
+		L: []int{1, 2, 3},
+		M: map[int]S1{1: {A: 1}},
+	}
+	testStore(t, gm, &st, "/here/", map[string]string{
+		"/here/p/A": "1",
+		"/here/p/B": "2",
+		"/here/l":   "[1,2,3]",
+		"/here/m/1": `{"A":1,"B":0}`,
+	}, nil)
+
+	// Slice elements are removed from the stored value
+	m := map[string]string{
+		"/here/p/A": "1",
+		"/here/p/B": "2",
+		"/here/l":   "[1,3]",
+		"/here/m/1": `{"A":1,"B":0}`,
+	}
+	testDelete(t, gm, &st, "/here/", m, nil, "L", 1)
+
+	// Attributes of values are reset in the stored value
+	m["/here/m/1"] = `{"A":0,"B":0}`
+	testDelete(t, gm, &st, "/here/", m, nil, "M", 1, "A")
+
+	// Pointers are reset and all their keys deleted
+	delete(m, "/here/p/A")
+	delete(m, "/here/p/B")
+	testDelete(t, gm, &st, "/here/", m, nil, "P")
+	if st.P != nil {
+		t.Errorf("Pointer was not reset")
+	}
+
+	// Deleting an object which is not stored
+	testDelete(t, gm, &st, "/here/", m, nil, "P")
+	testDelete(t, gm, &st, "/here/", m, encoding.ErrFindObjectNotFound, "M", 2)
+
+	testDelete(t, gm, &st, "/here/", map[string]string{}, nil)
+	if !reflect.DeepEqual(st, S5{}) {
+		t.Errorf("Object was not reset %v", st)
+	}
+}
+
+func TestStoreTxn(t *testing.T) {
+	defer func(max int) { MaxTxnOps = max }(MaxTxnOps)
+	MaxTxnOps = 2
+
+	st := S4{M: map[string]int{"y": 1, "x": 2, "z": 3}}
+	r := &txnRecorder{Gomap: gomap.Create()}
+	err := Store(r, context.Background(), &st, "/here/")
+	if err != nil {
+		t.Fatalf("Store returned %v", err)
+	}
+
+	// Keys are written in order, omitted keys being deleted
+	var keys [][]string
+	for _, txn := range r.txns {
+		var k []string
+		for _, op := range txn {
+			if op.Value == nil {
+				k = append(k, "-"+op.Key)
+			} else {
+				k = append(k, op.Key)
+			}
+		}
+		keys = append(keys, k)
+	}
+	expected := [][]string{{"-/here/a", "/here/m/x"}, {"/here/m/y", "/here/m/z"}}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Wrong transactions %v (should be %v)", keys, expected)
+	}
+
+	// Stores which do not provide transactions are written key by key
+	gm := gomap.Create()
+	err = Store(noTxnStore{gm}, context.Background(), &st, "/here/")
+	if err != nil {
+		t.Fatalf("Store returned %v", err)
+	}
+	if !reflect.DeepEqual(gm.GetBackingMap(), r.GetBackingMap()) {
+		t.Errorf("Incorrect return %v (should be %v)", gm.GetBackingMap(), r.GetBackingMap())
+	}
+}
+
+func TestMove(t *testing.T) {
+	st := S2{M: map[int]S1{1: {A: 1, B: 2}, 2: {A: 3}}}
+	r := &txnRecorder{Gomap: gomap.Create()}
+	testStore(t, r.Gomap, &st, "/here/", map[string]string{
+		"/here/B":          "",
+		"/here/S/A":        "0",
+		"/here/S/B":        "0",
+		"/here/map/1/s1/A": "1",
+		"/here/map/1/s1/B": "2",
+		"/here/map/2/s1/A": "3",
+		"/here/map/2/s1/B": "0",
+	}, nil)
+
+	// The new keys are stored and the previous ones deleted by a single transaction
+	err := Move(r, context.Background(), &st, "/here/", []interface{}{"M", 1}, []interface{}{"M", 3})
+	if err != nil {
+		t.Fatalf("Move returned %v", err)
+	}
+	m := map[string]string{
+		"/here/B":          "",
+		"/here/S/A":        "0",
+		"/here/S/B":        "0",
+		"/here/map/2/s1/A": "3",
+		"/here/map/2/s1/B": "0",
+		"/here/map/3/s1/A": "1",
+		"/here/map/3/s1/B": "2",
+	}
+	if !reflect.DeepEqual(m, r.GetBackingMap()) {
+		t.Errorf("Incorrect return %v (should be %v)", r.GetBackingMap(), m)
+	}
+	if len(r.txns) != 1 {
+		t.Errorf("Move applied %d transactions", len(r.txns))
+	}
+
+	// Stores which do not provide transactions are written key by key
+	gm := gomap.CreateFromExistingMap(nil)
+	for k, v := range m {
+		gm.Set(context.Background(), k, v)
+	}
+	err = Move(noTxnStore{gm}, context.Background(), &st, "/here/", []interface{}{"M", 3}, []interface{}{"M", 2})
+	if err != nil {
+		t.Fatalf("Move returned %v", err)
+	}
+	delete(m, "/here/map/3/s1/A")
+	delete(m, "/here/map/3/s1/B")
+	m["/here/map/2/s1/A"] = "1"
+	m["/here/map/2/s1/B"] = "2"
+	if !reflect.DeepEqual(m, gm.GetBackingMap()) {
+		t.Errorf("Incorrect return %v (should be %v)", gm.GetBackingMap(), m)
+	}
+	if !reflect.DeepEqual(st.M, map[int]S1{2: {A: 1, B: 2}}) {
+		t.Errorf("Wrong object %v", st.M)
+	}
+
+	err = Move(gm, context.Background(), &st, "/here/", []interface{}{"M", 1}, []interface{}{"M", 2})
+	if !errors.Is(err, encoding.ErrFindObjectNotFound) {
+		t.Errorf("Move returned %v", err)
+	}
+}
+
+var errBackend = errors.New("Backend failure")
+
+// Fails the writes once a given number of writes succeeded.
+type failingStore struct {
+	kvs.Store
+	writes int
+}
+
+func (f *failingStore) Set(c context.Context, key string, value string) error {
+	if f.writes == 0 {
+		return errBackend
+	}
+	f.writes--
+	return f.Store.Set(c, key, value)
+}
+
+func (f *failingStore) Delete(c context.Context, key string) error {
+	if f.writes == 0 {
+		return errBackend
+	}
+	f.writes--
+	return f.Store.Delete(c, key)
+}
+
+// Fails all the transactions.
+type failingTxnStore struct {
+	*gomap.Gomap
+}
+
+func (f failingTxnStore) Txn(c context.Context, ops []kvs.Op) error {
+	return errBackend
+}
+
+func TestSetRollback(t *testing.T) {
+	st := S2{M: map[int]S1{1: {A: 1}}}
+	f := &failingStore{Store: gomap.Create()}
+
+	err := Set(f, context.Background(), &st, "/here/", 5, "S", "A")
+	if !errors.Is(err, errBackend) || st.S.A != 0 {
+		t.Errorf("Set returned %v with object %v", err, st)
+	}
+
+	// Created map elements are removed
+	err = Set(f, context.Background(), &st, "/here/", S1{A: 2}, "M", 2)
+	if !errors.Is(err, errBackend) || !reflect.DeepEqual(st.M, map[int]S1{1: {A: 1}}) {
+		t.Errorf("Set returned %v with object %v", err, st)
+	}
+
+	// Writes which partially succeeded
+	f.writes = 1
+	err = Set(f, context.Background(), &st, "/here/", S2{B: "b"})
+	if !errors.Is(err, errBackend) || !reflect.DeepEqual(st, S2{M: map[int]S1{1: {A: 1}}}) {
+		t.Errorf("Set returned %v with object %v", err, st)
+	}
+
+	// Pointers are restored
+	s5 := S5{}
+	err = Set(f, context.Background(), &s5, "/here/", S1{A: 1}, "P")
+	if !errors.Is(err, errBackend) || s5.P != nil {
+		t.Errorf("Set returned %v with object %v", err, s5)
+	}
+	p := &S1{A: 1}
+	s5.P = p
+	err = Set(f, context.Background(), &s5, "/here/", S1{A: 2}, "P")
+	if !errors.Is(err, errBackend) || !reflect.DeepEqual(s5.P, &S1{A: 1}) || !reflect.DeepEqual(p, &S1{A: 1}) {
+		t.Errorf("Set returned %v with object %v", err, s5)
+	}
+}
+
+func TestDeleteRollback(t *testing.T) {
+	st := S5{
+		P: &S1{A: 1, B: 2},
+		L: []int{1, 2, 3},
+		M: map[int]S1{1: {A: 1}, 2: {A: 2}},
+	}
+	gm := gomap.Create()
+	err := Store(gm, context.Background(), &st, "/here/")
+	if err != nil {
+		t.Fatalf("Store returned %v", err)
+	}
+	f := &failingStore{Store: gm}
+
+	for _, fields := range [][]interface{}{{"M", 1}, {"M", 2, "A"}, {"L", 1}, {"P"}, {"P", "A"}, {}} {
+		err = Delete(f, context.Background(), &st, "/here/", fields...)
+		if !errors.Is(err, errBackend) {
+			t.Errorf("Delete%v returned %v", fields, err)
+		}
+		if !reflect.DeepEqual(st, S5{P: &S1{A: 1, B: 2}, L: []int{1, 2, 3}, M: map[int]S1{1: {A: 1}, 2: {A: 2}}}) {
+			t.Errorf("Delete%v did not restore the object %v", fields, st)
+		}
+	}
+	if len(gm.GetBackingMap()) != 5 {
+		t.Errorf("Keys were modified %v", gm.GetBackingMap())
+	}
+
+	// Pointed values are not modified in place
+	type Inner struct{ X int }
+	type Elem struct{ P *Inner }
+	type S6 struct {
+		M map[string]Elem `kvs:"m/{key}"`
+	}
+	s6 := S6{M: map[string]Elem{"a": {P: &Inner{X: 1}}}}
+	err = Store(gm, context.Background(), &s6, "/there/")
+	if err != nil {
+		t.Fatalf("Store returned %v", err)
+	}
+	err = Delete(f, context.Background(), &s6, "/there/", "M", "a", "P", "X")
+	if !errors.Is(err, errBackend) || s6.M["a"].P.X != 1 {
+		t.Errorf("Delete returned %v with object %v", err, s6.M["a"].P)
+	}
+}
+
+func TestSetDeleteTxn(t *testing.T) {
+	defer func(max int) { MaxTxnOps = max }(MaxTxnOps)
+	MaxTxnOps = 2
+
+	// Changes are applied by a single transaction, regardless of MaxTxnOps
+	st := S4{}
+	r := &txnRecorder{Gomap: gomap.Create()}
+	err := Set(r, context.Background(), &st, "/here/", map[string]int{"x": 1, "y": 2, "z": 3}, "M")
+	if err != nil || len(r.txns) != 1 || len(r.txns[0]) != 3 {
+		t.Errorf("Set returned %v with transactions %v", err, r.txns)
+	}
+	r.txns = nil
+	err = Delete(r, context.Background(), &st, "/here/", "M")
+	if err != nil || len(r.txns) != 1 || len(r.GetBackingMap()) != 0 {
+		t.Errorf("Delete returned %v with transactions %v", err, r.txns)
+	}
+
+	// The object is restored when the transaction fails
+	f := failingTxnStore{gomap.Create()}
+	err = Set(f, context.Background(), &st, "/here/", map[string]int{"x": 1, "y": 2, "z": 3}, "M")
+	if !errors.Is(err, errBackend) || len(st.M) != 0 {
+		t.Errorf("Set returned %v with object %v", err, st)
+	}
+}
+
+func TestMoveRollback(t *testing.T) {
+	st := S2{M: map[int]S1{1: {A: 1}, 2: {A: 2}}}
+	for _, s := range []kvs.Store{failingTxnStore{gomap.Create()}, &failingStore{Store: gomap.Create(), writes: 1}} {
+		err := Move(s, context.Background(), &st, "/here/", []interface{}{"M", 1}, []interface{}{"M", 2})
+		if !errors.Is(err, errBackend) || !reflect.DeepEqual(st.M, map[int]S1{1: {A: 1}, 2: {A: 2}}) {
+			t.Errorf("Move returned %v with object %v", err, st)
+		}
+	}
+}
+
+func TestStoreFaults(t *testing.T) {
+	gm := gomap.Create()
+	f := faulty.Create(gm, faulty.Faults{
+		Seed:       1,
+		ErrorRates: map[faulty.Op]float64{faulty.OpSet: 0.3, faulty.OpDelete: 0.3},
+	})
+
+	// Objects are left unchanged when they can't be stored
+	st := S2{}
+	for i := 0; i < 20; i++ {
+		err := Set(f, context.Background(), &st, "/here/", S1{A: i}, "M", i)
+		_, ok := st.M[i]
+		if err == nil && (!ok || gm.GetBackingMap()[fmt.Sprintf("/here/map/%d/s1/A", i)] != fmt.Sprint(i)) {
+			t.Errorf("Element %d was not stored", i)
+		} else if err != nil && (!errors.Is(err, faulty.ErrInjected) || ok) {
+			t.Errorf("Set returned %v with object %v", err, st.M)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		_, stored := st.M[i]
+		err := Delete(f, context.Background(), &st, "/here/", "M", i)
+		_, ok := st.M[i]
+		if (err == nil && ok) || (err != nil && ok != stored) {
+			t.Errorf("Delete returned %v with object %v", err, st.M)
+		}
+	}
+
+	// Retrying until the store succeeds
+	r := retry.Create(f, retry.Policy{})
+	st = S2{}
+	for i := 0; i < 20; i++ {
+		err := Set(r, context.Background(), &st, "/here/", S1{A: i}, "M", i)
+		if err != nil {
+			t.Errorf("Set returned %v", err)
+		}
+	}
+	err := Store(r, context.Background(), &st, "/here/")
+	if err != nil {
+		t.Errorf("Store returned %v", err)
+	}
+	expected, err := encoding.Encode("/here/", &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, gm.GetBackingMap()) {
+		t.Errorf("Incorrect return %v (should be %v)", gm.GetBackingMap(), expected)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/Oryon/kvsync/encoding"
 	"github.com/Oryon/kvsync/kvs"
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -37,6 +38,9 @@ var ErrNotThisPath = errors.New("The modified object is not on this path")
 var ErrNotImplemented = errors.New("This is not implemented")
 var ErrNilPointer = errors.New("Reached nil pointer")
 var ErrIsDelete = errors.New("Object is being deleted")
+var ErrIsCreate = errors.New("Object is being created")
+var ErrOverlappingKeySpace = errors.New("Cannot watch objects in overlapping key spaces")
+var ErrNotSynchronized = errors.New("No object is synchronized with this format")
 
 // SyncEvent is used to notify a change on a watched object
 // as well as diving into the changed element of the object.
@@ -49,6 +53,22 @@ type SyncEvent struct {
 
 	// Keep track of potential error
 	err error
+
+	// Whether the event replays a state that existed before the object was synchronized.
+	initial bool
+
+	// A copy of the modified sub-object before the change,
+	// or an invalid value if it did not exist.
+	previous reflect.Value
+
+	// The key-value update which caused the change.
+	update kvs.Update
+
+	// Whether the modified sub-object was deleted.
+	deleted bool
+
+	// Field path the deleted sub-object was moved to, or nil.
+	moved []interface{}
 }
 
 // These callbacks are used to get notified when a synchronized object changed.
@@ -135,14 +155,35 @@ func (se SyncEvent) GetIndex(index *int) SyncEvent {
 }
 
 // Returns whether the currently considered object was actually deleted.
+// Deleted map elements are removed from the map, while other deleted
+// sub-objects are reset to their zero value.
 func (se SyncEvent) IsDeleted(deleted *bool) SyncEvent {
-	if !se.current_object.IsValid() && len(se.fields) == 0 {
+	if (se.deleted || !se.current_object.IsValid()) && len(se.fields) == 0 {
 		*deleted = true
 		return se
 	}
 	return se
 }
 
+// Returns the field path, from the synchronized object, of the sub-object which replaces
+// the deleted sub-object when the deletion is part of a move (see store.Move), or nil otherwise.
+//
+// A deletion is recognized as a move when the same transaction previously stored an equal
+// sub-object at a sibling location (e.g. another key of the same map). Moves can only be
+// recognized when the key-value store identifies transactions (see kvs.Update.Txn), and
+// when the moved sub-object is deleted by a single key or directory.
+func (se SyncEvent) MovedTo(to *[]interface{}) SyncEvent {
+	*to = se.moved
+	return se
+}
+
+// Returns whether the event notifies a key-value pair that was already known
+// when the object started being synchronized, rather than an actual change.
+func (se SyncEvent) IsInitial(initial *bool) SyncEvent {
+	*initial = se.initial
+	return se
+}
+
 func (se SyncEvent) Error() error {
 	return se.err
 }
@@ -159,6 +200,36 @@ func (se SyncEvent) Current() (interface{}, error) {
 	return se.current_object.Interface(), nil
 }
 
+// Returns the value the currently considered object had before the change,
+// or ErrIsCreate if it did not exist.
+//
+// When called on the modified sub-object, the previous value is returned
+// as is. When called on one of its parents, a copy of the parent
+// is returned, in which the modified sub-object is set to its previous value,
+// or to its zero value if it did not exist.
+func (se SyncEvent) Previous() (interface{}, error) {
+	if se.err != nil {
+		return nil, se.err
+	}
+	if !se.current_object.IsValid() && len(se.fields) != 0 {
+		return nil, ErrIsDelete
+	}
+
+	prev := revertValue(se.current_object, se.fields, se.previous)
+	if !prev.IsValid() {
+		return nil, ErrIsCreate
+	}
+	return prev.Interface(), nil
+}
+
+// Returns the key-value update which caused the change.
+// The key is absolute, and the previous and new values are
+// the raw strings found in the storage.
+// Events marked as initial have no previous value.
+func (se SyncEvent) Update() kvs.Update {
+	return se.update
+}
+
 func (se SyncEvent) String() (string, error) {
 	if se.err != nil {
 		return "", se.err
@@ -195,6 +266,67 @@ func (se SyncEvent) Bool() (bool, error) {
 	return b, nil
 }
 
+// Returns a copy of v where the sub-object designated by fields is replaced by leaf,
+// or removed if leaf is invalid.
+// Only the path to the sub-object is copied, such that v is not modified.
+func revertValue(v reflect.Value, fields []interface{}, leaf reflect.Value) reflect.Value {
+	if len(fields) == 0 {
+		return leaf
+	}
+	if !v.IsValid() {
+		return v
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if !v.IsNil() {
+			p.Elem().Set(v.Elem())
+		}
+		p.Elem().Set(revertValue(p.Elem(), fields, leaf))
+		return p
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		f := c.FieldByName(fields[0].(string))
+		r := revertValue(f, fields[1:], leaf)
+		if r.IsValid() {
+			f.Set(r)
+		} else {
+			f.Set(reflect.Zero(f.Type()))
+		}
+		return c
+	case reflect.Map:
+		c := reflect.MakeMap(v.Type())
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, v.MapIndex(k))
+		}
+		k := reflect.ValueOf(fields[0])
+		c.SetMapIndex(k, revertValue(v.MapIndex(k), fields[1:], leaf))
+		return c
+	default:
+		return v
+	}
+}
+
+// Returns the sub-object of v designated by fields, or an invalid value if it does not exist.
+func valueByFields(v reflect.Value, fields []interface{}) reflect.Value {
+	for _, f := range fields {
+		for v.IsValid() && v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if !v.IsValid() {
+			return v
+		}
+		if v.Kind() == reflect.Struct {
+			v = v.FieldByName(f.(string))
+		} else {
+			v = v.MapIndex(reflect.ValueOf(f))
+		}
+	}
+	return v
+}
+
 func (se SyncEvent) derefPointers() SyncEvent {
 	if !se.current_object.IsValid() {
 		se.err = ErrIsDelete
@@ -211,21 +343,121 @@ func (se SyncEvent) derefPointers() SyncEvent {
 	return se
 }
 
+// SyncObject describes an object to synchronize.
 type SyncObject struct {
-	Format   string
+	// The object format string, or its *encoding.Layout.
+	// Format strings are compiled when the object is synchronized, such that
+	// invalid formats are reported by SyncObject.
+	Format   interface{}
 	Object   interface{}
 	Callback SyncCallback
+
+	// When set, map elements which are left empty after a key deletion are removed
+	// from their map (see encoding.PruneEmpty).
+	PruneEmpty bool
+
+	// When set, the object cannot share its key space with any other object.
+	// Registering an exclusive object overlapping an existing one, or an object
+	// overlapping an existing exclusive one, fails with ErrOverlappingKeySpace.
+	Exclusive bool
+
+	// When set, values which can't be decoded are reported as a *DecodeError
+	// and the object keeps its previous value (see Sync.Strict).
+	Strict bool
+}
+
+// DecodeError describes a value which could not be decoded into an object synchronized in strict mode.
+type DecodeError struct {
+	// The synchronized object.
+	Object interface{}
+
+	// The key, relative to the root of the key-value store.
+	Key string
+
+	// Field path of the sub-object stored at the key.
+	Fields []interface{}
+
+	// The raw value.
+	Value string
+
+	// The decoding error.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Failed to decode key '%s' into %v: %v", e.Key, e.Fields, e.Err)
 }
 
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// These callbacks are used to get notified when a value could not be decoded.
+type DecodeErrorCallback func(err *DecodeError) error
+
+// Counters of a Sync.
+type Stats struct {
+	// Number of updates received from the key-value store.
+	Updates uint64
+
+	// Number of values which could not be decoded into objects synchronized in strict mode.
+	DecodeErrors uint64
+}
+
+// Sync dispatches the updates from a kvs.Sync to the synchronized objects.
+//
+// Multiple objects may be synchronized with overlapping key spaces, for instance
+// one with format '/db/' and one with format '/db/Nodes/', or even twice the same format.
+// Each update is then applied to every object which format matches the key, and
+// their callbacks are called in the order the objects were synchronized.
+//
+// Sync is not safe for concurrent use. SyncObject and UnsyncObject must be called
+// from the goroutine calling Next, which includes calling them from callbacks.
 type Sync struct {
-	Sync     kvs.Sync
+	Sync kvs.Sync
+
+	// When set, all the objects are synchronized in strict mode.
+	//
+	// By default, a value which can't be decoded sets the sub-object stored at the key
+	// to its zero value. In strict mode, the sub-object keeps its previous value, its
+	// callback is not called, and a *DecodeError is given to ErrorCallback.
+	Strict bool
+
+	// Called when a value can't be decoded into an object synchronized in strict mode.
+	// Errors returned by the callback are returned like errors returned by object callbacks.
+	// When not set, the *DecodeError itself is returned.
+	ErrorCallback DecodeErrorCallback
+
 	objects  map[int]SyncObject
 	next_key int
+
+	// Latest known value of every key returned by Sync,
+	// used to populate objects synchronized after the initial listing.
+	index map[string]string
+
+	// Index of the synchronized objects formats, used to route updates.
+	trie formatTrie
+
+	stats Stats
+
+	// Transaction of the last update, and field paths it stored in every object,
+	// used to recognize moves.
+	txn     uint64
+	txnSets map[int][][]interface{}
+}
+
+// Returns the counters of the Sync.
+func (s *Sync) Stats() Stats {
+	return s.stats
 }
 
 // Waits until the next change from the storage, updates
-// the objects that are being synchronized, calls the callback,
+// the objects that are being synchronized, calls the callbacks,
 // and then returns.
+//
+// An error returned by a callback does not prevent the following
+// objects from being updated and notified. Next returns the first error
+// returned by a callback (or the first *DecodeError when ErrorCallback is not set), if any.
 func (s *Sync) Next(c context.Context) error {
 	s.initIfNot()
 
@@ -234,97 +466,259 @@ func (s *Sync) Next(c context.Context) error {
 		return err
 	}
 
+	s.stats.Updates++
+	s.indexUpdate(e)
+	if e.Txn == 0 || e.Txn != s.txn {
+		s.txn = e.Txn
+		s.txnSets = nil
+	}
+
+	// Only objects which format prefixes the key may own it
+	var cberr error
+	for _, id := range s.trie.lookup(e.Key) {
+		v, ok := s.objects[id]
+		if !ok {
+			// Object was unsynchronized by a callback
+			continue
+		}
+		err := s.deliver(id, v, e, false)
+		if err != nil && cberr == nil {
+			cberr = err
+		}
+	}
+
+	return cberr
+}
+
+// Applies an update to an object, and calls the object callback
+// if the object was modified.
+// Returns the error returned by the callback.
+func (s *Sync) deliver(id int, o SyncObject, e *kvs.Update, initial bool) error {
+	k := e.Key
+	if e.Value == nil && strings.HasSuffix(e.Key, "/") {
+		k = e.Key[:len(e.Key)-1]
+	}
+
+	// Keep a copy of the sub-object before it gets modified
+	previous, lfields, err := encoding.LookupKey(o.Object, o.Format, k)
+	if err != nil {
+		// The key does not belong to this object
+		return nil
+	}
+
 	if e.Value == nil {
-		// First try to remove as map object
-		for _, v := range s.objects {
-			k := e.Key
-			if e.Key[len(e.Key)-1] == '/' {
-				k = e.Key[:len(e.Key)-1]
-			}
-			fields, err := encoding.DeleteKeyObject(v.Object, v.Format, k)
-			if err != nil {
-				if err == encoding.ErrFindObjectNotFound {
-					return err
-				}
-				continue
-			}
-			event := SyncEvent{
-				current_object: reflect.ValueOf(v.Object),
-				fields:         fields,
-			}
-			v.Callback(&event)
+		var opts []encoding.KeyOption
+		if o.PruneEmpty {
+			opts = append(opts, encoding.PruneEmpty())
+		}
+		fields, err := encoding.DeleteKeyObject(o.Object, o.Format, k, opts...)
+		if err != nil {
+			// The deleted map element was not in this object
 			return nil
 		}
+
+		prev := reflect.ValueOf(previous)
+		moved := s.movedTo(id, o, lfields, prev)
+		if len(fields) < len(lfields) {
+			// Some empty parents were pruned, which were empty apart from the deleted object
+			t := encoding.TypeByFields(reflect.TypeOf(o.Object), fields)
+			prev = revertValue(reflect.Zero(t), lfields[len(fields):], prev)
+		}
+		return s.notify(o, e, fields, prev, initial, true, moved)
 	}
 
-	// This is a hack since some objects cannot be deleted properly for now
-	es := ""
-	if e.Value == nil {
-		e.Value = &es
+	var opts []encoding.KeyOption
+	if o.Strict || s.Strict {
+		opts = append(opts, encoding.StrictDecoding())
 	}
+	fields, err := encoding.UpdateKeyObject(o.Object, o.Format, e.Key, *e.Value, opts...)
+	var verr *encoding.ValueError
+	if errors.As(err, &verr) {
+		return s.decodeError(o, e, verr)
+	} else if err != nil {
+		// The key does not belong to this object
+		return nil
+	}
+	if e.Txn != 0 {
+		if s.txnSets == nil {
+			s.txnSets = make(map[int][][]interface{})
+		}
+		s.txnSets[id] = append(s.txnSets[id], fields)
+	}
+	return s.notify(o, e, fields, reflect.ValueOf(previous), initial, false, nil)
+}
 
-	for _, v := range s.objects {
-		fields, err := encoding.UpdateKeyObject(v.Object, v.Format, e.Key, *e.Value)
-		if err != nil {
+// Returns the field path of a sub-object stored by the current transaction at a sibling
+// location of the deleted sub-object designated by fields, and equal to its previous value.
+func (s *Sync) movedTo(id int, o SyncObject, fields []interface{}, previous reflect.Value) []interface{} {
+	n := len(fields)
+	if n == 0 || !previous.IsValid() {
+		return nil
+	}
+	for _, set := range s.txnSets[id] {
+		if len(set) < n || set[n-1] == fields[n-1] || !reflect.DeepEqual(set[:n-1], fields[:n-1]) {
 			continue
 		}
-		event := SyncEvent{
-			current_object: reflect.ValueOf(v.Object),
-			fields:         fields,
+		v := valueByFields(reflect.ValueOf(o.Object), set[:n])
+		if v.IsValid() && reflect.DeepEqual(v.Interface(), previous.Interface()) {
+			return append([]interface{}(nil), set[:n]...)
 		}
-		v.Callback(&event)
 	}
-
 	return nil
 }
 
+// Reports a value which could not be decoded into an object synchronized in strict mode.
+func (s *Sync) decodeError(o SyncObject, e *kvs.Update, verr *encoding.ValueError) error {
+	s.stats.DecodeErrors++
+	derr := &DecodeError{
+		Object: o.Object,
+		Key:    e.Key,
+		Fields: verr.Fields,
+		Value:  verr.Value,
+		Err:    verr.Err,
+	}
+	if s.ErrorCallback == nil {
+		return derr
+	}
+	return s.ErrorCallback(derr)
+}
+
+func (s *Sync) notify(o SyncObject, e *kvs.Update, fields []interface{}, previous reflect.Value, initial bool, deleted bool, moved []interface{}) error {
+	event := SyncEvent{
+		current_object: reflect.ValueOf(o.Object),
+		fields:         fields,
+		initial:        initial,
+		deleted:        deleted,
+		previous:       previous,
+		update:         *e,
+		moved:          moved,
+	}
+	return o.Callback(&event)
+}
+
 // Start synchronizing a new object, sending a notification when something changes.
+//
+// The object is immediately populated with the known key-value pairs, and the callback is
+// called for each of them. SyncObject returns the first error returned by the callback,
+// in which case the object is still synchronized.
 func (s *Sync) SyncObject(o SyncObject) error {
 	s.initIfNot()
 
+	var layout *encoding.Layout
+	switch f := o.Format.(type) {
+	case *encoding.Layout:
+		err := f.Validate(o.Object)
+		if err != nil {
+			return err
+		}
+		layout = f
+	case string:
+		var err error
+		layout, err = encoding.Compile(f, reflect.TypeOf(o.Object))
+		if err != nil {
+			return err
+		}
+	default:
+		return encoding.ErrFormatType
+	}
+	o.Format = layout
+
 	for _, v := range s.objects {
-		if prefixCollision(o.Format, v.Format) {
-			return fmt.Errorf("Cannot watch objects in overlaping key spaces.")
+		if (o.Exclusive || v.Exclusive) && prefixCollision(layout.String(), v.Format.(*encoding.Layout).String()) {
+			return ErrOverlappingKeySpace
 		}
 	}
 
-	s.objects[s.next_key] = o
+	id := s.next_key
+	s.objects[id] = o
+	s.trie.insert(layout.String(), id)
 	s.next_key++ //FIXME: This will not work after loop.
 
-	//TODO: Register watcher on KVS
-
-	return nil
+	return s.replay(id, o)
 }
 
-// Start synchronizing a new object, sending a notification when something changes.
+// Stop synchronizing all the objects registered with the given format.
+// The objects do not receive any update nor notification once this function returns.
 func (s *Sync) UnsyncObject(key string) error {
 	s.initIfNot()
+	found := false
 	for k, v := range s.objects {
-		if v.Format == key {
+		if format := v.Format.(*encoding.Layout).String(); format == key {
 			delete(s.objects, k)
-			//TODO: Unregister watcher on KVS
-			return nil
+			s.trie.remove(format, k)
+			found = true
 		}
 	}
 
-	return fmt.Errorf("Key '%s' not found in listeners", key)
+	if !found {
+		return fmt.Errorf("%w: '%s'", ErrNotSynchronized, key)
+	}
+	return nil
 }
 
 func (s *Sync) initIfNot() {
 	if s.objects == nil {
 		s.objects = make(map[int]SyncObject)
 	}
+	if s.index == nil {
+		s.index = make(map[string]string)
+	}
+}
+
+// Keeps the index of known key-value pairs up to date.
+func (s *Sync) indexUpdate(e *kvs.Update) {
+	if e.Value != nil {
+		s.index[e.Key] = *e.Value
+		return
+	}
+
+	if !strings.HasSuffix(e.Key, "/") {
+		delete(s.index, e.Key)
+		return
+	}
+
+	// Deleting a directory removes all the keys it contains
+	for k := range s.index {
+		if strings.HasPrefix(k, e.Key) {
+			delete(s.index, k)
+		}
+	}
+}
+
+// Populates a newly synchronized object with the known key-value pairs,
+// calling the callback for each of them with events marked as initial.
+func (s *Sync) replay(id int, o SyncObject) error {
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var cberr error
+	for _, k := range keys {
+		if _, ok := s.objects[id]; !ok {
+			// The object was unsynchronized by a callback
+			break
+		}
+		value := s.index[k]
+		err := s.deliver(id, o, &kvs.Update{Key: k, Value: &value}, true)
+		if err != nil && cberr == nil {
+			cberr = err
+		}
+	}
+	return cberr
 }
 
+// Returns whether two formats may share some keys.
 func prefixCollision(key1, key2 string) bool {
-	s1 := strings.Split(key1, "/")
-	s2 := strings.Split(key2, "/")
+	s1 := formatTrieSegments(key1)
+	s2 := formatTrieSegments(key2)
 	if len(s1) < len(s2) {
 		s1, s2 = s2, s1 //swap works in go
 	}
 
 	for i := range s2 {
-		if s1[i] != s2[i] {
+		if s1[i] != s2[i] && s1[i] != "{key}" && s2[i] != "{key}" {
 			return false
 		}
 	}
@@ -16,8 +16,13 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/Oryon/kvsync/encoding"
+	"github.com/Oryon/kvsync/kvs/faulty"
 	"github.com/Oryon/kvsync/kvs/gomap"
+	"github.com/Oryon/kvsync/store"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -30,7 +35,7 @@ func failIfError(t *testing.T, err error) {
 }
 
 func failIfErrorDifferent(t *testing.T, err error, expected error) {
-	if err != expected {
+	if !errors.Is(err, expected) {
 		fmt.Printf("FAIL::::: Error '%v' differs from expected '%v'\n", err, expected)
 		t.Errorf("Error '%v' differs from expected '%v'", err, expected)
 	}
@@ -62,7 +67,7 @@ func TestBasicSyncUnSync(t *testing.T) {
 	failIfError(t, err)
 
 	err = s.UnsyncObject("/test/key3")
-	failIfNotError(t, err)
+	failIfErrorDifferent(t, err, ErrNotSynchronized)
 
 	err = s.UnsyncObject("/test/key2")
 	failIfError(t, err)
@@ -141,8 +146,9 @@ func TestBasicNext(t *testing.T) {
 	failIfError(t, err)
 
 	lastEvent = nil
-	c, _ := context.WithDeadline(context.Background(), time.Now().Add(time.Millisecond))
+	c, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Millisecond))
 	s.Next(c)
+	cancel()
 	if lastEvent != nil {
 		t.Errorf("There should not have been an event")
 	}
@@ -228,3 +234,733 @@ func TestBasicNext(t *testing.T) {
 	}
 
 }
+
+func TestLateSyncObject(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	st1 := S2{}
+	err := s.SyncObject(SyncObject{
+		Format:   "/o/",
+		Object:   &st1,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/o/B", "nya"))
+	failIfError(t, gm.Set(context.Background(), "/o/map/1/s1/A", "1"))
+	failIfError(t, gm.Set(context.Background(), "/o/map/2/s1/A", "2"))
+	failIfError(t, gm.Delete(context.Background(), "/o/map/2/"))
+	for i := 0; i < 4; i++ {
+		failIfError(t, s.Next(context.Background()))
+	}
+
+	var events []SyncEvent
+	st2 := S2{}
+	err = s.SyncObject(SyncObject{
+		Format: "/p/",
+		Object: &st2,
+		Callback: func(e *SyncEvent) error {
+			events = append(events, *e)
+			return nil
+		},
+	})
+	failIfError(t, err)
+	if len(events) != 0 {
+		t.Errorf("Unexpected events for an object in another key space")
+	}
+
+	// Objects registered after the initial listing get the current state
+	failIfError(t, s.UnsyncObject("/o/"))
+	st3 := S2{}
+	err = s.SyncObject(SyncObject{
+		Format: "/o/",
+		Object: &st3,
+		Callback: func(e *SyncEvent) error {
+			events = append(events, *e)
+			return nil
+		},
+	})
+	failIfError(t, err)
+	if st3.B != "nya" || st3.M[1].A != 1 {
+		t.Errorf("Object was not populated: %v", st3)
+	}
+	if _, ok := st3.M[2]; ok {
+		t.Errorf("Deleted key should not be replayed")
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 initial events, got %d", len(events))
+	}
+	for _, e := range events {
+		initial := false
+		e.IsInitial(&initial)
+		if !initial {
+			t.Errorf("Event should be initial")
+		}
+	}
+
+	// Further changes are not initial
+	events = nil
+	failIfError(t, gm.Set(context.Background(), "/o/B", "nyu"))
+	failIfError(t, s.Next(context.Background()))
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	initial := true
+	if str, err := events[0].IsInitial(&initial).Field("B").String(); err != nil || str != "nyu" || initial {
+		t.Errorf("Wrong event %v %s %v", err, str, initial)
+	}
+
+	// Unsynchronized objects are not modified anymore
+	events = nil
+	failIfError(t, s.UnsyncObject("/o/"))
+	failIfError(t, gm.Set(context.Background(), "/o/B", "nyo"))
+	failIfError(t, s.Next(context.Background()))
+	if len(events) != 0 || st3.B != "nyu" {
+		t.Errorf("Unsynchronized object should not be notified")
+	}
+
+	// Objects unsynchronized during the replay do not get further events
+	events = nil
+	st4 := S2{}
+	err = s.SyncObject(SyncObject{
+		Format: "/o/",
+		Object: &st4,
+		Callback: func(e *SyncEvent) error {
+			events = append(events, *e)
+			return s.UnsyncObject("/o/")
+		},
+	})
+	failIfError(t, err)
+	if len(events) != 1 || len(st4.M) != 0 {
+		t.Errorf("Unsynchronized object should not be notified: %d events, %v", len(events), st4)
+	}
+
+	// Empty keys are not stored by objects
+	failIfError(t, gm.Set(context.Background(), "", "x"))
+	failIfError(t, gm.Delete(context.Background(), ""))
+	for i := 0; i < 2; i++ {
+		failIfError(t, s.Next(context.Background()))
+	}
+}
+
+func TestOverlappingObjects(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	var order []string
+	cberr := fmt.Errorf("callback error")
+
+	whole := S2{}
+	err := s.SyncObject(SyncObject{
+		Format: "/o/",
+		Object: &whole,
+		Callback: func(e *SyncEvent) error {
+			order = append(order, "whole")
+			return cberr
+		},
+	})
+	failIfError(t, err)
+
+	view := make(map[int]S1)
+	err = s.SyncObject(SyncObject{
+		Format: "/o/map/{key}/s1/",
+		Object: &view,
+		Callback: func(e *SyncEvent) error {
+			order = append(order, "view")
+			key := 0
+			if err := e.Value(&key).Error(); err != nil || key != 1 {
+				t.Errorf("Wrong event %v %d", err, key)
+			}
+			return nil
+		},
+	})
+	failIfError(t, err)
+
+	err = s.SyncObject(SyncObject{
+		Format:    "/o/map/{key}/",
+		Object:    &view,
+		Exclusive: true,
+	})
+	failIfErrorDifferent(t, err, ErrOverlappingKeySpace)
+
+	// Both objects are updated in registration order, despite the callback error
+	failIfError(t, gm.Set(context.Background(), "/o/map/1/s1/A", "3"))
+	err = s.Next(context.Background())
+	failIfErrorDifferent(t, err, cberr)
+	if !reflect.DeepEqual(order, []string{"whole", "view"}) {
+		t.Errorf("Wrong callback order %v", order)
+	}
+	if whole.M[1].A != 3 || view[1].A != 3 {
+		t.Errorf("Objects were not updated")
+	}
+
+	// Keys outside of the narrower view only reach the wider object
+	order = nil
+	failIfError(t, gm.Set(context.Background(), "/o/B", "nya"))
+	failIfErrorDifferent(t, s.Next(context.Background()), cberr)
+	if !reflect.DeepEqual(order, []string{"whole"}) {
+		t.Errorf("Wrong callback order %v", order)
+	}
+
+	// Deletions also reach every object
+	order = nil
+	failIfError(t, gm.Delete(context.Background(), "/o/map/1/"))
+	failIfErrorDifferent(t, s.Next(context.Background()), cberr)
+	if len(whole.M) != 0 || len(view) != 0 {
+		t.Errorf("Objects were not updated")
+	}
+	if len(order) != 2 {
+		t.Errorf("Wrong callback order %v", order)
+	}
+
+	// An exclusive object cannot be added over existing ones
+	failIfError(t, s.UnsyncObject("/o/map/{key}/s1/"))
+	err = s.SyncObject(SyncObject{
+		Format:    "/o/",
+		Object:    &S2{},
+		Exclusive: true,
+	})
+	failIfErrorDifferent(t, err, ErrOverlappingKeySpace)
+
+	err = s.SyncObject(SyncObject{
+		Format:    "/p/",
+		Object:    &S2{},
+		Exclusive: true,
+		Callback:  expectSyncEventCB,
+	})
+	failIfError(t, err)
+}
+
+type Edge struct {
+	From string
+	To   string
+}
+
+func TestPrevious(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	edges := make(map[string]Edge)
+	err := s.SyncObject(SyncObject{
+		Format:   "/e/{key}/",
+		Object:   &edges,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/e/x/From", "A"))
+	failIfError(t, s.Next(context.Background()))
+
+	id := ""
+	_, err = lastEvent.Value(&id).Field("From").Previous()
+	failIfErrorDifferent(t, err, ErrIsCreate)
+	if prev, err := lastEvent.Value(&id).Previous(); err != nil || prev != (Edge{}) {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+
+	failIfError(t, gm.Set(context.Background(), "/e/x/From", "B"))
+	failIfError(t, s.Next(context.Background()))
+
+	if prev, err := lastEvent.Value(&id).Field("From").Previous(); err != nil || prev != "A" {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+	if prev, err := lastEvent.Value(&id).Previous(); err != nil || prev != (Edge{From: "A"}) {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+	if prev, err := lastEvent.Previous(); err != nil || (*prev.(*map[string]Edge))["x"].From != "A" {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+	if edges["x"].From != "B" {
+		t.Errorf("Current object should not be modified")
+	}
+
+	u := lastEvent.Update()
+	if u.Key != "/e/x/From" || *u.Value != "B" || *u.Previous != "A" {
+		t.Errorf("Wrong update %v", u)
+	}
+
+	failIfError(t, gm.Delete(context.Background(), "/e/x/"))
+	failIfError(t, s.Next(context.Background()))
+
+	deleted := false
+	if prev, err := lastEvent.Value(&id).IsDeleted(&deleted).Previous(); err != nil || prev != (Edge{From: "B"}) || !deleted {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+}
+
+type S5 struct {
+	Addr *string `kvs:"addr"`
+}
+
+func TestPreviousPointer(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	st := S5{}
+	err := s.SyncObject(SyncObject{
+		Format:   "/o/",
+		Object:   &st,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/o/addr", "a"))
+	failIfError(t, s.Next(context.Background()))
+	_, err = lastEvent.Field("Addr").Previous()
+	failIfErrorDifferent(t, err, ErrIsCreate)
+
+	// Previous values of pointer fields are pointers
+	failIfError(t, gm.Set(context.Background(), "/o/addr", "b"))
+	failIfError(t, s.Next(context.Background()))
+	if prev, err := lastEvent.Field("Addr").Previous(); err != nil || *prev.(*string) != "a" {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+	if prev, err := lastEvent.Previous(); err != nil || *prev.(*S5).Addr != "a" {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+	if *st.Addr != "b" {
+		t.Errorf("Current object should not be modified")
+	}
+
+	failIfError(t, gm.Delete(context.Background(), "/o/addr"))
+	failIfError(t, s.Next(context.Background()))
+	if prev, err := lastEvent.Field("Addr").Previous(); err != nil || *prev.(*string) != "b" {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+	if prev, err := lastEvent.Previous(); err != nil || *prev.(*S5).Addr != "b" {
+		t.Errorf("Wrong previous value %v %v", prev, err)
+	}
+	if st.Addr != nil {
+		t.Errorf("Pointer was not reset")
+	}
+}
+
+type S3 struct {
+	B string
+	P *S1           `kvs:"p"`
+	M map[string]S1 `kvs:"M/{key}/"`
+}
+
+func TestDeleteField(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	st := S3{}
+	err := s.SyncObject(SyncObject{
+		Format:     "/o/",
+		Object:     &st,
+		Callback:   expectSyncEventCB,
+		PruneEmpty: true,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/o/B", "nya"))
+	failIfError(t, gm.Set(context.Background(), "/o/p", "{\"A\":1}"))
+	failIfError(t, gm.Set(context.Background(), "/o/M/a/A", "2"))
+	for i := 0; i < 3; i++ {
+		failIfError(t, s.Next(context.Background()))
+	}
+	if st.B != "nya" || st.P.A != 1 || st.M["a"].A != 2 {
+		t.Errorf("Wrong object state %v", st)
+	}
+
+	// Deleting a field resets it
+	failIfError(t, gm.Delete(context.Background(), "/o/B"))
+	failIfError(t, s.Next(context.Background()))
+	deleted := false
+	if prev, err := lastEvent.Field("B").IsDeleted(&deleted).Previous(); err != nil || prev != "nya" || !deleted {
+		t.Errorf("Wrong event %v %v %v", prev, err, deleted)
+	}
+	if st.B != "" {
+		t.Errorf("Field was not reset")
+	}
+
+	// Deleting a pointer field sets it to nil
+	failIfError(t, gm.Delete(context.Background(), "/o/p"))
+	failIfError(t, s.Next(context.Background()))
+	deleted = false
+	if err := lastEvent.Field("P").IsDeleted(&deleted).Error(); err != nil || !deleted {
+		t.Errorf("Wrong event %v %v", err, deleted)
+	}
+	if st.P != nil {
+		t.Errorf("Pointer was not reset")
+	}
+
+	// Deleting the last field of a map element removes it
+	failIfError(t, gm.Delete(context.Background(), "/o/M/a/A"))
+	failIfError(t, s.Next(context.Background()))
+	deleted = false
+	key := ""
+	if prev, err := lastEvent.Field("M").Value(&key).IsDeleted(&deleted).Previous(); err != nil || prev != (S1{A: 2}) || !deleted || key != "a" {
+		t.Errorf("Wrong event %v %v %v", prev, err, deleted)
+	}
+	if _, ok := st.M["a"]; ok {
+		t.Errorf("Map element was not pruned")
+	}
+}
+
+func TestCodecOption(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	m := make(map[string][]byte)
+	err := s.SyncObject(SyncObject{
+		Format:   "/o/{key},codec=text",
+		Object:   &m,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/o/a", "raw text"))
+	failIfError(t, s.Next(context.Background()))
+	if string(m["a"]) != "raw text" {
+		t.Errorf("Wrong value %v", m)
+	}
+}
+
+type S4 struct {
+	S1    `kvs:",inline"`
+	B     string `kvs:"b,alias=old_b"`
+	Local int    `kvs:"-"`
+}
+
+func TestTagOptions(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	st := S4{Local: 1}
+	err := s.SyncObject(SyncObject{
+		Format:   "/o/",
+		Object:   &st,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/o/A", "1"))
+	failIfError(t, s.Next(context.Background()))
+	if err := lastEvent.Field("S1").Field("A").Error(); err != nil || st.A != 1 {
+		t.Errorf("Wrong event %v %v", err, st)
+	}
+
+	failIfError(t, gm.Set(context.Background(), "/o/old_b", "legacy"))
+	failIfError(t, s.Next(context.Background()))
+	if err := lastEvent.Field("B").Error(); err != nil || st.B != "legacy" {
+		t.Errorf("Wrong event %v %v", err, st)
+	}
+
+	lastEvent = nil
+	failIfError(t, gm.Set(context.Background(), "/o/Local", "2"))
+	failIfError(t, s.Next(context.Background()))
+	if lastEvent != nil || st.Local != 1 {
+		t.Errorf("Local field should not be synchronized")
+	}
+}
+
+type Shape interface {
+	Area() int
+}
+
+type Square struct {
+	Side int
+}
+
+func (s Square) Area() int { return s.Side * s.Side }
+
+func TestInterfaceValues(t *testing.T) {
+	encoding.RegisterType("square", Square{})
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	m := make(map[string]Shape)
+	err := s.SyncObject(SyncObject{
+		Format:   "/shapes/{key}",
+		Object:   &m,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/shapes/a", "{\"type\":\"square\",\"value\":{\"Side\":3}}"))
+	failIfError(t, s.Next(context.Background()))
+	if sq, ok := m["a"].(Square); !ok || sq.Area() != 9 {
+		t.Errorf("Wrong value %v", m)
+	}
+}
+
+func TestSyncLayout(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	// Invalid formats are reported when synchronizing the object
+	m := make(map[int]S1)
+	err := s.SyncObject(SyncObject{
+		Format:   "/o/map/",
+		Object:   &m,
+		Callback: expectSyncEventCB,
+	})
+	if lerr, ok := err.(*encoding.LayoutError); !ok || lerr.Err != encoding.ErrMapFormat {
+		t.Errorf("Wrong error %v", err)
+	}
+
+	l, err := encoding.Compile("/o/map/{key}/", reflect.TypeOf(m))
+	failIfError(t, err)
+
+	err = s.SyncObject(SyncObject{
+		Format:   l,
+		Object:   &S2{},
+		Callback: expectSyncEventCB,
+	})
+	failIfErrorDifferent(t, err, encoding.ErrLayoutType)
+
+	err = s.SyncObject(SyncObject{
+		Format:   l,
+		Object:   &m,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/o/map/1/A", "2"))
+	failIfError(t, s.Next(context.Background()))
+	if m[1].A != 2 {
+		t.Errorf("Wrong value %v", m)
+	}
+
+	failIfError(t, s.UnsyncObject("/o/map/{key}/"))
+}
+
+func TestStrict(t *testing.T) {
+	gm := gomap.Create()
+
+	s := Sync{
+		Sync: gm,
+	}
+
+	lenient := S3{}
+	err := s.SyncObject(SyncObject{
+		Format:   "/o/",
+		Object:   &lenient,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+
+	strict := S3{}
+	events := 0
+	err = s.SyncObject(SyncObject{
+		Format: "/o/",
+		Object: &strict,
+		Callback: func(e *SyncEvent) error {
+			events++
+			return nil
+		},
+		Strict: true,
+	})
+	failIfError(t, err)
+
+	failIfError(t, gm.Set(context.Background(), "/o/M/a/A", "2"))
+	failIfError(t, s.Next(context.Background()))
+	if events != 1 || strict.M["a"].A != 2 || lenient.M["a"].A != 2 {
+		t.Errorf("Wrong object state %v %v", strict, lenient)
+	}
+
+	// Without ErrorCallback, the error is returned by Next
+	failIfError(t, gm.Set(context.Background(), "/o/M/a/A", "nya"))
+	err = s.Next(context.Background())
+	derr, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("Next returned %v", err)
+	}
+	if derr.Object != &strict || derr.Key != "/o/M/a/A" || derr.Value != "nya" || derr.Err == nil ||
+		!reflect.DeepEqual(derr.Fields, []interface{}{"M", "a", "A"}) {
+		t.Errorf("Wrong error %#v", derr)
+	}
+	if events != 1 || strict.M["a"].A != 2 {
+		t.Errorf("Strict object was modified %v", strict)
+	}
+	if lenient.M["a"].A != 0 {
+		t.Errorf("Lenient object was not reset %v", lenient)
+	}
+
+	// Errors are given to ErrorCallback
+	var errs []*DecodeError
+	s.ErrorCallback = func(err *DecodeError) error {
+		errs = append(errs, err)
+		return nil
+	}
+	s.Strict = true
+	failIfError(t, gm.Set(context.Background(), "/o/p", "{"))
+	failIfError(t, s.Next(context.Background()))
+	if len(errs) != 2 || errs[0].Object != &lenient || errs[1].Object != &strict {
+		t.Errorf("Wrong errors %v", errs)
+	}
+	if lenient.P != nil || strict.P != nil || events != 1 {
+		t.Errorf("Objects were modified %v %v", lenient, strict)
+	}
+
+	// Objects synchronized later are populated with valid values only
+	late := S3{}
+	err = s.SyncObject(SyncObject{
+		Format:   "/o/",
+		Object:   &late,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+	if len(errs) != 4 || errs[2].Key != "/o/M/a/A" || errs[3].Key != "/o/p" {
+		t.Errorf("Wrong errors %v", errs)
+	}
+	if late.P != nil || len(late.M) != 0 {
+		t.Errorf("Late object was modified %v", late)
+	}
+
+	stats := s.Stats()
+	if stats.Updates != 3 || stats.DecodeErrors != 5 {
+		t.Errorf("Wrong stats %+v", stats)
+	}
+
+	// Values of nested sub-objects are reported with their whole field path
+	nested := S6{}
+	err = s.SyncObject(SyncObject{
+		Format:   "/n/",
+		Object:   &nested,
+		Callback: expectSyncEventCB,
+	})
+	failIfError(t, err)
+	errs = nil
+	failIfError(t, gm.Set(context.Background(), "/n/m/x/M/a/A", "nya"))
+	failIfError(t, s.Next(context.Background()))
+	if len(errs) != 1 || errs[0].Object != &nested ||
+		!reflect.DeepEqual(errs[0].Fields, []interface{}{"M", "x", "M", "a", "A"}) {
+		t.Errorf("Wrong errors %v", errs)
+	}
+	if len(nested.M) != 0 || s.Stats().DecodeErrors != 6 {
+		t.Errorf("Wrong object state %v %+v", nested, s.Stats())
+	}
+}
+
+type S6 struct {
+	M map[string]S3 `kvs:"m/{key}/"`
+}
+
+func TestMovedTo(t *testing.T) {
+	gm := gomap.Create()
+	s := Sync{
+		Sync: gm,
+	}
+
+	o := S3{}
+	var moves [][]interface{}
+	err := s.SyncObject(SyncObject{
+		Format: "/o/",
+		Object: &o,
+		Callback: func(e *SyncEvent) error {
+			var deleted bool
+			var to []interface{}
+			e.Field("M").Value(nil).IsDeleted(&deleted).MovedTo(&to)
+			if deleted {
+				moves = append(moves, to)
+			}
+			return nil
+		},
+	})
+	failIfError(t, err)
+
+	w := S3{M: map[string]S1{"a": {A: 1}, "c": {A: 1}}}
+	failIfError(t, store.Store(gm, context.Background(), &w, "/o/", "M"))
+	for i := 0; i < 2; i++ {
+		failIfError(t, s.Next(context.Background()))
+	}
+
+	// Moves applied by a transaction are recognized
+	failIfError(t, store.Move(gm, context.Background(), &w, "/o/", []interface{}{"M", "a"}, []interface{}{"M", "b"}))
+	for i := 0; i < 2; i++ {
+		failIfError(t, s.Next(context.Background()))
+	}
+	if !reflect.DeepEqual(o.M, w.M) {
+		t.Errorf("Wrong object %v (should be %v)", o.M, w.M)
+	}
+	if !reflect.DeepEqual(moves, [][]interface{}{{"M", "b"}}) {
+		t.Errorf("Wrong moves %v", moves)
+	}
+
+	// Deletions which are not part of the same transaction are not
+	moves = nil
+	failIfError(t, gm.Set(context.Background(), "/o/M/d/A", "1"))
+	failIfError(t, gm.Delete(context.Background(), "/o/M/c/"))
+	for i := 0; i < 2; i++ {
+		failIfError(t, s.Next(context.Background()))
+	}
+	if !reflect.DeepEqual(moves, [][]interface{}{nil}) {
+		t.Errorf("Wrong moves %v", moves)
+	}
+}
+
+func TestFaultyStore(t *testing.T) {
+	gm := gomap.Create()
+	f := faulty.CreateTxn(gm, faulty.Faults{
+		Seed:          1,
+		ErrorRates:    map[faulty.Op]float64{faulty.OpNext: 0.2},
+		DuplicateRate: 0.2,
+		ReorderRate:   0.3,
+	})
+	s := Sync{
+		Sync: f,
+	}
+
+	o := S3{}
+	err := s.SyncObject(SyncObject{
+		Format:   "/o/",
+		Object:   &o,
+		Callback: func(e *SyncEvent) error { return nil },
+	})
+	failIfError(t, err)
+
+	w := S3{B: "b", P: &S1{A: 1}, M: make(map[string]S1)}
+	for i := 0; i < 10; i++ {
+		w.M[fmt.Sprintf("m%d", i)] = S1{A: i}
+	}
+	c := context.Background()
+	failIfError(t, store.Store(gm, c, &w, "/o/"))
+	failIfError(t, store.Set(gm, c, &w, "/o/", S1{A: 10}, "M", "m1"))
+	failIfError(t, store.Delete(gm, c, &w, "/o/", "M", "m2"))
+	failIfError(t, store.Move(gm, c, &w, "/o/", []interface{}{"M", "m3"}, []interface{}{"M", "m10"}))
+	failIfError(t, store.Set(gm, c, &w, "/o/", "c", "B"))
+
+	// Objects converge despite errors, duplicated and reordered updates
+	c, cancel := context.WithTimeout(c, 100*time.Millisecond)
+	defer cancel()
+	for {
+		err := s.Next(c)
+		if err != nil && !errors.Is(err, faulty.ErrInjected) {
+			break
+		}
+	}
+	if !reflect.DeepEqual(o, w) {
+		t.Errorf("Wrong object %v (should be %v)", o, w)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sync
+
+import (
+	"sort"
+	"strings"
+)
+
+// Prefix trie indexing the formats of synchronized objects.
+//
+// Each node is a key path segment. Static segments are stored by name,
+// and '{key}' segments are stored as a wildcard child matching any segment.
+// Objects are attached to the node reached by their format, such that
+// walking down a key returns every object that may own it.
+type formatTrie struct {
+	children map[string]*formatTrie
+	wildcard *formatTrie
+	objects  []int
+}
+
+// Splits a format into the segments used to index the object.
+// Format options are ignored.
+// The trailing empty segment of recursive formats is not indexed,
+// since the object owns every key below its path.
+func formatTrieSegments(format string) []string {
+	if i := strings.IndexByte(format, ','); i >= 0 {
+		// Remove format options
+		format = format[:i]
+	}
+	segments := strings.Split(format, "/")
+	if segments[len(segments)-1] == "" {
+		segments = segments[:len(segments)-1]
+	}
+	return segments
+}
+
+func (t *formatTrie) insert(format string, id int) {
+	n := t
+	for _, s := range formatTrieSegments(format) {
+		if s == "{key}" {
+			if n.wildcard == nil {
+				n.wildcard = &formatTrie{}
+			}
+			n = n.wildcard
+			continue
+		}
+		if n.children == nil {
+			n.children = make(map[string]*formatTrie)
+		}
+		child, ok := n.children[s]
+		if !ok {
+			child = &formatTrie{}
+			n.children[s] = child
+		}
+		n = child
+	}
+	n.objects = append(n.objects, id)
+}
+
+func (t *formatTrie) remove(format string, id int) {
+	n := t
+	for _, s := range formatTrieSegments(format) {
+		if s == "{key}" {
+			n = n.wildcard
+		} else {
+			n = n.children[s]
+		}
+		if n == nil {
+			return
+		}
+	}
+	for i, o := range n.objects {
+		if o == id {
+			n.objects = append(n.objects[:i], n.objects[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the objects which format is a prefix of the key, sorted by id.
+// When the key is a directory (i.e. finishes with '/'), the objects
+// which format is below the directory are also returned.
+func (t *formatTrie) lookup(key string) []int {
+	var ids []int
+	t.lookupSegments(strings.Split(key, "/"), &ids)
+	sort.Ints(ids)
+	return ids
+}
+
+func (t *formatTrie) lookupSegments(segments []string, ids *[]int) {
+	if len(segments) == 1 && segments[0] == "" {
+		// Reached the end of a directory key
+		t.collect(ids)
+		return
+	}
+	*ids = append(*ids, t.objects...)
+	if len(segments) == 0 {
+		return
+	}
+	if child, ok := t.children[segments[0]]; ok {
+		child.lookupSegments(segments[1:], ids)
+	}
+	if t.wildcard != nil && segments[0] != "" {
+		t.wildcard.lookupSegments(segments[1:], ids)
+	}
+}
+
+// Returns all the objects of the sub-trie.
+func (t *formatTrie) collect(ids *[]int) {
+	*ids = append(*ids, t.objects...)
+	for _, child := range t.children {
+		child.collect(ids)
+	}
+	if t.wildcard != nil {
+		t.wildcard.collect(ids)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2019 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"github.com/Oryon/kvsync/encoding"
+	"github.com/Oryon/kvsync/kvs/gomap"
+	"reflect"
+	"testing"
+)
+
+func testLookup(t *testing.T, trie *formatTrie, key string, expected []int) {
+	ids := trie.lookup(key)
+	if len(ids) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Lookup '%s' returned %v instead of %v", key, ids, expected)
+	}
+}
+
+func TestFormatTrie(t *testing.T) {
+	trie := &formatTrie{}
+	trie.insert("/a/", 0)
+	trie.insert("/a/b", 1)
+	trie.insert("/a/{key}/c/", 2)
+	trie.insert("/b/{key}", 3)
+	trie.insert("", 4)
+
+	testLookup(t, trie, "/a/x", []int{0, 4})
+	testLookup(t, trie, "/a/b", []int{0, 1, 4})
+	testLookup(t, trie, "/a/b/c/d", []int{0, 1, 2, 4})
+	testLookup(t, trie, "/a/x/c/d", []int{0, 2, 4})
+	testLookup(t, trie, "/a/x/d/d", []int{0, 4})
+	testLookup(t, trie, "/b/x", []int{3, 4})
+	testLookup(t, trie, "/b/", []int{3, 4})
+	testLookup(t, trie, "/a/x/", []int{0, 2, 4})
+	testLookup(t, trie, "/c/x", []int{4})
+
+	trie.remove("/a/{key}/c/", 2)
+	trie.remove("", 4)
+	testLookup(t, trie, "/a/x/c/d", []int{0})
+	testLookup(t, trie, "/c/x", nil)
+}
+
+const benchObjects = 500
+
+// Registers many objects, and fills the backend with updates spread over all objects.
+func benchSetup(b *testing.B) (*Sync, *gomap.Gomap) {
+	gm := gomap.Create()
+	s := &Sync{
+		Sync: gm,
+	}
+	for i := 0; i < benchObjects; i++ {
+		err := s.SyncObject(SyncObject{
+			Format:   fmt.Sprintf("/objects/%d/", i),
+			Object:   &S2{},
+			Callback: func(e *SyncEvent) error { return nil },
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < b.N; i++ {
+		gm.Set(context.Background(), fmt.Sprintf("/objects/%d/map/%d/s1/A", i%benchObjects, i), "1")
+	}
+	return s, gm
+}
+
+// Dispatches updates the way Next used to, trying every object in turn.
+func nextLinear(s *Sync, c context.Context) error {
+	e, err := s.Sync.Next(c)
+	if err != nil {
+		return err
+	}
+	for _, v := range s.objects {
+		fields, err := encoding.UpdateKeyObject(v.Object, v.Format, e.Key, *e.Value)
+		if err != nil {
+			continue
+		}
+		event := SyncEvent{
+			current_object: reflect.ValueOf(v.Object),
+			fields:         fields,
+		}
+		v.Callback(&event)
+	}
+	return nil
+}
+
+func BenchmarkNextLinear(b *testing.B) {
+	s, _ := benchSetup(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := nextLinear(s, context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNextTrie(b *testing.B) {
+	s, _ := benchSetup(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Next(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}